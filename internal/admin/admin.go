@@ -0,0 +1,57 @@
+package admin
+
+// Response is the JSON envelope returned by the /admin endpoints
+type Response struct {
+	Status          string // "ok", "accepted" or "error"
+	Code            string `json:",omitempty"` // machine readable error / result code
+	Message         string
+	Setting         string          `json:",omitempty"`
+	Value           any             `json:",omitempty"`
+	Old             any             `json:",omitempty"`
+	New             any             `json:",omitempty"`
+	Changes         []SettingChange `json:",omitempty"`
+	Warning         string          `json:",omitempty"`
+	Persisted       bool            `json:",omitempty"` // settings have been written to the config file
+	RestartRequired []string        `json:",omitempty"` // changed settings that are only applied on startup
+	NotPersisted    []string        `json:",omitempty"` // settings overridden by environment variables or flags that were not written to the config file
+}
+
+// SettingChange holds the old and new value of a changed setting
+type SettingChange struct {
+	Setting string
+	Old     any
+	New     any
+}
+
+// Option is a setting that can be changed at runtime
+type Option struct {
+	Name      string // name used in the admin API (/admin/settings/{name})
+	Field     string // name of the field in config.Settings
+	AllowZero bool   // numbers need to be greater than 0 otherwise
+}
+
+// Options are the settings that can be changed at runtime (see Options in openapi_admin.json)
+var Options = []Option{
+	{Name: "aur-file-location", Field: "AurFileLocation"},
+	{Name: "max-results", Field: "MaxResults"},
+	{Name: "refresh-interval", Field: "RefreshInterval"},
+	{Name: "rate-limit", Field: "RateLimit", AllowZero: true},
+	{Name: "rate-limit-cleanup-interval", Field: "RateLimitCleanupInterval"},
+	{Name: "rate-limit-time-window", Field: "RateLimitTimeWindow"},
+	{Name: "cache-cleanup-interval", Field: "CacheCleanupInterval"},
+	{Name: "cache-expiration-time", Field: "CacheExpirationTime"},
+	{Name: "enable-search-cache", Field: "EnableSearchCache"},
+	{Name: "capture-requests", Field: "CaptureRequests"},
+	{Name: "capture-sample-rate", Field: "CaptureSampleRate"},
+	{Name: "log-level", Field: "LogLevel"},
+	{Name: "access-log", Field: "AccessLog"},
+}
+
+// OptionNames returns the names of our options
+func OptionNames() []string {
+	names := make([]string, 0, len(Options))
+	for _, o := range Options {
+		names = append(names, o.Name)
+	}
+	return names
+}
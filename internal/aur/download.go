@@ -1,44 +1,104 @@
 package aur
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"errors"
 	"io"
 	"net/http"
 	"time"
+
+	"github.com/moson-mo/goaurrpc/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var client = &http.Client{
 	Timeout: 10 * time.Second,
 }
 
-// DownloadPackageData downloads package data file from AUR; decompression happens automatically
-func DownloadPackageData(address string, lastmod time.Time) ([]byte, time.Time, error) {
-	req, err := http.NewRequest("GET", address, nil)
+var tracer = tracing.Tracer("github.com/moson-mo/goaurrpc/internal/aur")
+
+// Download is a downloaded package data file
+type Download struct {
+	Data         []byte // decompressed data
+	LastModified time.Time
+	ETag         string
+	Size         int // number of bytes downloaded (as transferred, possibly compressed)
+}
+
+// DownloadPackageData downloads package data file from AUR and decompresses it if it was sent gzip encoded
+func DownloadPackageData(ctx context.Context, address string, lastmod time.Time) (*Download, error) {
+	r, body, err := download(ctx, address, lastmod)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Download{
+		Data: body,
+		ETag: r.Header.Get("ETag"),
+		Size: len(body),
+	}
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		if d.Data, err = decompress(ctx, body); err != nil {
+			return nil, err
+		}
+	}
+
+	d.LastModified, err = http.ParseTime(r.Header.Get("Last-Modified"))
+	if err != nil {
+		d.LastModified = time.Now()
+	}
+
+	return d, nil
+}
+
+// downloads the (possibly compressed) file
+func download(ctx context.Context, address string, lastmod time.Time) (*http.Response, []byte, error) {
+	ctx, span := tracer.Start(ctx, "download", trace.WithAttributes(attribute.String("url.full", address)))
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", address, nil)
 	if err != nil {
-		return nil, lastmod, err
+		return nil, nil, tracing.Error(span, err)
 	}
 	req.Header.Set("If-Modified-Since", lastmod.Format(http.TimeFormat))
+	// we decompress ourselves, so download and decompression can be measured separately
+	req.Header.Set("Accept-Encoding", "gzip")
 
 	r, err := client.Do(req)
 	if err != nil {
-		return nil, lastmod, err
+		return nil, nil, tracing.Error(span, err)
 	}
 	defer r.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", r.StatusCode))
 
 	if r.StatusCode == 304 {
 		io.Copy(io.Discard, r.Body)
-		return nil, lastmod, errors.New("not modified")
+		return nil, nil, errors.New("not modified")
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		return nil, lastmod, err
+		return nil, nil, tracing.Error(span, err)
 	}
+	span.SetAttributes(attribute.Int("http.response.body.size", len(body)))
+	return r, body, nil
+}
+
+func decompress(ctx context.Context, b []byte) ([]byte, error) {
+	_, span := tracer.Start(ctx, "decompress")
+	defer span.End()
 
-	newmod, err := http.ParseTime(r.Header.Get("Last-Modified"))
+	gz, err := gzip.NewReader(bytes.NewReader(b))
 	if err != nil {
-		newmod = time.Now()
+		return nil, tracing.Error(span, err)
 	}
-
-	return body, newmod, nil
+	b, err = io.ReadAll(gz)
+	if err != nil {
+		return nil, tracing.Error(span, err)
+	}
+	return b, nil
 }
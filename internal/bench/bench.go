@@ -0,0 +1,251 @@
+package bench
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	goaurrpc bench sends requests to a goaurrpc (or aurweb) instance and reports latencies and throughput.
+
+	closed-loop: a fixed number of workers send requests one after another.
+	open-loop:   requests are started at a fixed rate, no matter how long previous ones take.
+	             Latencies are measured from the time a request was scheduled, so a slow server can't hide queueing delays.
+*/
+
+// Modes
+const (
+	ModeClosed = "closed-loop"
+	ModeOpen   = "open-loop"
+)
+
+// number of requests we generate for synthetic benchmarks. They are sent in a loop
+const syntheticRequests = 10000
+
+type options struct {
+	target      string
+	concurrency int
+	rate        float64 // requests per second; open-loop if > 0
+	requests    int     // stop after this number of requests (0 = no limit)
+	duration    time.Duration
+	maxInFlight int
+}
+
+// the outcome of a single request
+type result struct {
+	kind    string
+	status  int // 0 if the request failed
+	failed  bool
+	latency time.Duration
+	bytes   int64
+}
+
+// Run executes goaurrpc bench with the given arguments and returns the exit code:
+// 0 on success, 1 on errors or if -max-p99 was exceeded and 2 for invalid arguments
+func Run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("goaurrpc bench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	logFile := fs.String("log", "", "Request log `file` to replay (JSONL). A synthetic mix of requests is sent if empty")
+	mixFlag := fs.String("mix", "info=60,search=25,suggest=15", "Synthetic request `mix` (type=weight)")
+	concurrency := fs.Int("c", 10, "Number of workers (closed-loop)")
+	rate := fs.Float64("rate", 0, "Requests per second. Switches to open-loop mode if set")
+	maxInFlight := fs.Int("max-in-flight", 1000, "Maximum number of concurrent requests (open-loop). Requests exceeding it are dropped")
+	requests := fs.Int("n", 0, "Stop after this number of requests (0 = no limit)")
+	duration := fs.Duration("d", 10*time.Second, "Stop after this `duration` (0 = no limit)")
+	timeout := fs.Duration("timeout", 10*time.Second, "Request timeout")
+	seed := fs.Int64("seed", 1, "Random seed for synthetic requests")
+	maxP99 := fs.Duration("max-p99", 0, "Exit with 1 if the 99th percentile latency exceeds this `duration`")
+	output := fs.String("o", OutputTable, "Output `format` (table or json)")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: goaurrpc bench [flags] <url>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	opts := options{
+		target:      strings.TrimRight(fs.Arg(0), "/"),
+		concurrency: *concurrency,
+		rate:        *rate,
+		requests:    *requests,
+		duration:    *duration,
+		maxInFlight: *maxInFlight,
+	}
+	var err error
+	switch {
+	case fs.NArg() != 1:
+		err = errors.New("target URL missing")
+	case opts.requests == 0 && opts.duration == 0:
+		err = errors.New("-n or -d needs to be set")
+	case opts.rate == 0 && opts.concurrency < 1, opts.rate > 0 && opts.maxInFlight < 1:
+		err = errors.New("-c and -max-in-flight need to be at least 1")
+	case opts.rate < 0:
+		err = errors.New("-rate can not be negative")
+	case *output != OutputTable && *output != OutputJSON:
+		err = errors.New("invalid output format '" + *output + "'. Use table or json")
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		fs.Usage()
+		return 2
+	}
+
+	client := &http.Client{
+		Timeout: *timeout,
+		Transport: &http.Transport{
+			MaxIdleConns:        opts.concurrency + opts.maxInFlight,
+			MaxIdleConnsPerHost: opts.concurrency + opts.maxInFlight,
+		},
+	}
+
+	// requests we send
+	var reqs []request
+	if *logFile != "" {
+		reqs, err = loadLogFile(*logFile)
+	} else {
+		var mix map[string]int
+		var names []string
+		if mix, err = parseMix(*mixFlag); err == nil {
+			if names, err = fetchNames(client, opts.target); err == nil {
+				reqs, err = synthetic(mix, names, syntheticRequests, rand.New(rand.NewSource(*seed)))
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+
+	report := run(client, opts, reqs)
+	if err = report.write(stdout, *output); err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+
+	if *maxP99 > 0 && report.Latency.P99 > ms(*maxP99) {
+		fmt.Fprintf(stderr, "error: 99th percentile latency %.2fms exceeds %s\n", report.Latency.P99, *maxP99)
+		return 1
+	}
+	return 0
+}
+
+// sends requests and returns our report
+func run(client *http.Client, opts options, reqs []request) *Report {
+	var (
+		mut     sync.Mutex
+		results []result
+		dropped int
+		wg      sync.WaitGroup
+	)
+	collect := func(res result) {
+		mut.Lock()
+		results = append(results, res)
+		mut.Unlock()
+	}
+
+	start := time.Now()
+	done := func(i int, t time.Time) bool {
+		return opts.requests > 0 && i >= opts.requests || opts.duration > 0 && t.Sub(start) >= opts.duration
+	}
+
+	if opts.rate > 0 {
+		interval := time.Duration(float64(time.Second) / opts.rate)
+		inFlight := make(chan struct{}, opts.maxInFlight)
+		for i := 0; ; i++ {
+			scheduled := start.Add(time.Duration(i) * interval)
+			if done(i, scheduled) {
+				break
+			}
+			time.Sleep(time.Until(scheduled))
+
+			select {
+			case inFlight <- struct{}{}:
+			default:
+				dropped++
+				continue
+			}
+			wg.Add(1)
+			go func(req request) {
+				defer wg.Done()
+				collect(send(client, opts.target, req, scheduled))
+				<-inFlight
+			}(reqs[i%len(reqs)])
+		}
+	} else {
+		var next int64 = -1
+		for w := 0; w < opts.concurrency; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					i := int(atomic.AddInt64(&next, 1))
+					now := time.Now()
+					if done(i, now) {
+						return
+					}
+					collect(send(client, opts.target, reqs[i%len(reqs)], now))
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	mode := fmt.Sprintf("%s (%d workers)", ModeClosed, opts.concurrency)
+	if opts.rate > 0 {
+		mode = fmt.Sprintf("%s (%g req/s)", ModeOpen, opts.rate)
+	}
+	return newReport(mode, results, dropped, time.Since(start))
+}
+
+// sends a request. The latency is measured from start
+func send(client *http.Client, target string, req request, start time.Time) result {
+	res := result{kind: req.kind}
+
+	var body io.Reader
+	if req.body != "" {
+		body = strings.NewReader(req.body)
+	}
+	hreq, err := http.NewRequest(req.method, target+req.uri, body)
+	if err != nil {
+		res.failed = true
+		return res
+	}
+	if body != nil {
+		hreq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	hres, err := client.Do(hreq)
+	if err != nil {
+		res.failed = true
+		res.latency = time.Since(start)
+		return res
+	}
+	b, err := io.ReadAll(hres.Body)
+	hres.Body.Close()
+	res.latency = time.Since(start)
+	res.status = hres.StatusCode
+	res.bytes = int64(len(b))
+
+	switch {
+	case hres.StatusCode == http.StatusTooManyRequests:
+	case err != nil, hres.StatusCode >= 400:
+		res.failed = true
+	case bytes.Contains(b, []byte(`"type":"error"`)):
+		// v5 errors are returned with status 200
+		res.failed = true
+	}
+	return res
+}
@@ -0,0 +1,164 @@
+package bench
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/moson-mo/goaurrpc/internal/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+// a fake rpc endpoint. Every 4th request is rate limited
+func testServer(t *testing.T) (*httptest.Server, *int64) {
+	var count int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&count, 1)
+		r.ParseForm()
+		switch {
+		case r.Form.Get("type") == "suggest":
+			w.Write([]byte(`["` + r.Form.Get("arg") + `pkg"]`))
+		case r.Form.Get("arg") == "broken":
+			w.Write([]byte(`{"error":"Incorrect by field specified.","resultcount":0,"results":[],"type":"error","version":5}`))
+		case n%4 == 0:
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(`{"resultcount":0,"results":[],"type":"multiinfo","version":5}`))
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &count
+}
+
+func TestLoadLog(t *testing.T) {
+	reqs, err := loadLog(strings.NewReader(`/rpc?v=5&type=info&arg=yay
+
+{"Method": "POST", "Path": "/rpc", "Params": {"v": ["5"], "type": ["search"], "arg": ["ya"]}, "Status": 200}
+{"Path": "/rpc/v5/suggest/ya"}
+{"Method": "get", "Path": "/rpc?v=5", "Params": {"type": ["info"]}}
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, []request{
+		{method: "GET", uri: "/rpc?arg=yay&type=info&v=5", kind: "info"},
+		{method: "POST", uri: "/rpc", body: "arg=ya&type=search&v=5", kind: "search"},
+		{method: "GET", uri: "/rpc/v5/suggest/ya", kind: "suggest"},
+		{method: "GET", uri: "/rpc?type=info&v=5", kind: "info"},
+	}, reqs)
+
+	_, err = loadLog(strings.NewReader("{broken\n"))
+	assert.EqualError(t, err, "line 1: "+jsonError("{broken"))
+	_, err = loadLog(strings.NewReader("\n"))
+	assert.EqualError(t, err, "request log is empty")
+}
+
+// files written by request capture can be replayed
+func TestLoadCapture(t *testing.T) {
+	b, err := json.Marshal(rpc.CaptureRecord{
+		Time:    time.Now(),
+		Method:  "POST",
+		Path:    "/rpc",
+		Params:  url.Values{"v": {"5"}, "type": {"search"}, "arg": {"ya"}},
+		Client:  "0123456789abcdef",
+		Status:  200,
+		Size:    100,
+		Latency: 0.5,
+	})
+	assert.Nil(t, err)
+	reqs, err := loadLog(bytes.NewReader(b))
+	assert.Nil(t, err)
+	assert.Equal(t, []request{{method: "POST", uri: "/rpc", body: "arg=ya&type=search&v=5", kind: "search"}}, reqs)
+}
+
+func jsonError(s string) string {
+	var rec Record
+	return json.Unmarshal([]byte(s), &rec).Error()
+}
+
+func TestMix(t *testing.T) {
+	mix, err := parseMix("info=2, search=1,suggest=0")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"info": 2, "search": 1, "suggest": 0}, mix)
+
+	for _, invalid := range []string{"info", "info=x", "info=-1", "msearch=1"} {
+		_, err = parseMix(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+
+	reqs, err := synthetic(mix, []string{"yay", "paru"}, 300, rand.New(rand.NewSource(1)))
+	assert.Nil(t, err)
+	assert.Len(t, reqs, 300)
+	kinds := map[string]int{}
+	for _, req := range reqs {
+		kinds[req.kind]++
+		assert.True(t, strings.HasPrefix(req.uri, "/rpc?"), req.uri)
+	}
+	assert.Zero(t, kinds["suggest"])
+	assert.InDelta(t, 200, kinds["info"], 30)
+
+	_, err = synthetic(mix, nil, 1, rand.New(rand.NewSource(1)))
+	assert.NotNil(t, err)
+	_, err = synthetic(map[string]int{"info": 0}, []string{"yay"}, 1, rand.New(rand.NewSource(1)))
+	assert.NotNil(t, err)
+}
+
+func TestPercentile(t *testing.T) {
+	var l []time.Duration
+	for i := 100; i > 0; i-- {
+		l = append(l, time.Duration(i)*time.Millisecond)
+	}
+	lat := latency(l)
+	assert.Equal(t, Latency{Min: 1, Mean: 50.5, P50: 50, P90: 90, P95: 95, P99: 99, Max: 100}, lat)
+	assert.Equal(t, Latency{}, latency(nil))
+	assert.Equal(t, time.Second, percentile([]time.Duration{time.Second}, 99))
+}
+
+func TestRun(t *testing.T) {
+	ts, count := testServer(t)
+
+	// closed-loop, synthetic requests
+	var stdout, stderr bytes.Buffer
+	code := Run([]string{"-n", "100", "-c", "4", "-o", "json", ts.URL}, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	var report Report
+	assert.Nil(t, json.Unmarshal(stdout.Bytes(), &report))
+	assert.Equal(t, 100, report.Requests)
+	assert.Equal(t, 100, report.Success+report.Errors+report.RateLimited)
+	assert.NotZero(t, report.RateLimited)
+	assert.Zero(t, report.Errors)
+	assert.Equal(t, report.RateLimited, report.StatusCodes["429"])
+	assert.Contains(t, report.Mode, ModeClosed)
+	assert.Equal(t, int64(126), atomic.LoadInt64(count)) // 26 suggest requests for package names
+
+	// open-loop, request log
+	log := filepath.Join(t.TempDir(), "requests.jsonl")
+	os.WriteFile(log, []byte("/rpc?v=5&type=info&arg=yay\n/rpc?v=5&type=search&arg=broken\n"), 0600)
+	stdout.Reset()
+	code = Run([]string{"-log", log, "-rate", "200", "-d", "250ms", ts.URL}, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	out := stdout.String()
+	assert.Contains(t, out, "Mode:          open-loop (200 req/s)")
+	assert.Contains(t, out, "Requests:      50 (")
+	assert.Regexp(t, `search\s+25\s+25\s`, out)
+	assert.Regexp(t, `all\s+50\s+25\s`, out)
+
+	// latency threshold
+	code = Run([]string{"-log", log, "-n", "2", "-max-p99", "1ns", ts.URL}, &bytes.Buffer{}, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "error: 99th percentile latency")
+
+	// invalid arguments
+	for _, args := range [][]string{{}, {"-d", "0", ts.URL}, {"-c", "0", ts.URL}, {"-rate", "-1", ts.URL}, {"-o", "yaml", ts.URL}} {
+		assert.Equal(t, 2, Run(args, &bytes.Buffer{}, &bytes.Buffer{}), args)
+	}
+	assert.Equal(t, 1, Run([]string{"-log", "doesnotexist", ts.URL}, &bytes.Buffer{}, &bytes.Buffer{}))
+	assert.Equal(t, 1, Run([]string{"-mix", "x=1", ts.URL}, &bytes.Buffer{}, &bytes.Buffer{}))
+}
@@ -0,0 +1,189 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Output formats
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+// Report holds the results of a benchmark
+type Report struct {
+	Mode        string
+	Requests    int
+	Success     int
+	Errors      int     // failed requests, status codes >= 400 (except 429) and error results
+	RateLimited int     // status 429
+	Dropped     int     // requests not sent because -max-in-flight was reached (open-loop)
+	Duration    float64 // in seconds
+	Throughput  float64 // requests per second
+	Bytes       int64
+	StatusCodes map[string]int
+	Latency     Latency
+	Types       map[string]TypeReport
+}
+
+// TypeReport holds the results for a request type
+type TypeReport struct {
+	Requests int
+	Errors   int
+	Latency  Latency
+}
+
+// Latency statistics in milliseconds
+type Latency struct {
+	Min  float64
+	Mean float64
+	P50  float64
+	P90  float64
+	P95  float64
+	P99  float64
+	Max  float64
+}
+
+func newReport(mode string, results []result, dropped int, elapsed time.Duration) *Report {
+	r := &Report{
+		Mode:        mode,
+		Requests:    len(results),
+		Dropped:     dropped,
+		Duration:    elapsed.Seconds(),
+		StatusCodes: map[string]int{},
+		Types:       map[string]TypeReport{},
+	}
+	if elapsed > 0 {
+		r.Throughput = float64(len(results)) / elapsed.Seconds()
+	}
+
+	var all []time.Duration
+	byType := map[string][]time.Duration{}
+	for _, res := range results {
+		tr := r.Types[res.kind]
+		tr.Requests++
+		switch {
+		case res.failed:
+			r.Errors++
+			tr.Errors++
+		case res.status == http.StatusTooManyRequests:
+			r.RateLimited++
+		default:
+			r.Success++
+		}
+		r.Types[res.kind] = tr
+
+		status := "failed"
+		if res.status != 0 {
+			status = strconv.Itoa(res.status)
+		}
+		r.StatusCodes[status]++
+		r.Bytes += res.bytes
+		all = append(all, res.latency)
+		byType[res.kind] = append(byType[res.kind], res.latency)
+	}
+
+	r.Latency = latency(all)
+	for kind, l := range byType {
+		tr := r.Types[kind]
+		tr.Latency = latency(l)
+		r.Types[kind] = tr
+	}
+	return r
+}
+
+// computes latency statistics
+func latency(l []time.Duration) Latency {
+	if len(l) == 0 {
+		return Latency{}
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+
+	var sum time.Duration
+	for _, d := range l {
+		sum += d
+	}
+	return Latency{
+		Min:  ms(l[0]),
+		Mean: ms(sum / time.Duration(len(l))),
+		P50:  ms(percentile(l, 50)),
+		P90:  ms(percentile(l, 90)),
+		P95:  ms(percentile(l, 95)),
+		P99:  ms(percentile(l, 99)),
+		Max:  ms(l[len(l)-1]),
+	}
+}
+
+// returns the p-th percentile of sorted durations (nearest rank)
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// writes our report in the given format
+func (r *Report) write(w io.Writer, output string) error {
+	if output == OutputJSON {
+		b, err := json.MarshalIndent(r, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(b, '\n'))
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Mode:\t%s\n", r.Mode)
+	fmt.Fprintf(tw, "Requests:\t%d (%d ok, %d errors, %d rate limited, %d dropped)\n", r.Requests, r.Success, r.Errors, r.RateLimited, r.Dropped)
+	fmt.Fprintf(tw, "Duration:\t%.3fs\n", r.Duration)
+	fmt.Fprintf(tw, "Throughput:\t%.2f req/s\n", r.Throughput)
+	fmt.Fprintf(tw, "Transferred:\t%d bytes\n", r.Bytes)
+
+	codes := make([]string, 0, len(r.StatusCodes))
+	for code := range r.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	fmt.Fprintf(tw, "Status codes:\t")
+	for i, code := range codes {
+		if i > 0 {
+			fmt.Fprint(tw, ", ")
+		}
+		fmt.Fprintf(tw, "%s: %d", code, r.StatusCodes[code])
+	}
+	fmt.Fprint(tw, "\n\nLatency (ms):\n")
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "TYPE\tREQUESTS\tERRORS\tMIN\tMEAN\tP50\tP90\tP95\tP99\tMAX\t")
+	row := func(name string, requests, errors int, l Latency) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t\n",
+			name, requests, errors, l.Min, l.Mean, l.P50, l.P90, l.P95, l.P99, l.Max)
+	}
+	types := make([]string, 0, len(r.Types))
+	for kind := range r.Types {
+		types = append(types, kind)
+	}
+	sort.Strings(types)
+	for _, kind := range types {
+		row(kind, r.Types[kind].Requests, r.Types[kind].Errors, r.Types[kind].Latency)
+	}
+	row("all", r.Requests, r.Errors, r.Latency)
+	return tw.Flush()
+}
@@ -0,0 +1,209 @@
+package bench
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+/*
+	Request logs are JSONL files; each line holds one request:
+
+	{"Method": "GET", "Path": "/rpc", "Params": {"v": ["5"], "type": ["info"], "arg[]": ["yay"]}}
+
+	Other fields are ignored, so request capture files (CaptureFile) can be replayed directly.
+	Lines that are not JSON objects are treated as request URIs: /rpc?v=5&type=suggest&arg=ya
+*/
+
+// Record is a line of a request log
+type Record struct {
+	Method string
+	Path   string
+	Params url.Values `json:",omitempty"`
+}
+
+// a request we send to the target
+type request struct {
+	method string
+	uri    string
+	body   string // form data for POST requests
+	kind   string // request type for our report
+}
+
+func newRequest(rec Record) (request, error) {
+	u, err := url.Parse(rec.Path)
+	if err != nil {
+		return request{}, err
+	}
+	params := u.Query()
+	for k, v := range rec.Params {
+		params[k] = append(params[k], v...)
+	}
+	u.RawQuery = ""
+
+	req := request{method: strings.ToUpper(rec.Method), kind: requestKind(u.Path, params)}
+	switch req.method {
+	case "":
+		req.method = http.MethodGet
+		fallthrough
+	case http.MethodGet:
+		req.uri = u.String()
+		if len(params) > 0 {
+			req.uri += "?" + params.Encode()
+		}
+	default:
+		req.uri = u.String()
+		req.body = params.Encode()
+	}
+	return req, nil
+}
+
+// returns the request type like "info" or "search". Types can be passed as parameter or in the path (/rpc/v5/info/...)
+func requestKind(path string, params url.Values) string {
+	if t := params.Get("type"); t != "" {
+		return t
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) >= 3 && (parts[0] == "rpc" || parts[0] == "api") {
+		return parts[2]
+	}
+	return "other"
+}
+
+// reads a request log
+func loadLog(r io.Reader) ([]request, error) {
+	var requests []request
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+
+		rec := Record{Path: text}
+		if strings.HasPrefix(text, "{") {
+			rec = Record{}
+			if err := json.Unmarshal([]byte(text), &rec); err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err)
+			}
+		}
+		req, err := newRequest(rec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		requests = append(requests, req)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(requests) == 0 {
+		return nil, errors.New("request log is empty")
+	}
+	return requests, nil
+}
+
+func loadLogFile(path string) ([]request, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return loadLog(f)
+}
+
+// parses a request mix like "info=60,search=25,suggest=15"
+func parseMix(s string) (map[string]int, error) {
+	mix := map[string]int{}
+	for _, part := range strings.Split(s, ",") {
+		kind, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		w, err := strconv.Atoi(weight)
+		if !ok || err != nil || w < 0 {
+			return nil, errors.New("invalid mix '" + part + "'. Use type=weight like info=60")
+		}
+		switch kind {
+		case "info", "search", "suggest":
+			mix[kind] = w
+		default:
+			return nil, errors.New("invalid request type '" + kind + "' in mix. Use info, search or suggest")
+		}
+	}
+	return mix, nil
+}
+
+// generates n requests for a mix of request types with arguments taken from the given package names
+func synthetic(mix map[string]int, names []string, n int, rng *rand.Rand) ([]request, error) {
+	if len(names) == 0 {
+		return nil, errors.New("no package names for generating requests")
+	}
+	kinds := make([]string, 0, len(mix))
+	total := 0
+	for kind, w := range mix {
+		kinds = append(kinds, kind)
+		total += w
+	}
+	if total == 0 {
+		return nil, errors.New("request mix is empty")
+	}
+	sort.Strings(kinds)
+
+	requests := make([]request, 0, n)
+	for i := 0; i < n; i++ {
+		pick := rng.Intn(total)
+		kind := kinds[0]
+		for _, k := range kinds {
+			if pick < mix[k] {
+				kind = k
+				break
+			}
+			pick -= mix[k]
+		}
+
+		name := names[rng.Intn(len(names))]
+		params := url.Values{"v": {"5"}, "type": {kind}}
+		switch kind {
+		case "info":
+			params.Set("arg[]", name)
+		default:
+			params.Set("arg", prefix(name, 2+rng.Intn(3)))
+		}
+		requests = append(requests, request{method: http.MethodGet, uri: "/rpc?" + params.Encode(), kind: kind})
+	}
+	return requests, nil
+}
+
+func prefix(s string, n int) string {
+	if len(s) < n {
+		return s
+	}
+	return s[:n]
+}
+
+// fetches package names from the target with suggest queries
+func fetchNames(client *http.Client, target string) ([]string, error) {
+	var names []string
+	for c := 'a'; c <= 'z'; c++ {
+		res, err := client.Get(target + "/rpc?v=5&type=suggest&arg=" + string(c))
+		if err != nil {
+			return nil, err
+		}
+		var found []string
+		err = json.NewDecoder(res.Body).Decode(&found)
+		res.Body.Close()
+		if err != nil {
+			return nil, errors.New("fetching package names: " + res.Status)
+		}
+		names = append(names, found...)
+	}
+	return names, nil
+}
@@ -0,0 +1,278 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Severity of a problem with our settings
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Problem is an issue with one of our settings
+type Problem struct {
+	Severity Severity
+	Setting  string
+	Message  string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%-8s %s: %s", string(p.Severity)+":", p.Setting, p.Message)
+}
+
+// the default admin API key from our sample config
+const defaultAdminAPIKey = "change-me"
+
+// admin API keys shorter than this are considered weak
+const minAdminAPIKeyLength = 16
+
+// CheckFile returns problems of settings that depend on the config file they have been loaded from
+func (s *Settings) CheckFile(path string) []Problem {
+	if s.PersistSettings && path != "" && FormatOf(path) != FormatJSON {
+		return []Problem{{SeverityError, "PersistSettings", "is only supported with JSON config files"}}
+	}
+	return nil
+}
+
+// Check validates our settings in depth and returns every problem that was found:
+// Files and key pairs must be loadable, URLs parseable, ports free and values not risky
+func (s *Settings) Check() []Problem {
+	problems := s.basicProblems()
+	add := func(severity Severity, setting, format string, a ...any) {
+		problems = append(problems, Problem{severity, setting, fmt.Sprintf(format, a...)})
+	}
+
+	// port
+	if s.Port < 0 || s.Port > 65535 {
+		add(SeverityError, "Port", "%d is not a valid port number", s.Port)
+	} else if s.Port > 0 && len(s.ListenAddresses) == 0 {
+		l, err := net.Listen("tcp", ":"+strconv.Itoa(s.Port))
+		if err != nil {
+			add(SeverityError, "Port", "port %d is not available: %s", s.Port, err)
+		} else {
+			l.Close()
+		}
+	}
+
+	// listen addresses (syntax is checked in basicProblems)
+	for _, setting := range []struct {
+		name      string
+		addresses []string
+	}{
+		{"ListenAddresses", s.ListenAddresses},
+		{"AdminListenAddresses", s.AdminListenAddresses},
+	} {
+		for _, addr := range setting.addresses {
+			if path, ok := strings.CutPrefix(addr, UnixPrefix); ok {
+				if err := checkDir(filepath.Dir(path)); err != nil {
+					add(SeverityError, setting.name, "can not create socket '%s': %s", path, err)
+				}
+				continue
+			}
+			if checkListenAddress(addr) != nil {
+				continue
+			}
+			l, err := net.Listen("tcp", addr)
+			if err != nil {
+				add(SeverityError, setting.name, "'%s' is not available: %s", addr, err)
+			} else {
+				l.Close()
+			}
+		}
+	}
+	if s.EnablePprof && len(s.AdminListenAddresses) == 0 {
+		add(SeverityWarning, "EnablePprof", "profiles are only served on the admin listener; AdminListenAddresses is not set")
+	}
+	if s.SocketOwner != "" {
+		if _, _, err := LookupOwner(s.SocketOwner); err != nil {
+			add(SeverityError, "SocketOwner", "%s", err)
+		}
+	}
+
+	// package data
+	u, urlErr := url.Parse(s.AurFileLocation)
+	isURL := urlErr == nil && (u.Scheme == "http" || u.Scheme == "https")
+	switch {
+	case s.AurFileLocation == "":
+		add(SeverityError, "AurFileLocation", "needs to be specified")
+	case s.LoadFromFile && isURL:
+		add(SeverityError, "AurFileLocation", "is a URL but LoadFromFile is set")
+	case s.LoadFromFile:
+		if err := checkReadable(s.AurFileLocation); err != nil {
+			add(SeverityError, "AurFileLocation", "file is not readable: %s", err)
+		}
+	case urlErr != nil:
+		add(SeverityError, "AurFileLocation", "is not a valid URL: %s", urlErr)
+	case !isURL || u.Host == "":
+		if _, err := os.Stat(s.AurFileLocation); err == nil {
+			add(SeverityError, "LoadFromFile", "needs to be set when AurFileLocation is a local file")
+		} else {
+			add(SeverityError, "AurFileLocation", "is not a valid http(s) URL")
+		}
+	case s.RefreshInterval > 0 && s.RefreshInterval < 60:
+		add(SeverityWarning, "RefreshInterval", "data is downloaded every %d seconds", s.RefreshInterval)
+	}
+
+	// TLS
+	if s.EnableSSL {
+		problems = append(problems, checkKeyPair(s.CertFile, s.KeyFile)...)
+		if s.TLSMinVersion == "1.0" || s.TLSMinVersion == "1.1" {
+			add(SeverityWarning, "TLSMinVersion", "TLS %s is deprecated and insecure", s.TLSMinVersion)
+		}
+		for _, name := range s.TLSCipherSuites {
+			for _, suite := range tls.InsecureCipherSuites() {
+				if suite.Name == name {
+					add(SeverityWarning, "TLSCipherSuites", "'%s' is insecure", name)
+				}
+			}
+		}
+		if s.AdminClientCAFile != "" {
+			if _, err := LoadCertPool(s.AdminClientCAFile); err != nil {
+				add(SeverityError, "AdminClientCAFile", "can not be loaded: %s", err)
+			}
+		}
+	}
+	switch {
+	case s.HTTPRedirectPort <= 0 || s.HTTPRedirectPort > 65535: // disabled or checked in basicProblems
+	case s.HTTPRedirectPort == s.Port && len(s.ListenAddresses) == 0:
+		add(SeverityError, "HTTPRedirectPort", "is the same as Port")
+	default:
+		l, err := net.Listen("tcp", ":"+strconv.Itoa(s.HTTPRedirectPort))
+		if err != nil {
+			add(SeverityError, "HTTPRedirectPort", "port %d is not available: %s", s.HTTPRedirectPort, err)
+		} else {
+			l.Close()
+		}
+	}
+
+	// tracing
+	if s.EnableTracing && s.TracingEndpoint != "" {
+		if u, err := url.Parse(s.TracingEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(SeverityError, "TracingEndpoint", "is not a valid http(s) URL")
+		}
+	}
+
+	// server limits
+	if s.ReadHeaderTimeout == 0 && s.ReadTimeout == 0 {
+		add(SeverityWarning, "ReadHeaderTimeout", "clients can keep connections open indefinitely without sending a request")
+	}
+
+	// trusted proxies
+	for _, ip := range s.TrustedReverseProxies {
+		if net.ParseIP(ip) == nil {
+			add(SeverityError, "TrustedReverseProxies", "'%s' is not a valid IP address", ip)
+		}
+	}
+
+	// files we write to
+	if s.LogFile != "" {
+		if err := checkWritable(s.LogFile); err != nil {
+			add(SeverityError, "LogFile", "file is not writable: %s", err)
+		}
+	}
+	if s.AccessLog && s.AccessLogFile != "" {
+		if err := checkWritable(s.AccessLogFile); err != nil {
+			add(SeverityError, "AccessLogFile", "file is not writable: %s", err)
+		}
+	}
+	if s.EnableApiKeys && s.ApiKeyFile != "" {
+		if err := checkWritable(s.ApiKeyFile); err != nil {
+			add(SeverityError, "ApiKeyFile", "file is not writable: %s", err)
+		}
+	}
+	if s.CaptureRequests && s.CaptureFile != "" {
+		if err := checkWritable(s.CaptureFile); err != nil {
+			add(SeverityError, "CaptureFile", "file is not writable: %s", err)
+		}
+	}
+
+	// risky values
+	if s.RateLimit == 0 {
+		add(SeverityWarning, "RateLimit", "rate limiting is disabled")
+	}
+	if s.EnableAdminApi {
+		switch {
+		case s.AdminAPIKey == "":
+			add(SeverityError, "AdminAPIKey", "is empty while EnableAdminApi is set")
+		case s.AdminAPIKey == defaultAdminAPIKey:
+			add(SeverityError, "AdminAPIKey", "default key '%s' is used while EnableAdminApi is set", defaultAdminAPIKey)
+		case len(s.AdminAPIKey) < minAdminAPIKeyLength:
+			add(SeverityWarning, "AdminAPIKey", "key is weak (less than %d characters)", minAdminAPIKeyLength)
+		}
+	}
+	if s.CacheExpirationTime > 0 && s.CacheCleanupInterval > s.CacheExpirationTime {
+		add(SeverityWarning, "CacheCleanupInterval", "is greater than CacheExpirationTime; entries stay in the cache longer than expected")
+	}
+
+	return problems
+}
+
+// checks if TLS certificate and key can be loaded
+func checkKeyPair(certFile, keyFile string) []Problem {
+	if certFile == "" || keyFile == "" {
+		return []Problem{{SeverityError, "CertFile", "CertFile and KeyFile need to be specified when EnableSSL is set"}}
+	}
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return []Problem{{SeverityError, "CertFile", "key pair can not be loaded: " + err.Error()}}
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return []Problem{{SeverityError, "CertFile", "certificate can not be parsed: " + err.Error()}}
+	}
+
+	switch {
+	case time.Now().After(cert.NotAfter):
+		return []Problem{{SeverityError, "CertFile", "certificate expired at " + cert.NotAfter.Format(time.RFC3339)}}
+	case time.Until(cert.NotAfter) < 14*24*time.Hour:
+		return []Problem{{SeverityWarning, "CertFile", "certificate expires at " + cert.NotAfter.Format(time.RFC3339)}}
+	}
+	return nil
+}
+
+// checks if a file can be opened for reading
+func checkReadable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// checks if a file can be written. Files that don't exist yet need an existing directory
+func checkWritable(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err == nil {
+		return f.Close()
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	return checkDir(filepath.Dir(path))
+}
+
+// checks if dir exists and is a directory
+func checkDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/goccy/go-json"
+	toml "github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+	Config files can be written in JSON, YAML or TOML. The format is picked from the file extension.
+	Keys are the names of our settings (matched case-insensitively) in all formats.
+	Unknown keys are rejected.
+*/
+
+// Format is the format of a config file
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+// FormatOf returns the format of a config file. Files without .yaml, .yml or .toml extension are JSON
+func FormatOf(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// ParseFormat returns the format for a name like "yaml"
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "json":
+		return FormatJSON, nil
+	case "yaml", "yml":
+		return FormatYAML, nil
+	case "toml":
+		return FormatTOML, nil
+	}
+	return "", errors.New("config: unknown format '" + name + "'. Use json, yaml or toml")
+}
+
+// decodes the contents of a config file into s
+func decode(b []byte, f Format, s *Settings) error {
+	switch f {
+	case FormatYAML:
+		return decodeYAML(b, s)
+	case FormatTOML:
+		return decodeTOML(b, s)
+	}
+	return decodeJSON(b, s)
+}
+
+func decodeJSON(b []byte, s *Settings) error {
+	// check for unknown keys first, so we can report the line
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if t, err := dec.Token(); err != nil {
+		return err
+	} else if d, ok := t.(json.Delim); !ok || d != '{' {
+		return errors.New("config: file does not contain a JSON object")
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if key, ok := t.(string); ok && settingsField(key) == "" {
+			line := bytes.Count(b[:dec.InputOffset()], []byte("\n")) + 1
+			return errUnknownSetting(line, key)
+		}
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return err
+		}
+	}
+
+	return json.Unmarshal(b, s)
+}
+
+func decodeYAML(b []byte, s *Settings) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config: line %d: file does not contain a YAML mapping", root.Line)
+	}
+
+	// values are converted to JSON, so they are treated exactly like in a JSON file
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		field := settingsField(key.Value)
+		if field == "" {
+			return errUnknownSetting(key.Line, key.Value)
+		}
+
+		var val any
+		if err := value.Decode(&val); err != nil {
+			return fmt.Errorf("config: line %d: %s", value.Line, err)
+		}
+		jb, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Errorf("config: line %d: %s", value.Line, err)
+		}
+		fv := v.FieldByName(field)
+		if json.Unmarshal(jb, fv.Addr().Interface()) != nil {
+			return fmt.Errorf("config: line %d: invalid value for %s (expected %s)", value.Line, field, fv.Type())
+		}
+	}
+	return nil
+}
+
+func decodeTOML(b []byte, s *Settings) error {
+	dec := toml.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(s)
+
+	var sme *toml.StrictMissingError
+	var de *toml.DecodeError
+	switch {
+	case errors.As(err, &sme) && len(sme.Errors) > 0:
+		line, _ := sme.Errors[0].Position()
+		return errUnknownSetting(line, strings.Join(sme.Errors[0].Key(), "."))
+	case errors.As(err, &de):
+		line, _ := de.Position()
+		return fmt.Errorf("config: line %d: %s", line, de.Error())
+	}
+	return err
+}
+
+func errUnknownSetting(line int, key string) error {
+	return fmt.Errorf("config: line %d: unknown setting '%s'", line, key)
+}
@@ -0,0 +1,260 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+	Each setting can be overridden with an environment variable and a command line flag.
+	Names are derived from the field name: AdminAPIKey -> GOAURRPC_ADMIN_API_KEY / -admin-api-key
+	Secrets (tagged with `secret:"true"`) can be read from a file: GOAURRPC_ADMIN_API_KEY_FILE
+	Precedence: flags > environment > config file > defaults
+*/
+
+// EnvPrefix is the prefix of environment variables overriding our settings
+const EnvPrefix = "GOAURRPC_"
+
+// Redacted is shown instead of the value of secrets
+const Redacted = "REDACTED"
+
+// Flags holds the settings overrides passed on the command line
+type Flags struct {
+	values []*flagValue
+}
+
+// a command line flag overriding a setting
+type flagValue struct {
+	field string
+	kind  reflect.Kind
+	value string
+	set   bool
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.value
+}
+
+func (v *flagValue) Set(value string) error {
+	if _, err := parseValue(v.kind, value); err != nil {
+		return err
+	}
+	v.value = value
+	v.set = true
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.kind == reflect.Bool
+}
+
+// RegisterFlags defines a flag for each setting
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := Flags{}
+	t := reflect.TypeOf(Settings{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		v := &flagValue{field: field.Name, kind: field.Type.Kind()}
+		usage := "Boolean value for setting " + field.Name
+		switch field.Type.Kind() {
+		case reflect.Int:
+			usage = "`int` value for setting " + field.Name
+		case reflect.String:
+			usage = "`string` value for setting " + field.Name
+		case reflect.Slice:
+			usage = "Comma separated `list` for setting " + field.Name
+		}
+		usage += " (env " + EnvName(field.Name) + ")"
+		fs.Var(v, FlagName(field.Name), usage)
+		f.values = append(f.values, v)
+	}
+	return &f
+}
+
+// Load returns our effective settings:
+// The defaults, overridden by the config file (if any), environment variables and flags
+func Load(path string, flags *Flags) (*Settings, error) {
+	s, err := Read(path, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	// make sure we got sane config data
+	if err := validateSettings(*s); err != nil {
+		return nil, err
+	}
+	if problems := s.CheckFile(path); len(problems) > 0 {
+		return nil, errors.New("config: " + problems[0].Setting + " " + problems[0].Message)
+	}
+	return s, nil
+}
+
+// Read returns our effective settings like Load, but without validating them
+func Read(path string, flags *Flags) (*Settings, error) {
+	s := DefaultSettings()
+	if path != "" {
+		if err := readFile(path, s); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := flags.apply(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Overridden returns the settings that are overridden by environment variables or flags
+func Overridden(flags *Flags) []string {
+	var fields []string
+	for _, field := range settingsFields() {
+		_, env := os.LookupEnv(EnvName(field))
+		_, envFile := os.LookupEnv(EnvName(field) + "_FILE")
+		if env || envFile || flags.isSet(field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// Redact returns a copy of our settings with secrets being replaced
+func (s Settings) Redact() Settings {
+	v := reflect.ValueOf(&s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if isSecret(v.Type().Field(i)) && v.Field(i).String() != "" {
+			v.Field(i).SetString(Redacted)
+		}
+	}
+	s.TrustedReverseProxies = append([]string{}, s.TrustedReverseProxies...)
+	s.ListenAddresses = slices.Clone(s.ListenAddresses)
+	s.AdminListenAddresses = slices.Clone(s.AdminListenAddresses)
+	s.TLSCipherSuites = slices.Clone(s.TLSCipherSuites)
+	return s
+}
+
+// EnvName returns the name of the environment variable for a setting
+func EnvName(field string) string {
+	return EnvPrefix + strings.ToUpper(splitName(field, "_"))
+}
+
+// FlagName returns the name of the command line flag for a setting
+func FlagName(field string) string {
+	return strings.ToLower(splitName(field, "-"))
+}
+
+// splits a field name into words: AdminAPIKey -> Admin_API_Key
+func splitName(name, sep string) string {
+	var b strings.Builder
+	r := []rune(name)
+	for i := range r {
+		if i > 0 && unicode.IsUpper(r[i]) && (unicode.IsLower(r[i-1]) || i+1 < len(r) && unicode.IsLower(r[i+1])) {
+			b.WriteString(sep)
+		}
+		b.WriteRune(r[i])
+	}
+	return b.String()
+}
+
+// overrides settings with environment variables
+func (s *Settings) applyEnv() error {
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := EnvName(field.Name)
+		value, ok := os.LookupEnv(name)
+
+		if file, fok := os.LookupEnv(name + "_FILE"); fok && isSecret(field) {
+			if ok {
+				return errors.New("config: " + name + " and " + name + "_FILE are mutually exclusive")
+			}
+			b, err := os.ReadFile(file)
+			if err != nil {
+				return errors.New("config: " + name + "_FILE: " + err.Error())
+			}
+			value, ok = strings.TrimRight(string(b), "\r\n"), true
+		}
+		if !ok {
+			continue
+		}
+
+		if err := setField(v.Field(i), value); err != nil {
+			return errors.New("config: " + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// overrides settings with the flags that have been set
+func (f *Flags) apply(s *Settings) error {
+	if f == nil {
+		return nil
+	}
+	v := reflect.ValueOf(s).Elem()
+	for _, fv := range f.values {
+		if !fv.set {
+			continue
+		}
+		if err := setField(v.FieldByName(fv.field), fv.value); err != nil {
+			return errors.New("config: -" + FlagName(fv.field) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// checks if a flag for a setting has been set
+func (f *Flags) isSet(field string) bool {
+	if f == nil {
+		return false
+	}
+	for _, fv := range f.values {
+		if fv.field == field {
+			return fv.set
+		}
+	}
+	return false
+}
+
+// sets a field from its string representation
+func setField(field reflect.Value, value string) error {
+	parsed, err := parseValue(field.Kind(), value)
+	if err != nil {
+		return err
+	}
+	field.Set(reflect.ValueOf(parsed))
+	return nil
+}
+
+// converts a string to a value of our settings
+func parseValue(kind reflect.Kind, value string) (any, error) {
+	switch kind {
+	case reflect.Int:
+		return strconv.Atoi(value)
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	case reflect.Slice:
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list, nil
+	}
+	return value, nil
+}
+
+// checks if a setting holds a secret
+func isSecret(field reflect.StructField) bool {
+	return field.Tag.Get("secret") == "true"
+}
@@ -0,0 +1,279 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+/*
+	Settings are written back to the existing config file.
+	Keys we don't know about are kept and the formatting of values that did not change is preserved.
+	Only changed values are re-encoded; settings missing in the file are appended.
+*/
+
+// the number of backups of our config file that are kept
+const maxBackups = 5
+
+// SaveToFile writes our settings to a file (atomically).
+// A timestamped backup of the previous file is kept next to it; older backups are removed.
+// Settings listed in keep are not written; their value in the file stays as it is
+func (s *Settings) SaveToFile(path string, keep ...string) error {
+	if FormatOf(path) != FormatJSON {
+		return errors.New("config: settings can only be written to JSON config files")
+	}
+
+	var perm fs.FileMode = 0600
+	orig, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	b, err := s.merge(orig, keep)
+	if err != nil {
+		return err
+	}
+
+	if orig != nil {
+		if err = writeFileAtomic(BackupFileName(path, time.Now()), orig, perm); err != nil {
+			return err
+		}
+		if err = removeBackups(path, maxBackups); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, b, perm)
+}
+
+// layout of the timestamp in backup file names
+const backupTimeLayout = "20060102-150405"
+
+// BackupFileName returns the name of the backup for a config file
+func BackupFileName(path string, t time.Time) string {
+	return path + "." + t.Format(backupTimeLayout) + ".bak"
+}
+
+// removes all but the newest backups of a config file
+func removeBackups(path string, keep int) error {
+	matches, err := filepath.Glob(path + ".*.bak")
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(m, path+"."), ".bak")
+		if _, err := time.Parse(backupTimeLayout, stamp); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+
+	// timestamps sort chronologically
+	sort.Strings(backups)
+	for _, b := range backups[:len(backups)-keep] {
+		if err := os.Remove(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// a top-level key/value pair of a config file
+type member struct {
+	key   string
+	value json.RawMessage
+}
+
+// merges our settings into the contents of an existing config file
+func (s *Settings) merge(orig []byte, keep []string) ([]byte, error) {
+	if len(bytes.TrimSpace(orig)) == 0 {
+		orig = []byte("{\n}\n")
+	}
+
+	members, err := parseMembers(orig)
+	if err != nil {
+		return nil, err
+	}
+
+	// current values, compact JSON
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var current map[string]json.RawMessage
+	if err = json.Unmarshal(b, &current); err != nil {
+		return nil, err
+	}
+
+	indent := detectIndent(orig)
+	written := map[string]bool{}
+	for _, field := range keep {
+		written[field] = true
+	}
+	var out bytes.Buffer
+	out.WriteString("{\n")
+
+	writeMember := func(key string, value []byte) {
+		if out.Len() > 2 {
+			out.WriteString(",\n")
+		}
+		k, _ := json.Marshal(key)
+		out.WriteString(indent)
+		out.Write(k)
+		out.WriteString(": ")
+		out.Write(value)
+	}
+
+	for _, m := range members {
+		field := settingsField(m.key)
+		if field == "" || written[field] {
+			// unknown key, duplicate or setting we should keep
+			writeMember(m.key, m.value)
+			continue
+		}
+		written[field] = true
+
+		value := m.value
+		var c bytes.Buffer
+		if json.Compact(&c, m.value) != nil || !bytes.Equal(c.Bytes(), current[field]) {
+			if value, err = indentValue(current[field], indent); err != nil {
+				return nil, err
+			}
+		}
+		writeMember(m.key, value)
+	}
+
+	// settings that were not part of the file
+	for _, field := range settingsFields() {
+		if written[field] {
+			continue
+		}
+		value, err := indentValue(current[field], indent)
+		if err != nil {
+			return nil, err
+		}
+		writeMember(field, value)
+	}
+
+	out.WriteString("\n}")
+	if bytes.HasSuffix(orig, []byte("\n")) {
+		out.WriteString("\n")
+	}
+	return out.Bytes(), nil
+}
+
+// returns the top-level members of a JSON object in their original order
+func parseMembers(b []byte) ([]member, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := t.(json.Delim); !ok || d != '{' {
+		return nil, errors.New("config: file does not contain a JSON object")
+	}
+
+	var members []member
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := t.(string)
+		if !ok {
+			return nil, errors.New("config: invalid key in config file")
+		}
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		members = append(members, member{key: key, value: value})
+	}
+	return members, nil
+}
+
+// returns the indentation used for the first key of a JSON object
+func detectIndent(b []byte) string {
+	start := bytes.IndexByte(b, '{')
+	quote := bytes.IndexByte(b, '"')
+	if start < 0 || quote < start {
+		return "\t"
+	}
+	ws := b[start+1 : quote]
+	if nl := bytes.LastIndexByte(ws, '\n'); nl >= 0 {
+		ws = ws[nl+1:]
+	}
+	if len(ws) == 0 || len(bytes.TrimLeft(ws, " \t")) != 0 {
+		return "\t"
+	}
+	return string(ws)
+}
+
+// formats a value for a member at the given indentation
+func indentValue(value json.RawMessage, indent string) ([]byte, error) {
+	var b bytes.Buffer
+	err := json.Indent(&b, value, indent, indent)
+	return b.Bytes(), err
+}
+
+// returns the names of our settings in the order they are declared
+func settingsFields() []string {
+	t := reflect.TypeOf(Settings{})
+	fields := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("json") == "-" {
+			continue
+		}
+		fields = append(fields, t.Field(i).Name)
+	}
+	return fields
+}
+
+// returns the name of the setting for a key. Keys are matched case-insensitively, like when loading the file
+func settingsField(key string) string {
+	for _, field := range settingsFields() {
+		if strings.EqualFold(field, key) {
+			return field
+		}
+	}
+	return ""
+}
+
+// writes a file by creating a temporary file which is renamed afterwards
+func writeFileAtomic(path string, b []byte, perm fs.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+
+	"github.com/goccy/go-json"
+	toml "github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// descriptions of our settings, used for comments in sample config files
+var descriptions = map[string]string{
+	"Port":                     "The port number our service is listening on",
+	"ListenAddresses":          "Addresses to listen on (host:port or unix:/path/to/socket). Overrides Port if set",
+	"SocketMode":               "File mode of unix sockets",
+	"SocketOwner":              "Owner of unix sockets (user:group)",
+	"AdminListenAddresses":     "Addresses of a separate listener for /admin, /metrics, pprof and health checks (host:port or unix:/path/to/socket). These routes are removed from the public listener if set",
+	"AurFileLocation":          "Either the URL to the full metadata archive packages-meta-ext-v1.json.gz or a local copy of the file",
+	"MaxResults":               "The maximum number of package results that are being returned to the client",
+	"RefreshInterval":          "The interval (in seconds) in which the metadata file is being reloaded",
+	"RateLimit":                "The maximum number of requests that are allowed within the time-window (0 = disabled)",
+	"LoadFromFile":             "Set to true when using a local file instead of a URL for AurFileLocation",
+	"RateLimitCleanupInterval": "The interval (in seconds) in which rate-limits are being cleaned up",
+	"RateLimitTimeWindow":      "Defines the length of the time window for rate-limiting (in seconds)",
+	"TrustedReverseProxies":    "A list of trusted IP-Addresses, in case you use a reverse proxy and need to rely on X-Real-IP or X-Forwarded-For headers",
+	"EnableSSL":                "Enables internal SSL/TLS. You'll need to provide CertFile and KeyFile when enabling it",
+	"CertFile":                 "Path to the cert file (if SSL is enabled)",
+	"KeyFile":                  "Path to the corresponding key file (if SSL is enabled)",
+	"TLSMinVersion":            "Minimum TLS version (1.0, 1.1, 1.2 or 1.3)",
+	"TLSCipherSuites":          "Allowed cipher suites for TLS 1.0 - 1.2, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (empty = Go defaults)",
+	"AdminClientCAFile":        "CA certificates (PEM) that admin API clients need a certificate from (mTLS, requires EnableSSL)",
+	"HTTPRedirectPort":         "Port that redirects plain HTTP requests to HTTPS (0 = disabled, requires EnableSSL and a TCP listener)",
+	"EnableSearchCache":        "Caches data for search queries that have been performed by clients",
+	"CacheCleanupInterval":     "The interval (in seconds) for performing cleanup of search-cache entries",
+	"CacheExpirationTime":      "The number of seconds an entry should stay in the search-cache",
+	"DataStaleThreshold":       "Package data older than this (in seconds) is considered stale: /readyz fails and rpc_data_stale is set (0 = disabled)",
+	"ShutdownDelay":            "The number of seconds we keep serving requests after SIGTERM while /readyz already fails, so load balancers can take us out of rotation",
+	"ShutdownTimeout":          "The number of seconds in-flight requests are drained on shutdown before remaining connections are closed (0 = wait forever)",
+	"ReadHeaderTimeout":        "The number of seconds clients have to send the request headers (0 = no limit)",
+	"ReadTimeout":              "The number of seconds clients have to send the entire request, including the body (0 = no limit)",
+	"WriteTimeout":             "The maximum number of seconds for handling a request and writing the response (0 = no limit)",
+	"IdleTimeout":              "The number of seconds idle keep-alive connections are kept open (0 = ReadTimeout)",
+	"MaxHeaderBytes":           "The maximum size (in bytes) of request headers (0 = 1 MB)",
+	"MaxBodySize":              "The maximum size (in bytes) of POST request bodies (0 = unlimited)",
+	"MaxArgs":                  "The maximum number of arg / arg[] values per request (0 = unlimited)",
+	"MaxArgLength":             "The maximum length of a single arg / arg[] value (0 = unlimited)",
+	"LogFile":                  "Path to a log file. Logs are written to stdout if empty",
+	"LogLevel":                 "The minimum level of log messages: trace, debug, info, warn or error",
+	"LogFormat":                "The format of log messages: text (logfmt) or json",
+	"AccessLog":                "Writes an access log entry for each /rpc and /api request",
+	"AccessLogFile":            "Path to the access log file. Entries are written to stdout if empty",
+	"AccessLogFormat":          "The format of access log entries: combined (Apache combined log format with extra fields) or json",
+	"LogMaxSize":               "The maximum size (in MB) of LogFile and AccessLogFile before they are rotated (0 = unlimited)",
+	"LogRotateInterval":        "Rotates log, access log and capture files when a new interval (in seconds) starts, e.g. 86400 for daily rotation (0 = disabled)",
+	"LogMaxBackups":            "The number of rotated files that are kept (0 = all)",
+	"LogMaxAge":                "The number of seconds rotated files are kept (0 = forever)",
+	"LogCompress":              "Compresses rotated files with gzip",
+	"EnableTracing":            "Exports OpenTelemetry traces of requests and data reloads via OTLP/HTTP",
+	"TracingEndpoint":          "The URL of the OTLP/HTTP traces endpoint, e.g. of an OpenTelemetry collector",
+	"TracingSampleRate":        "Samples 1 out of n traces (1 = all traces). Sampling decisions of incoming traceparent headers are respected",
+	"EnableMetrics":            "Enables Prometheus metrics at /metrics",
+	"EnablePprof":              "Serve pprof profiles at /debug/pprof on the admin listener (requires AdminListenAddresses)",
+	"EnableAdminApi":           "Enables the administrative endpoint at /admin",
+	"AdminAPIKey":              "The API Key that is to be provided in the header for the /admin endpoint",
+	"EnableApiKeys":            "Enables client API keys for /rpc and /api",
+	"ApiKeyFile":               "Path to the file holding the (hashed) client API keys",
+	"PersistSettings":          "Writes settings changed via the admin API back to the config file (JSON config files only)",
+	"WatchConfigFile":          "Reloads the config file automatically when it has been modified",
+	"CaptureRequests":          "Records sampled /rpc and /api requests to CaptureFile (JSONL) for replaying them with goaurrpc bench",
+	"CaptureFile":              "Path to the request capture file",
+	"CaptureSampleRate":        "Captures 1 out of n requests (1 = all requests)",
+	"CaptureMaxSize":           "The maximum size (in MB) of the capture file before it is rotated (0 = unlimited)",
+}
+
+// WriteSample writes our default settings in the given format.
+// YAML and TOML samples contain a description of each setting; JSON does not support comments
+func WriteSample(w io.Writer, f Format) error {
+	s := DefaultSettings()
+	v := reflect.ValueOf(s).Elem()
+
+	switch f {
+	case FormatYAML:
+		root := yaml.Node{Kind: yaml.MappingNode}
+		for _, field := range settingsFields() {
+			var value yaml.Node
+			if err := value.Encode(v.FieldByName(field).Interface()); err != nil {
+				return err
+			}
+			root.Content = append(root.Content, &yaml.Node{
+				Kind:        yaml.ScalarNode,
+				Value:       field,
+				HeadComment: descriptions[field],
+			}, &value)
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(&root); err != nil {
+			return err
+		}
+		return enc.Close()
+	case FormatTOML:
+		var b bytes.Buffer
+		for i, field := range settingsFields() {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString("# " + descriptions[field] + "\n")
+			t, err := toml.Marshal(map[string]any{field: v.FieldByName(field).Interface()})
+			if err != nil {
+				return err
+			}
+			b.Write(t)
+		}
+		_, err := w.Write(b.Bytes())
+		return err
+	}
+
+	b, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -3,14 +3,20 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
-
-	"github.com/goccy/go-json"
+	"os/user"
+	"strconv"
+	"strings"
 )
 
 // Settings is a data structure holding our configuration data
 type Settings struct {
 	Port                     int
+	ListenAddresses          []string
+	SocketMode               string // octal
+	SocketOwner              string // user:group
+	AdminListenAddresses     []string
 	AurFileLocation          string
 	MaxResults               int
 	RefreshInterval          int // in seconds
@@ -22,19 +28,67 @@ type Settings struct {
 	EnableSSL                bool
 	CertFile                 string
 	KeyFile                  string
+	TLSMinVersion            string
+	TLSCipherSuites          []string
+	AdminClientCAFile        string
+	HTTPRedirectPort         int // 0 = disabled
 	EnableSearchCache        bool
 	CacheCleanupInterval     int // in seconds
 	CacheExpirationTime      int // in seconds
+	DataStaleThreshold       int // in seconds
+	ShutdownDelay            int // in seconds
+	ShutdownTimeout          int // in seconds
+	ReadHeaderTimeout        int // in seconds
+	ReadTimeout              int // in seconds
+	WriteTimeout             int // in seconds
+	IdleTimeout              int // in seconds
+	MaxHeaderBytes           int // in bytes
+	MaxBodySize              int // in bytes
+	MaxArgs                  int
+	MaxArgLength             int
 	LogFile                  string
+	LogLevel                 string
+	LogFormat                string
+	AccessLog                bool
+	AccessLogFile            string
+	AccessLogFormat          string
+	LogMaxSize               int // in MB
+	LogRotateInterval        int // in seconds
+	LogMaxBackups            int
+	LogMaxAge                int // in seconds
+	LogCompress              bool
+	EnableTracing            bool
+	TracingEndpoint          string
+	TracingSampleRate        int // sample 1 out of n traces (0 / 1 = all)
 	EnableMetrics            bool
+	EnablePprof              bool
 	EnableAdminApi           bool
-	AdminAPIKey              string
+	AdminAPIKey              string `secret:"true"`
+	EnableApiKeys            bool
+	ApiKeyFile               string
+	PersistSettings          bool
+	WatchConfigFile          bool
+	CaptureRequests          bool
+	CaptureFile              string
+	CaptureSampleRate        int // capture 1 out of n requests (0 / 1 = all)
+	CaptureMaxSize           int // in MB
 }
 
+// valid values for LogLevel, LogFormat and AccessLogFormat
+var (
+	LogLevels        = []string{"trace", "debug", "info", "warn", "error"}
+	LogFormats       = []string{"text", "json"}
+	AccessLogFormats = []string{"combined", "json"}
+)
+
 // DefaultSettings returns the default settings for our server
 func DefaultSettings() *Settings {
 	s := Settings{
 		Port:                     10666,
+		ListenAddresses:          []string{},
+		SocketMode:               "0660",
+		SocketOwner:              "",
+		AdminListenAddresses:     []string{},
 		AurFileLocation:          "https://aur.archlinux.org/packages-meta-ext-v1.json.gz",
 		MaxResults:               5000,
 		RefreshInterval:          5 * 60, // refresh every 5 minutes
@@ -43,58 +97,255 @@ func DefaultSettings() *Settings {
 		RateLimitCleanupInterval: 10 * 60,
 		RateLimitTimeWindow:      24 * 60 * 60,
 		TrustedReverseProxies:    []string{"127.0.0.1", "::1"},
+		TLSMinVersion:            "1.2",
+		TLSCipherSuites:          []string{},
+		AdminClientCAFile:        "",
+		HTTPRedirectPort:         0,
 		EnableSearchCache:        true,
 		CacheCleanupInterval:     60,
 		CacheExpirationTime:      180,
+		DataStaleThreshold:       0,
+		ShutdownDelay:            0,
+		ShutdownTimeout:          30,
+		ReadHeaderTimeout:        10,
+		ReadTimeout:              30,
+		WriteTimeout:             60,
+		IdleTimeout:              120,
+		MaxHeaderBytes:           64 * 1024,
+		MaxBodySize:              1024 * 1024,
+		MaxArgs:                  1000,
+		MaxArgLength:             256,
 		LogFile:                  "",
+		LogLevel:                 "info",
+		LogFormat:                "text",
+		AccessLog:                false,
+		AccessLogFile:            "",
+		AccessLogFormat:          "combined",
+		LogMaxSize:               100,
+		LogRotateInterval:        0,
+		LogMaxBackups:            10,
+		LogMaxAge:                0,
+		LogCompress:              false,
+		EnableTracing:            false,
+		TracingEndpoint:          "http://localhost:4318/v1/traces",
+		TracingSampleRate:        1,
 		EnableMetrics:            true,
+		EnablePprof:              false,
 		EnableAdminApi:           false,
 		AdminAPIKey:              "change-me",
+		EnableApiKeys:            false,
+		ApiKeyFile:               "",
+		PersistSettings:          false,
+		WatchConfigFile:          false,
+		CaptureRequests:          false,
+		CaptureFile:              "",
+		CaptureSampleRate:        1,
+		CaptureMaxSize:           100,
 	}
 	return &s
 }
 
-// LoadFromFile load settings from a file
+// LoadFromFile load settings from a file. Settings missing in the file have their default value
 func LoadFromFile(path string) (*Settings, error) {
-	b, err := os.ReadFile(path)
-	if err != nil {
+	s := DefaultSettings()
+	if err := readFile(path, s); err != nil {
 		return nil, err
 	}
-	var s Settings
-	err = json.Unmarshal(b, &s)
-	if err != nil {
+
+	// make sure we got sane config data
+	if err := validateSettings(*s); err != nil {
 		return nil, err
 	}
 
-	// make sure we got sane config data
-	err = validateSettings(s)
+	return s, nil
+}
+
+// reads settings from a file (JSON, YAML or TOML) into s
+func readFile(path string, s *Settings) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return decode(b, FormatOf(path), s)
+}
+
+// Validate checks if our settings are sane
+func (s *Settings) Validate() error {
+	return validateSettings(*s)
+}
 
-	return &s, err
+// checks if one of our listen addresses is a TCP address. Port is used if there are none
+func hasTCPAddress(addresses []string) bool {
+	if len(addresses) == 0 {
+		return true
+	}
+	for _, addr := range addresses {
+		if !strings.HasPrefix(addr, UnixPrefix) {
+			return true
+		}
+	}
+	return false
 }
 
 // validate config settings
 func validateSettings(s Settings) error {
-	if s.RateLimit == 0 {
-		fmt.Printf("Warning: Rate limiting is disabled - RateLimit = 0\n\n")
-	}
-
-	errZero := " needs to be specified / greater than 0"
-	switch 0 {
-	case s.Port:
-		return errors.New("config: Port" + errZero)
-	case s.MaxResults:
-		return errors.New("config: MaxResults" + errZero)
-	case s.RefreshInterval:
-		return errors.New("config: RefreshInterval" + errZero)
-	case s.RateLimitCleanupInterval:
-		return errors.New("config: RateLimitCleanupInterval" + errZero)
-	case s.RateLimitTimeWindow:
-		return errors.New("config: RateLimitTimeWindow" + errZero)
-	case s.CacheCleanupInterval:
-		return errors.New("config: CacheCleanupInterval" + errZero)
-	case s.CacheExpirationTime:
-		return errors.New("config: CacheExpirationTime" + errZero)
+	if problems := s.basicProblems(); len(problems) > 0 {
+		return errors.New("config: " + problems[0].Setting + " " + problems[0].Message)
 	}
 
 	return nil
 }
+
+// returns the problems that prevent us from starting at all
+func (s *Settings) basicProblems() []Problem {
+	var problems []Problem
+	errZero := "needs to be specified / greater than 0"
+	for _, setting := range []struct {
+		name  string
+		value int
+	}{
+		{"Port", s.Port},
+		{"MaxResults", s.MaxResults},
+		{"RefreshInterval", s.RefreshInterval},
+		{"RateLimitCleanupInterval", s.RateLimitCleanupInterval},
+		{"RateLimitTimeWindow", s.RateLimitTimeWindow},
+		{"CacheCleanupInterval", s.CacheCleanupInterval},
+		{"CacheExpirationTime", s.CacheExpirationTime},
+	} {
+		if setting.value == 0 {
+			problems = append(problems, Problem{SeverityError, setting.name, errZero})
+		}
+	}
+
+	if s.EnableApiKeys && s.ApiKeyFile == "" {
+		problems = append(problems, Problem{SeverityError, "ApiKeyFile", "needs to be specified when EnableApiKeys is set"})
+	}
+
+	for _, setting := range []struct {
+		name   string
+		value  string
+		values []string
+	}{
+		{"LogLevel", s.LogLevel, LogLevels},
+		{"LogFormat", s.LogFormat, LogFormats},
+		{"AccessLogFormat", s.AccessLogFormat, AccessLogFormats},
+		{"TLSMinVersion", s.TLSMinVersion, TLSVersions},
+	} {
+		if !validChoice(setting.value, setting.values) {
+			problems = append(problems, Problem{SeverityError, setting.name, "needs to be one of: " + strings.Join(setting.values, ", ")})
+		}
+	}
+
+	for _, setting := range []struct {
+		name      string
+		addresses []string
+	}{
+		{"ListenAddresses", s.ListenAddresses},
+		{"AdminListenAddresses", s.AdminListenAddresses},
+	} {
+		for _, addr := range setting.addresses {
+			if err := checkListenAddress(addr); err != nil {
+				problems = append(problems, Problem{SeverityError, setting.name, err.Error()})
+			}
+		}
+	}
+	if _, err := ParseSocketMode(s.SocketMode); err != nil {
+		problems = append(problems, Problem{SeverityError, "SocketMode", err.Error()})
+	}
+
+	if _, err := ParseCipherSuites(s.TLSCipherSuites); err != nil {
+		problems = append(problems, Problem{SeverityError, "TLSCipherSuites", err.Error()})
+	}
+	if s.AdminClientCAFile != "" && !s.EnableSSL {
+		problems = append(problems, Problem{SeverityError, "AdminClientCAFile", "client certificates require EnableSSL"})
+	}
+	if s.HTTPRedirectPort < 0 || s.HTTPRedirectPort > 65535 {
+		problems = append(problems, Problem{SeverityError, "HTTPRedirectPort", fmt.Sprintf("%d is not a valid port number", s.HTTPRedirectPort)})
+	} else if s.HTTPRedirectPort > 0 && !s.EnableSSL {
+		problems = append(problems, Problem{SeverityError, "HTTPRedirectPort", "redirecting to HTTPS requires EnableSSL"})
+	} else if s.HTTPRedirectPort > 0 && !hasTCPAddress(s.ListenAddresses) {
+		problems = append(problems, Problem{SeverityError, "HTTPRedirectPort", "redirecting to HTTPS requires a TCP address in ListenAddresses"})
+	}
+
+	if s.EnableTracing && s.TracingEndpoint == "" {
+		problems = append(problems, Problem{SeverityError, "TracingEndpoint", "needs to be specified when EnableTracing is set"})
+	}
+
+	if s.CaptureRequests && s.CaptureFile == "" {
+		problems = append(problems, Problem{SeverityError, "CaptureFile", "needs to be specified when CaptureRequests is set"})
+	}
+
+	return problems
+}
+
+// checks if addr is either host:port or unix:/path/to/socket
+func checkListenAddress(addr string) error {
+	if path, ok := strings.CutPrefix(addr, UnixPrefix); ok {
+		if path == "" {
+			return fmt.Errorf("'%s' is missing the socket path", addr)
+		}
+		return nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("'%s' is not a valid address: %s", addr, err)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		return fmt.Errorf("'%s' has an invalid port number", addr)
+	}
+	return nil
+}
+
+// UnixPrefix marks a unix socket in ListenAddresses
+const UnixPrefix = "unix:"
+
+// ParseSocketMode returns the file mode for an octal string like "0660". Empty means 0660
+func ParseSocketMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0660, nil
+	}
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("'%s' is not a valid octal file mode", mode)
+	}
+	return os.FileMode(m), nil
+}
+
+// LookupOwner returns the uid and gid for "user:group" or "user" (with the user's primary group)
+func LookupOwner(owner string) (int, int, error) {
+	name, group, hasGroup := strings.Cut(owner, ":")
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	gid := u.Gid
+	if hasGroup {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return 0, 0, err
+		}
+		gid = g.Gid
+	}
+	uidNum, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("user %s has a non-numeric uid", name)
+	}
+	gidNum, err := strconv.Atoi(gid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("group of %s has a non-numeric gid", owner)
+	}
+	return uidNum, gidNum, nil
+}
+
+// checks if value is one of the given values. Empty values are allowed; the default is used for them
+func validChoice(value string, values []string) bool {
+	if value == "" {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
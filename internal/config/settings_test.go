@@ -1,16 +1,37 @@
 package config
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestLoadFromFile(t *testing.T) {
-	s, err := LoadFromFile("../../sample.conf")
+	for _, sample := range []string{"../../sample.conf", "../../sample.yaml", "../../sample.toml"} {
+		s, err := LoadFromFile(sample)
+		assert.Nil(t, err, sample)
+		assert.Equal(t, DefaultSettings(), s, "Sample does not equal defaults: "+sample)
+	}
+
+	s, err := LoadFromFile("../../test_data/test.conf")
 	assert.Nil(t, err)
 	assert.NotNil(t, s)
-	assert.Equal(t, DefaultSettings(), s, "Sample does not equal defaults")
 
 	s, err = LoadFromFile("../../test_data/test_broken.conf")
 	assert.NotNil(t, err)
@@ -58,4 +79,419 @@ func TestValidateSettings(t *testing.T) {
 	s.CacheExpirationTime = 1
 	err = validateSettings(s)
 	assert.Nil(t, err)
+
+	s.EnableApiKeys = true
+	err = validateSettings(s)
+	assert.NotNil(t, err)
+
+	s.ApiKeyFile = "keys.json"
+	err = validateSettings(s)
+	assert.Nil(t, err)
+
+	s.LogLevel = "verbose"
+	err = validateSettings(s)
+	assert.EqualError(t, err, "config: LogLevel needs to be one of: trace, debug, info, warn, error")
+
+	s.LogLevel = "trace"
+	s.AccessLogFormat = "common"
+	err = validateSettings(s)
+	assert.NotNil(t, err)
+
+	s.AccessLogFormat = "json"
+	err = validateSettings(s)
+	assert.Nil(t, err)
+
+	s.EnableTracing = true
+	s.TracingEndpoint = ""
+	err = validateSettings(s)
+	assert.EqualError(t, err, "config: TracingEndpoint needs to be specified when EnableTracing is set")
+}
+
+func TestSaveToFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "goaurrpc.conf")
+	orig := "{\n  \"port\": 10666,\n  \"MaxResults\": 5000,\n  \"TrustedReverseProxies\": [\"127.0.0.1\"]\n}\n"
+	assert.Nil(t, os.WriteFile(path, []byte(orig), 0640))
+
+	s := DefaultSettings()
+	s.MaxResults = 100
+	s.TrustedReverseProxies = []string{"127.0.0.1"}
+	assert.Nil(t, s.SaveToFile(path))
+
+	// unchanged values are kept, missing settings are appended
+	b, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(b), "{\n  \"port\": 10666,\n  \"MaxResults\": 100,\n  \"TrustedReverseProxies\": [\"127.0.0.1\"],\n  \"ListenAddresses\": [],\n  "), string(b))
+	assert.Contains(t, string(b), ",\n  \"PersistSettings\": false")
+	assert.True(t, strings.HasSuffix(string(b), "\n}\n"), string(b))
+
+	fi, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+
+	loaded, err := LoadFromFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, s, loaded)
+
+	// backup of the previous file
+	backups, err := filepath.Glob(path + ".*.bak")
+	assert.Nil(t, err)
+	assert.Len(t, backups, 1)
+	b, err = os.ReadFile(backups[0])
+	assert.Nil(t, err)
+	assert.Equal(t, orig, string(b))
+
+	// only the newest backups are kept
+	now := time.Now()
+	for i := 0; i < maxBackups+2; i++ {
+		b := BackupFileName(path, now.Add(time.Duration(-i-1)*time.Hour))
+		assert.Nil(t, os.WriteFile(b, []byte(orig), 0600))
+	}
+	assert.Nil(t, os.WriteFile(path+".other.bak", []byte(orig), 0600))
+	assert.Nil(t, s.SaveToFile(path))
+	backups, err = filepath.Glob(path + ".*.bak")
+	assert.Nil(t, err)
+	assert.Len(t, backups, maxBackups+1) // the unknown file is kept
+	assert.NotContains(t, backups, BackupFileName(path, now.Add(-time.Duration(maxBackups)*time.Hour)))
+
+	// new file
+	path = filepath.Join(dir, "new.conf")
+	assert.Nil(t, s.SaveToFile(path))
+	loaded, err = LoadFromFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, s, loaded)
+
+	// no JSON object
+	path = filepath.Join(dir, "broken.conf")
+	assert.Nil(t, os.WriteFile(path, []byte("[]"), 0600))
+	assert.NotNil(t, s.SaveToFile(path))
+}
+
+func TestNames(t *testing.T) {
+	assert.Equal(t, "GOAURRPC_ADMIN_API_KEY", EnvName("AdminAPIKey"))
+	assert.Equal(t, "GOAURRPC_ENABLE_SSL", EnvName("EnableSSL"))
+	assert.Equal(t, "GOAURRPC_API_KEY_FILE", EnvName("ApiKeyFile"))
+	assert.Equal(t, "admin-api-key", FlagName("AdminAPIKey"))
+	assert.Equal(t, "aur-file-location", FlagName("AurFileLocation"))
+}
+
+func TestLoad(t *testing.T) {
+	// defaults
+	s, err := Load("", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultSettings(), s)
+
+	// flags > env > file
+	secret := filepath.Join(t.TempDir(), "secret")
+	assert.Nil(t, os.WriteFile(secret, []byte("s3cret\n"), 0600))
+	t.Setenv("GOAURRPC_PORT", "1")
+	t.Setenv("GOAURRPC_MAX_RESULTS", "2")
+	t.Setenv("GOAURRPC_TRUSTED_REVERSE_PROXIES", "10.0.0.1, 10.0.0.2")
+	t.Setenv("GOAURRPC_ADMIN_API_KEY_FILE", secret)
+	t.Setenv("GOAURRPC_CERT_FILE_FILE", secret) // no secret, ignored
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterFlags(fs)
+	assert.Nil(t, fs.Parse([]string{"-port", "3", "-enable-ssl", "-cert-file", "c", "-key-file", "k"}))
+
+	s, err = Load("../../test_data/test.conf", flags)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, s.Port)
+	assert.Equal(t, 2, s.MaxResults)
+	assert.True(t, s.EnableSSL)
+	assert.Equal(t, "c", s.CertFile)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, s.TrustedReverseProxies)
+	assert.Equal(t, "s3cret", s.AdminAPIKey)
+	assert.Equal(t, []string{"Port", "MaxResults", "TrustedReverseProxies", "EnableSSL", "CertFile", "KeyFile", "AdminAPIKey"}, Overridden(flags))
+
+	// redacted secrets
+	r := s.Redact()
+	assert.Equal(t, Redacted, r.AdminAPIKey)
+	assert.Equal(t, "s3cret", s.AdminAPIKey)
+
+	// errors
+	assert.NotNil(t, fs.Parse([]string{"-port", "x"}))
+
+	t.Setenv("GOAURRPC_ADMIN_API_KEY", "both")
+	_, err = Load("", nil)
+	assert.NotNil(t, err)
+
+	os.Unsetenv("GOAURRPC_ADMIN_API_KEY")
+	t.Setenv("GOAURRPC_RATE_LIMIT", "x")
+	_, err = Load("", nil)
+	assert.NotNil(t, err)
+
+	t.Setenv("GOAURRPC_RATE_LIMIT", "1")
+	t.Setenv("GOAURRPC_MAX_RESULTS", "0")
+	_, err = Load("", nil)
+	assert.NotNil(t, err)
+}
+
+func TestSaveToFileKeep(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goaurrpc.conf")
+	assert.Nil(t, os.WriteFile(path, []byte("{\n\t\"AdminAPIKey\": \"file\"\n}\n"), 0600))
+
+	s := DefaultSettings()
+	s.AdminAPIKey = "env"
+	s.Port = 1
+	assert.Nil(t, s.SaveToFile(path, "AdminAPIKey", "Port"))
+
+	b, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), "\"AdminAPIKey\": \"file\"")
+	assert.NotContains(t, string(b), "\"Port\"")
+}
+
+func TestFormats(t *testing.T) {
+	dir := t.TempDir()
+
+	// samples
+	for _, f := range []Format{FormatJSON, FormatYAML, FormatTOML} {
+		var b bytes.Buffer
+		assert.Nil(t, WriteSample(&b, f))
+		if f != FormatJSON {
+			assert.Contains(t, b.String(), "# The port number our service is listening on\n")
+		}
+
+		path := filepath.Join(dir, "sample."+string(f))
+		assert.Nil(t, os.WriteFile(path, b.Bytes(), 0600))
+		s, err := LoadFromFile(path)
+		assert.Nil(t, err, f)
+		assert.Equal(t, DefaultSettings(), s, f)
+	}
+
+	// same field semantics in all formats
+	for name, content := range map[string]string{
+		"json": "{\"port\": 1, \"TrustedReverseProxies\": [\"a\"]}",
+		"yml":  "port: 1\nTrustedReverseProxies: [a]\n",
+		"toml": "port = 1\nTrustedReverseProxies = ['a']\n",
+	} {
+		path := filepath.Join(dir, "test."+name)
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0600))
+		s, err := LoadFromFile(path)
+		assert.Nil(t, err, name)
+		assert.Equal(t, 1, s.Port, name)
+		assert.Equal(t, []string{"a"}, s.TrustedReverseProxies, name)
+	}
+
+	// errors with line numbers
+	for name, c := range map[string]struct{ content, err string }{
+		"unknown.json": {"{\n\t\"Port\": 1,\n\t\"MaxResult\": 1\n}", "config: line 3: unknown setting 'MaxResult'"},
+		"unknown.yaml": {"Port: 1\n\nMaxResult: 1\n", "config: line 3: unknown setting 'MaxResult'"},
+		"unknown.toml": {"Port = 1\n\nMaxResult = 1\n", "config: line 3: unknown setting 'MaxResult'"},
+		"type.yaml":    {"Port: 1\nMaxResults: x\n", "config: line 2: invalid value for MaxResults (expected int)"},
+		"type.toml":    {"Port = 1\nMaxResults = 'x'\n", "config: line 2: toml: cannot store TOML string into a Go int"},
+		"list.yaml":    {"- Port\n", "config: line 1: file does not contain a YAML mapping"},
+	} {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.WriteFile(path, []byte(c.content), 0600))
+		_, err := LoadFromFile(path)
+		if assert.NotNil(t, err, name) {
+			assert.Equal(t, c.err, err.Error(), name)
+		}
+	}
+
+	// formats
+	assert.Equal(t, FormatYAML, FormatOf("x.YML"))
+	assert.Equal(t, FormatJSON, FormatOf("x.conf"))
+	f, err := ParseFormat("toml")
+	assert.Nil(t, err)
+	assert.Equal(t, FormatTOML, f)
+	_, err = ParseFormat("ini")
+	assert.NotNil(t, err)
+
+	// settings are only written to JSON files
+	assert.NotNil(t, DefaultSettings().SaveToFile(filepath.Join(dir, "sample.yaml")))
+	path := filepath.Join(dir, "persist.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte("PersistSettings: true\n"), 0600))
+	_, err = Load(path, nil)
+	assert.EqualError(t, err, "config: PersistSettings is only supported with JSON config files")
+	s, err := Read(path, nil)
+	assert.Nil(t, err)
+	assert.True(t, hasProblem(s.CheckFile(path), SeverityError, "PersistSettings"))
+	assert.Empty(t, s.CheckFile(filepath.Join(dir, "persist.json")))
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	// defaults are fine, but port might be in use on the test machine
+	s := DefaultSettings()
+	s.Port = freePort(t)
+	assert.Empty(t, s.Check())
+
+	// port in use
+	l, err := net.Listen("tcp", ":0")
+	assert.Nil(t, err)
+	defer l.Close()
+	s.Port = l.Addr().(*net.TCPAddr).Port
+	assert.True(t, hasProblem(s.Check(), SeverityError, "Port"))
+
+	// everything wrong
+	s = &Settings{
+		Port:                  70000,
+		ListenAddresses:       []string{"localhost", "127.0.0.1:70000", "unix:" + filepath.Join(dir, "missing", "rpc.sock")},
+		SocketMode:            "0999",
+		SocketOwner:           "goaurrpc-missing-user",
+		AurFileLocation:       "https://aur.archlinux.org/packages-meta-ext-v1.json.gz",
+		LoadFromFile:          true,
+		TrustedReverseProxies: []string{"127.0.0.1", "localhost"},
+		EnableSSL:             true,
+		CertFile:              filepath.Join(dir, "missing.pem"),
+		KeyFile:               filepath.Join(dir, "missing.key"),
+		LogFile:               filepath.Join(dir, "missing", "log"),
+		EnableTracing:         true,
+		TracingEndpoint:       "localhost:4318",
+		EnableAdminApi:        true,
+		AdminAPIKey:           "change-me",
+	}
+	problems := s.Check()
+	for _, setting := range []string{"Port", "ListenAddresses", "SocketMode", "SocketOwner", "MaxResults", "AurFileLocation", "CertFile", "TrustedReverseProxies", "LogFile", "TracingEndpoint", "AdminAPIKey"} {
+		assert.True(t, hasProblem(problems, SeverityError, setting), setting)
+	}
+	assert.True(t, hasProblem(problems, SeverityWarning, "RateLimit"))
+	assert.True(t, hasProblem(problems, SeverityWarning, "ReadHeaderTimeout"))
+	assert.Equal(t, "error:   Port: 70000 is not a valid port number", problems[len(s.basicProblems())].String())
+	listenProblems := 0
+	for _, p := range problems {
+		if p.Setting == "ListenAddresses" {
+			listenProblems++
+		}
+	}
+	assert.Equal(t, 3, listenProblems)
+
+	// listen addresses replace Port
+	s = DefaultSettings()
+	s.Port = l.Addr().(*net.TCPAddr).Port
+	s.ListenAddresses = []string{"127.0.0.1:0", "[::1]:0", "unix:" + filepath.Join(dir, "rpc.sock")}
+	assert.Empty(t, s.Check())
+	s.ListenAddresses = []string{l.Addr().String()}
+	assert.True(t, hasProblem(s.Check(), SeverityError, "ListenAddresses"))
+
+	// admin listener
+	s.ListenAddresses = nil
+	s.Port = freePort(t)
+	s.EnablePprof = true
+	assert.True(t, hasProblem(s.Check(), SeverityWarning, "EnablePprof"))
+	s.AdminListenAddresses = []string{"127.0.0.1:0", "unix:" + filepath.Join(dir, "admin.sock")}
+	assert.Empty(t, s.Check())
+	s.AdminListenAddresses = []string{"127.0.0.1", l.Addr().String()}
+	problems = s.Check()
+	assert.Len(t, problems, 2)
+	assert.True(t, hasProblem(problems, SeverityError, "AdminListenAddresses"))
+
+	// local file without LoadFromFile, weak key, valid key pair
+	s = DefaultSettings()
+	s.Port = freePort(t)
+	s.AurFileLocation = "../../test_data/test_packages.json"
+	s.EnableAdminApi = true
+	s.AdminAPIKey = "short"
+	s.EnableSSL = true
+	s.CertFile, s.KeyFile = writeKeyPair(t, dir, time.Now().Add(365*24*time.Hour))
+	problems = s.Check()
+	assert.Len(t, problems, 2)
+	assert.True(t, hasProblem(problems, SeverityError, "LoadFromFile"))
+	assert.True(t, hasProblem(problems, SeverityWarning, "AdminAPIKey"))
+
+	// expiring certificate
+	s.CertFile, s.KeyFile = writeKeyPair(t, dir, time.Now().Add(24*time.Hour))
+	assert.True(t, hasProblem(s.Check(), SeverityWarning, "CertFile"))
+}
+
+// checks if there is a problem with a setting
+func hasProblem(problems []Problem, severity Severity, setting string) bool {
+	for _, p := range problems {
+		if p.Severity == severity && p.Setting == setting {
+			return true
+		}
+	}
+	return false
+}
+
+// returns a port that is currently free
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", ":0")
+	assert.Nil(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// writes a self-signed certificate and its key
+func writeKeyPair(t *testing.T, dir string, notAfter time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func TestTLSSettings(t *testing.T) {
+	v, err := ParseTLSVersion("")
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), v)
+	v, err = ParseTLSVersion("1.3")
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), v)
+	_, err = ParseTLSVersion("1.4")
+	assert.NotNil(t, err)
+
+	ids, err := ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_RC4_128_SHA}, ids)
+	_, err = ParseCipherSuites([]string{"TLS_AES_128_GCM_SHA256"})
+	assert.EqualError(t, err, "'TLS_AES_128_GCM_SHA256' is a TLS 1.3 cipher suite, which can not be configured")
+	_, err = ParseCipherSuites([]string{"TLS_NONE"})
+	assert.NotNil(t, err)
+
+	dir := t.TempDir()
+	// settings that require EnableSSL
+	s := DefaultSettings()
+	s.TLSMinVersion = "2.0"
+	s.TLSCipherSuites = []string{"TLS_NONE"}
+	s.AdminClientCAFile = filepath.Join(dir, "ca.pem")
+	s.HTTPRedirectPort = 80
+	problems := s.basicProblems()
+	for _, setting := range []string{"TLSMinVersion", "TLSCipherSuites", "AdminClientCAFile", "HTTPRedirectPort"} {
+		assert.True(t, hasProblem(problems, SeverityError, setting), setting)
+	}
+
+	// insecure and missing files
+	s = DefaultSettings()
+	s.Port = freePort(t)
+	s.EnableSSL = true
+	s.CertFile, s.KeyFile = writeKeyPair(t, dir, time.Now().Add(365*24*time.Hour))
+	s.TLSMinVersion = "1.0"
+	s.TLSCipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+	s.AdminClientCAFile = filepath.Join(dir, "missing.pem")
+	s.HTTPRedirectPort = s.Port
+	problems = s.Check()
+	assert.Len(t, problems, 4)
+	assert.True(t, hasProblem(problems, SeverityWarning, "TLSMinVersion"))
+	assert.True(t, hasProblem(problems, SeverityWarning, "TLSCipherSuites"))
+	assert.True(t, hasProblem(problems, SeverityError, "AdminClientCAFile"))
+	assert.True(t, hasProblem(problems, SeverityError, "HTTPRedirectPort"))
+
+	s.TLSMinVersion = "1.3"
+	s.TLSCipherSuites = nil
+	s.AdminClientCAFile = s.CertFile
+	s.HTTPRedirectPort = freePort(t)
+	assert.Empty(t, s.Check())
+
+	// we can't redirect to unix sockets
+	s.ListenAddresses = []string{UnixPrefix + filepath.Join(dir, "rpc.sock")}
+	assert.True(t, hasProblem(s.basicProblems(), SeverityError, "HTTPRedirectPort"))
+	s.ListenAddresses = append(s.ListenAddresses, "127.0.0.1:"+strconv.Itoa(freePort(t)))
+	assert.False(t, hasProblem(s.basicProblems(), SeverityError, "HTTPRedirectPort"))
 }
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// valid values for TLSMinVersion
+var TLSVersions = []string{"1.0", "1.1", "1.2", "1.3"}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion returns the TLS version for "1.0" - "1.3". Empty means 1.2
+func ParseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("'%s' is not a valid TLS version", version)
+	}
+	return v, nil
+}
+
+// ParseCipherSuites returns the IDs of cipher suites given by name.
+// TLS 1.3 suites are rejected since they can not be configured
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	var ids []uint16
+	for _, name := range names {
+		suite := findCipherSuite(name)
+		if suite == nil {
+			return nil, fmt.Errorf("'%s' is not a known cipher suite", name)
+		}
+		if !slices.ContainsFunc(suite.SupportedVersions, func(v uint16) bool { return v < tls.VersionTLS13 }) {
+			return nil, fmt.Errorf("'%s' is a TLS 1.3 cipher suite, which can not be configured", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
+}
+
+// returns the cipher suite with the given name, nil if there is none
+func findCipherSuite(name string) *tls.CipherSuite {
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if suite.Name == name {
+			return suite
+		}
+	}
+	return nil
+}
+
+// LoadCertPool returns a pool with the (PEM encoded) certificates from file
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New(file + " does not contain any PEM encoded certificates")
+	}
+	return pool, nil
+}
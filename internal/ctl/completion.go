@@ -0,0 +1,114 @@
+package ctl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/moson-mo/goaurrpc/internal/admin"
+)
+
+// shells we provide completion scripts for
+var shells = []string{"bash", "zsh", "fish"}
+
+// flags that take a value. Their values are skipped when looking for commands
+const valueFlags = "-profiles|--profiles|-profile|--profile|-url|--url|-key|--key|-o|--o|-timeout|--timeout"
+
+func (c *ctl) printCompletion(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	switch args[0] {
+	case "bash":
+		fmt.Fprint(c.stdout, bashCompletion())
+	case "zsh":
+		fmt.Fprint(c.stdout, "#compdef goaurrpc\nautoload -U +X bashcompinit && bashcompinit\n"+bashCompletion())
+	case "fish":
+		fmt.Fprint(c.stdout, fishCompletion())
+	default:
+		return fmt.Errorf("unknown shell '%s'. Use %s", args[0], strings.Join(shells, ", "))
+	}
+	return nil
+}
+
+// words to complete after a command path like "settings get"
+type completion struct {
+	path  string
+	words []string
+}
+
+// returns the completions for all our commands
+func completions() []completion {
+	var list []completion
+	var walk func(path string, cmds []command)
+	walk = func(path string, cmds []command) {
+		comp := completion{path: path}
+		for _, cmd := range cmds {
+			comp.words = append(comp.words, cmd.name)
+		}
+		list = append(list, comp)
+		for _, cmd := range cmds {
+			if cmd.sub != nil {
+				walk(strings.TrimSpace(path+" "+cmd.name), cmd.sub)
+			}
+		}
+	}
+	walk("", commands())
+	return append(list,
+		completion{"settings get", admin.OptionNames()},
+		completion{"settings set", admin.OptionNames()},
+		completion{"completion", shells},
+	)
+}
+
+func bashCompletion() string {
+	var cases strings.Builder
+	for _, comp := range completions() {
+		fmt.Fprintf(&cases, "\t\t\"%s\") words=\"%s\" ;;\n", comp.path, strings.Join(comp.words, " "))
+	}
+
+	return `# bash completion for goaurrpc ctl
+_goaurrpc() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" words="" args=() skip=0 i
+	if ((COMP_CWORD == 1)); then
+		COMPREPLY=($(compgen -W "ctl" -- "$cur"))
+		return 0
+	fi
+	[[ "${COMP_WORDS[1]}" == "ctl" ]] || return 0
+	for ((i = 2; i < COMP_CWORD; i++)); do
+		if ((skip)); then
+			skip=0
+			continue
+		fi
+		case "${COMP_WORDS[i]}" in
+		` + valueFlags + `) skip=1 ;;
+		-*) ;;
+		*) args+=("${COMP_WORDS[i]}") ;;
+		esac
+	done
+	if [[ "$cur" == -* ]]; then
+		words="-profiles -profile -url -key -o -async -timeout"
+	else
+		case "${args[*]}" in
+` + cases.String() + `		esac
+	fi
+	COMPREPLY=($(compgen -W "$words" -- "$cur"))
+}
+complete -o default -F _goaurrpc goaurrpc
+`
+}
+
+func fishCompletion() string {
+	var b strings.Builder
+	b.WriteString("# fish completion for goaurrpc ctl\n")
+	b.WriteString("complete -c goaurrpc -n '__fish_use_subcommand' -f -a 'ctl'\n")
+	for _, comp := range completions() {
+		cond := "__fish_seen_subcommand_from ctl"
+		if comp.path != "" {
+			parts := strings.Fields(comp.path)
+			cond = "__fish_seen_subcommand_from " + parts[len(parts)-1]
+		}
+		fmt.Fprintf(&b, "complete -c goaurrpc -n '%s; and not __fish_seen_subcommand_from %s' -f -a '%s'\n",
+			cond, strings.Join(comp.words, " "), strings.Join(comp.words, " "))
+	}
+	return b.String()
+}
@@ -0,0 +1,429 @@
+package ctl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/moson-mo/goaurrpc/internal/admin"
+	"github.com/moson-mo/goaurrpc/internal/config"
+)
+
+/*
+	goaurrpc ctl is a client for the admin API.
+	It only makes use of the endpoints that are documented in openapi_admin.json
+*/
+
+// Output formats
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+// a subcommand of goaurrpc ctl
+type command struct {
+	name  string
+	args  string
+	usage string
+	sub   []command
+	run   func(c *ctl, args []string) error
+}
+
+// errUsage is returned when a command is called with wrong arguments
+var errUsage = errors.New("invalid arguments")
+
+// our subcommands
+func commands() []command {
+	return []command{
+		{name: "reload", usage: "Reload package data", run: job("reload-data")},
+		{name: "reload-config", usage: "Reload the config file", run: job("reload-config")},
+		{name: "cache", usage: "Manage the search-cache", sub: []command{
+			{name: "wipe", usage: "Remove all entries from the search-cache", run: job("wipe-cache")},
+			{name: "cleanup", usage: "Remove expired entries from the search-cache", run: job("cleanup-cache")},
+		}},
+		{name: "ratelimits", usage: "Manage rate-limits", sub: []command{
+			{name: "wipe", usage: "Remove all rate-limits", run: job("wipe-ratelimits")},
+			{name: "cleanup", usage: "Remove expired rate-limits", run: job("cleanup-ratelimits")},
+		}},
+		{name: "settings", usage: "Show or change settings", sub: []command{
+			{name: "get", args: "[name]", usage: "Show all settings or a single option", run: (*ctl).getSettings},
+			{name: "set", args: "<name> <value> [<name> <value>...]", usage: "Change options. Multiple changes are applied at once", run: (*ctl).setSettings},
+		}},
+		{name: "stats", usage: "Show runtime statistics", run: (*ctl).stats},
+		{name: "completion", args: "<bash|zsh|fish>", usage: "Print a shell completion script", run: (*ctl).printCompletion},
+	}
+}
+
+type ctl struct {
+	url    string
+	key    string
+	output string
+	async  bool
+	client *http.Client
+	stdout io.Writer
+}
+
+// Run executes goaurrpc ctl with the given arguments and returns the exit code
+func Run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("goaurrpc ctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	profiles := fs.String("profiles", DefaultProfilePath(), "Profile `file`")
+	profile := fs.String("profile", os.Getenv("GOAURRPC_CTL_PROFILE"), "Name of the profile to use (env GOAURRPC_CTL_PROFILE)")
+	addr := fs.String("url", "", "URL of the goaurrpc instance or unix:/path/to/admin.sock. Overrides the profile")
+	key := fs.String("key", "", "Admin API key. Overrides the profile")
+	output := fs.String("o", OutputTable, "Output `format` (table or json)")
+	async := fs.Bool("async", false, "Run jobs in the background")
+	timeout := fs.Duration("timeout", 30*time.Second, "Request timeout")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: goaurrpc ctl [flags] <command>\n\nCommands:\n")
+		tw := tabwriter.NewWriter(stderr, 0, 4, 2, ' ', 0)
+		for _, cmd := range commands() {
+			printUsage(tw, "", cmd)
+		}
+		tw.Flush()
+		fmt.Fprintf(stderr, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if *output != OutputTable && *output != OutputJSON {
+		fmt.Fprintln(stderr, "error: invalid output format '"+*output+"'. Use table or json")
+		return 2
+	}
+
+	cmd, rest, path := findCommand(fs.Args())
+	if cmd == nil {
+		fs.Usage()
+		return 2
+	}
+
+	c := &ctl{
+		url:    DefaultUrl,
+		output: *output,
+		async:  *async,
+		client: &http.Client{Timeout: *timeout},
+		stdout: stdout,
+	}
+
+	// the profile is only needed for talking to the server
+	if cmd.name != "completion" {
+		if err := c.setProfile(*profiles, *profile, *addr, *key); err != nil {
+			fmt.Fprintln(stderr, "error:", err)
+			return 1
+		}
+	}
+
+	if err := cmd.run(c, rest); err != nil {
+		if err == errUsage {
+			fmt.Fprintf(stderr, "Usage: goaurrpc ctl %s %s\n", path, cmd.args)
+			return 2
+		}
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+// finds the command to run. Returns the remaining arguments and the command path
+func findCommand(args []string) (*command, []string, string) {
+	cmds := commands()
+	var path []string
+	for len(args) > 0 {
+		var found *command
+		for i := range cmds {
+			if cmds[i].name == args[0] {
+				found = &cmds[i]
+			}
+		}
+		if found == nil {
+			return nil, nil, ""
+		}
+		path = append(path, args[0])
+		args = args[1:]
+		if found.sub == nil {
+			return found, args, strings.Join(path, " ")
+		}
+		cmds = found.sub
+	}
+	return nil, nil, ""
+}
+
+func printUsage(w io.Writer, prefix string, cmd command) {
+	if cmd.sub != nil {
+		for _, sub := range cmd.sub {
+			printUsage(w, prefix+cmd.name+" ", sub)
+		}
+		return
+	}
+	fmt.Fprintf(w, "  %s%s %s\t%s\n", prefix, cmd.name, cmd.args, cmd.usage)
+}
+
+// sets url, key and TLS settings from our profile. Flags take precedence
+func (c *ctl) setProfile(path, name, addr, key string) error {
+	var p Profile
+	if path != "" {
+		pf, err := LoadProfiles(path)
+		switch {
+		case err == nil:
+			if p, err = pf.Profile(name); err != nil {
+				return err
+			}
+			c.url, c.key = p.Url, p.APIKey
+		case !os.IsNotExist(err) || name != "":
+			return err
+		}
+	}
+	if addr != "" {
+		c.url = addr
+	}
+	if key != "" {
+		c.key = key
+	}
+	c.url = strings.TrimRight(c.url, "/")
+
+	tc, err := p.tlsConfig()
+	if err != nil {
+		return err
+	}
+	// an admin listener on a unix socket; with CAFile / CertFile we talk TLS
+	socket, unix := strings.CutPrefix(c.url, "unix:")
+	if unix {
+		c.url = "http://localhost"
+		if tc != nil {
+			c.url = "https://localhost"
+			tc.ServerName = "localhost"
+		}
+	}
+	if unix || tc != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tc
+		if unix {
+			transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			}
+		}
+		client := http.Client{}
+		if c.client != nil {
+			client = *c.client
+		}
+		client.Transport = transport
+		c.client = &client
+	}
+	return nil
+}
+
+// returns a command that runs an admin job
+func job(name string) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		if len(args) != 0 {
+			return errUsage
+		}
+		q := url.Values{}
+		if c.async {
+			q.Set("async", "true")
+		}
+		b, err := c.do("POST", "/admin/run-job/"+name, q, nil)
+		if err != nil {
+			return err
+		}
+		return c.printResponse(b)
+	}
+}
+
+func (c *ctl) getSettings(args []string) error {
+	switch len(args) {
+	case 0:
+		b, err := c.do("GET", "/admin/settings", nil, nil)
+		if err != nil {
+			return err
+		}
+		if c.output == OutputJSON {
+			return c.printJSON(b)
+		}
+		var s config.Settings
+		if err = json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		tw := c.table("SETTING", "VALUE")
+		v := reflect.ValueOf(s)
+		for i := 0; i < v.NumField(); i++ {
+			value := v.Field(i).Interface()
+			if list, ok := value.([]string); ok {
+				value = strings.Join(list, ",")
+			}
+			fmt.Fprintf(tw, "%s\t%v\n", v.Type().Field(i).Name, value)
+		}
+		return tw.Flush()
+	case 1:
+		b, err := c.do("GET", "/admin/settings/"+url.PathEscape(args[0]), nil, nil)
+		if err != nil {
+			return err
+		}
+		if c.output == OutputJSON {
+			return c.printJSON(b)
+		}
+		var res admin.Response
+		if err = json.Unmarshal(b, &res); err != nil {
+			return err
+		}
+		tw := c.table("SETTING", "VALUE")
+		fmt.Fprintf(tw, "%s\t%v\n", res.Setting, res.Value)
+		return tw.Flush()
+	}
+	return errUsage
+}
+
+func (c *ctl) setSettings(args []string) error {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return errUsage
+	}
+
+	// a single option is changed with POST; multiple ones atomically with PATCH
+	if len(args) == 2 {
+		b, err := c.do("POST", "/admin/settings/"+url.PathEscape(args[0]), url.Values{"value": {args[1]}}, nil)
+		if err != nil {
+			return err
+		}
+		return c.printResponse(b)
+	}
+
+	values := map[string]string{}
+	for i := 0; i < len(args); i += 2 {
+		values[fieldName(args[i])] = args[i+1]
+	}
+	b, err := c.do("PATCH", "/admin/settings", nil, values)
+	if err != nil {
+		return err
+	}
+	return c.printResponse(b)
+}
+
+func (c *ctl) stats(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	if c.output == OutputJSON {
+		b, err := c.do("GET", "/admin/stats", nil, nil)
+		if err != nil {
+			return err
+		}
+		return c.printJSON(b)
+	}
+
+	b, err := c.do("GET", "/admin/stats", url.Values{"format": {"text"}}, nil)
+	if err != nil {
+		return err
+	}
+	tw := c.table("STAT", "VALUE")
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if k, v, ok := strings.Cut(line, ": "); ok {
+			fmt.Fprintf(tw, "%s\t%s\n", k, v)
+		}
+	}
+	return tw.Flush()
+}
+
+// converts an option name like max-results to its field name (MaxResults)
+func fieldName(name string) string {
+	t := reflect.TypeOf(config.Settings{})
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i).Name; config.FlagName(field) == name || strings.EqualFold(field, name) {
+			return field
+		}
+	}
+	return name
+}
+
+// sends a request to the admin API and returns the response body
+func (c *ctl) do(method, path string, query url.Values, body any) ([]byte, error) {
+	u := c.url + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var rb io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		rb = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, rb)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("APIKey", c.key)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 300 {
+		var ar admin.Response
+		if json.Unmarshal(b, &ar) != nil || ar.Message == "" {
+			return nil, errors.New(method + " " + path + ": " + res.Status)
+		}
+		if ar.Code != "" {
+			return nil, errors.New(ar.Message + " (" + ar.Code + ")")
+		}
+		return nil, errors.New(ar.Message)
+	}
+	return b, nil
+}
+
+// prints the message of an admin response (table) or the response itself (json)
+func (c *ctl) printResponse(b []byte) error {
+	if c.output == OutputJSON {
+		return c.printJSON(b)
+	}
+	var res admin.Response
+	if err := json.Unmarshal(b, &res); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(c.stdout, res.Message)
+	return err
+}
+
+func (c *ctl) printJSON(b []byte) error {
+	var out bytes.Buffer
+	if err := json.Indent(&out, b, "", "\t"); err != nil {
+		return err
+	}
+	out.WriteString("\n")
+	_, err := out.WriteTo(c.stdout)
+	return err
+}
+
+// returns a tabwriter with a header line
+func (c *ctl) table(header ...string) *tabwriter.Writer {
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	return tw
+}
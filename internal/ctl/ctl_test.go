@@ -0,0 +1,197 @@
+package ctl
+
+import (
+	"bytes"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/moson-mo/goaurrpc/internal/admin"
+	"github.com/moson-mo/goaurrpc/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// the endpoints documented in openapi_admin.json
+type spec struct {
+	Paths      map[string]map[string]json.RawMessage
+	Components struct {
+		Schemas struct {
+			Options struct{ Enum []string }
+		}
+	}
+}
+
+func loadSpec(t *testing.T) spec {
+	b, err := os.ReadFile("../doc/openapi_admin.json")
+	assert.Nil(t, err)
+	var s spec
+	assert.Nil(t, json.Unmarshal(b, &s))
+	return s
+}
+
+// checks if a request matches one of the documented endpoints
+func (s spec) documented(method, path string) bool {
+	for p, methods := range s.Paths {
+		re := regexp.MustCompile("^" + regexp.MustCompile(`\{[^}]+\}`).ReplaceAllString(p, "[^/]+") + "$")
+		if _, ok := methods[strings.ToLower(method)]; ok && re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRun(t *testing.T) {
+	doc := loadSpec(t)
+	assert.Equal(t, doc.Components.Schemas.Options.Enum, admin.OptionNames())
+
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		assert.True(t, doc.documented(r.Method, r.URL.Path), "undocumented endpoint: "+r.Method+" "+r.URL.Path)
+
+		var res any = admin.Response{Status: "ok", Message: "done"}
+		switch {
+		case r.Header.Get("APIKey") != "secret":
+			w.WriteHeader(http.StatusUnauthorized)
+			res = admin.Response{Status: "error", Code: "unauthorized", Message: "Unauthorized"}
+		case r.URL.Path == "/admin/settings" && r.Method == "GET":
+			res = config.DefaultSettings()
+		case r.URL.Path == "/admin/settings" && r.Method == "PATCH":
+			var values map[string]string
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&values))
+			assert.Equal(t, map[string]string{"MaxResults": "100", "RateLimit": "50"}, values)
+		case r.URL.Path == "/admin/settings/max-results" && r.Method == "GET":
+			res = admin.Response{Status: "ok", Message: "Current setting", Setting: "MaxResults", Value: 5000}
+		case r.URL.Path == "/admin/stats":
+			w.Write([]byte("version: v1.2.2\npackages: 3\n"))
+			return
+		}
+		b, _ := json.Marshal(res)
+		w.Write(b)
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	profiles := filepath.Join(dir, "ctl.json")
+	os.WriteFile(filepath.Join(dir, "key"), []byte("secret\n"), 0600)
+	os.WriteFile(profiles, []byte(`{
+		"Default": "test",
+		"Profiles": {
+			"test": {"Url": "`+ts.URL+`/", "APIKeyFile": "`+filepath.Join(dir, "key")+`"},
+			"wrong": {"Url": "`+ts.URL+`", "APIKey": "wrong"}
+		}
+	}`), 0600)
+
+	tests := []struct {
+		args     []string
+		code     int
+		request  string
+		contains string
+	}{
+		{[]string{"reload"}, 0, "POST /admin/run-job/reload-data", "done"},
+		{[]string{"-async", "reload-config"}, 0, "POST /admin/run-job/reload-config?async=true", "done"},
+		{[]string{"cache", "wipe"}, 0, "POST /admin/run-job/wipe-cache", "done"},
+		{[]string{"cache", "cleanup"}, 0, "POST /admin/run-job/cleanup-cache", "done"},
+		{[]string{"ratelimits", "wipe"}, 0, "POST /admin/run-job/wipe-ratelimits", "done"},
+		{[]string{"ratelimits", "cleanup"}, 0, "POST /admin/run-job/cleanup-ratelimits", "done"},
+		{[]string{"settings", "get"}, 0, "GET /admin/settings", "MaxResults                5000"},
+		{[]string{"-o", "json", "settings", "get"}, 0, "GET /admin/settings", "\t\"MaxResults\": 5000,"},
+		{[]string{"settings", "get", "max-results"}, 0, "GET /admin/settings/max-results", "MaxResults  5000"},
+		{[]string{"settings", "set", "max-results", "100"}, 0, "POST /admin/settings/max-results?value=100", "done"},
+		{[]string{"settings", "set", "max-results", "100", "RateLimit", "50"}, 0, "PATCH /admin/settings", "done"},
+		{[]string{"stats"}, 0, "GET /admin/stats?format=text", "packages  3"},
+		{[]string{"-profile", "wrong", "stats"}, 1, "GET /admin/stats?format=text", ""},
+		{[]string{"-profile", "missing", "stats"}, 1, "", ""},
+		{[]string{"settings", "set", "max-results"}, 2, "", ""},
+		{[]string{"unknown"}, 2, "", ""},
+		{[]string{"-o", "yaml", "stats"}, 2, "", ""},
+		{[]string{"completion", "bash"}, 0, "", "complete -o default -F _goaurrpc goaurrpc"},
+		{[]string{"completion", "fish"}, 0, "", "-a 'wipe cleanup'"},
+	}
+
+	for _, test := range tests {
+		requests = nil
+		var stdout, stderr bytes.Buffer
+		code := Run(append([]string{"-profiles", profiles}, test.args...), &stdout, &stderr)
+		name := strings.Join(test.args, " ")
+
+		assert.Equal(t, test.code, code, name+": "+stderr.String())
+		assert.Contains(t, stdout.String(), test.contains, name)
+		if test.request == "" {
+			assert.Empty(t, requests, name)
+		} else {
+			assert.Equal(t, []string{test.request}, requests, name)
+		}
+	}
+
+	// admin listener on a unix socket
+	l, err := net.Listen("unix", filepath.Join(dir, "admin.sock"))
+	assert.Nil(t, err)
+	us := httptest.NewUnstartedServer(ts.Config.Handler)
+	us.Listener.Close()
+	us.Listener = l
+	us.Start()
+	defer us.Close()
+	requests = nil
+	code := Run([]string{"-profiles", profiles, "-url", "unix:" + filepath.Join(dir, "admin.sock"), "reload"}, &bytes.Buffer{}, &bytes.Buffer{})
+	assert.Equal(t, 0, code)
+	assert.Equal(t, []string{"POST /admin/run-job/reload-data"}, requests)
+
+	// server certificate verified with CAFile
+	tlsServer := httptest.NewTLSServer(ts.Config.Handler)
+	defer tlsServer.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw}), 0600)
+	os.WriteFile(filepath.Join(dir, "tls.json"), []byte(`{"Profiles": {
+		"tls": {"Url": "`+tlsServer.URL+`", "APIKey": "secret", "CAFile": "`+caFile+`"},
+		"nocert": {"Url": "`+tlsServer.URL+`", "APIKey": "secret", "CAFile": "`+caFile+`", "CertFile": "`+filepath.Join(dir, "missing.pem")+`"}
+	}}`), 0600)
+	requests = nil
+	code = Run([]string{"-profiles", filepath.Join(dir, "tls.json"), "-profile", "tls", "reload"}, &bytes.Buffer{}, &bytes.Buffer{})
+	assert.Equal(t, 0, code)
+	assert.Equal(t, []string{"POST /admin/run-job/reload-data"}, requests)
+	var tlsErr bytes.Buffer
+	code = Run([]string{"-profiles", filepath.Join(dir, "tls.json"), "-profile", "nocert", "reload"}, &bytes.Buffer{}, &tlsErr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, tlsErr.String(), "client certificate")
+
+	// errors returned by the server
+	var stderr bytes.Buffer
+	Run([]string{"-profiles", profiles, "-key", "nope", "reload"}, &bytes.Buffer{}, &stderr)
+	assert.Equal(t, "error: Unauthorized (unauthorized)\n", stderr.String())
+}
+
+func TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ctl.json")
+
+	_, err := LoadProfiles(path)
+	assert.True(t, os.IsNotExist(err))
+
+	os.WriteFile(path, []byte(`{"Profiles": {"only": {"Url": "http://example.com", "APIKey": "k"}}}`), 0600)
+	pf, err := LoadProfiles(path)
+	assert.Nil(t, err)
+	p, err := pf.Profile("")
+	assert.Nil(t, err)
+	assert.Equal(t, Profile{Url: "http://example.com", APIKey: "k"}, p)
+
+	_, err = pf.Profile("other")
+	assert.EqualError(t, err, "ctl: profile 'other' not found. Available: only")
+
+	// no profile file: defaults and flags are used
+	c := &ctl{url: DefaultUrl}
+	assert.Nil(t, c.setProfile(filepath.Join(dir, "missing.json"), "", "", "key"))
+	assert.Equal(t, DefaultUrl, c.url)
+	assert.Equal(t, "key", c.key)
+
+	os.WriteFile(path, []byte(`{`), 0600)
+	_, err = LoadProfiles(path)
+	assert.NotNil(t, err)
+}
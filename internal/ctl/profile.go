@@ -0,0 +1,131 @@
+package ctl
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/moson-mo/goaurrpc/internal/config"
+)
+
+/*
+	Server addresses and admin API keys are read from a profile file:
+
+	{
+		"Default": "local",
+		"Profiles": {
+			"local": {
+				"Url": "http://localhost:10666",
+				"APIKey": "change-me"
+			},
+			"remote": {
+				"Url": "https://aur.example.com:10669",
+				"APIKeyFile": "/path/to/key",
+				"CAFile": "/path/to/ca.pem",
+				"CertFile": "/path/to/admin.pem",
+				"KeyFile": "/path/to/admin.key"
+			}
+		}
+	}
+*/
+
+// DefaultUrl is used when no profile and no -url is given
+const DefaultUrl = "http://localhost:10666"
+
+// Profile holds the address and admin API key of a goaurrpc instance
+type Profile struct {
+	Url        string
+	APIKey     string `json:",omitempty"`
+	APIKeyFile string `json:",omitempty"` // read the key from a file instead
+	CAFile     string `json:",omitempty"` // CA certificates to verify the server with
+	CertFile   string `json:",omitempty"` // client certificate (AdminClientCAFile)
+	KeyFile    string `json:",omitempty"`
+}
+
+// ProfileFile holds our profiles
+type ProfileFile struct {
+	Default  string
+	Profiles map[string]Profile
+}
+
+// DefaultProfilePath returns the location of our profile file: $XDG_CONFIG_HOME/goaurrpc/ctl.json
+func DefaultProfilePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "goaurrpc", "ctl.json")
+}
+
+// LoadProfiles reads a profile file
+func LoadProfiles(path string) (*ProfileFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pf ProfileFile
+	if err = json.Unmarshal(b, &pf); err != nil {
+		return nil, errors.New("ctl: " + path + ": " + err.Error())
+	}
+	return &pf, nil
+}
+
+// Profile returns a profile by name. An empty name selects the default profile
+func (pf *ProfileFile) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = pf.Default
+	}
+	if name == "" && len(pf.Profiles) == 1 {
+		for n := range pf.Profiles {
+			name = n
+		}
+	}
+	p, ok := pf.Profiles[name]
+	if !ok {
+		return Profile{}, errors.New("ctl: profile '" + name + "' not found. Available: " + strings.Join(pf.names(), ", "))
+	}
+	if p.APIKey == "" && p.APIKeyFile != "" {
+		b, err := os.ReadFile(p.APIKeyFile)
+		if err != nil {
+			return Profile{}, errors.New("ctl: profile '" + name + "': " + err.Error())
+		}
+		p.APIKey = strings.TrimRight(string(b), "\r\n")
+	}
+	return p, nil
+}
+
+// returns the TLS config for our CA and client certificate; nil if there are none
+func (p Profile) tlsConfig() (*tls.Config, error) {
+	if p.CAFile == "" && p.CertFile == "" {
+		return nil, nil
+	}
+	tc := &tls.Config{}
+	if p.CAFile != "" {
+		pool, err := config.LoadCertPool(p.CAFile)
+		if err != nil {
+			return nil, errors.New("ctl: " + err.Error())
+		}
+		tc.RootCAs = pool
+	}
+	if p.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+		if err != nil {
+			return nil, errors.New("ctl: client certificate: " + err.Error())
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+func (pf *ProfileFile) names() []string {
+	names := make([]string, 0, len(pf.Profiles))
+	for name := range pf.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
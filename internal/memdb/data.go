@@ -9,6 +9,8 @@ type MemoryDB struct {
 	PackageSlice        []*PackageInfo
 	PackageDescriptions []PackageDescription
 	References          map[string][]*PackageInfo
+	ETag                string // ETag of the upstream file (if loaded from a URL)
+	DownloadSize        int    // number of bytes downloaded (if loaded from a URL)
 }
 
 // PackageInfo is a data structure holding data for a single package
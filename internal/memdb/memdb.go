@@ -2,6 +2,7 @@ package memdb
 
 import (
 	"compress/gzip"
+	"context"
 	"errors"
 	"io"
 	"os"
@@ -10,14 +11,17 @@ import (
 	"time"
 
 	"github.com/moson-mo/goaurrpc/internal/aur"
+	"github.com/moson-mo/goaurrpc/internal/tracing"
 
 	"github.com/goccy/go-json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// LoadDbFromFile loads package data from local JSON file
-func LoadDbFromFile(path string, lastmod time.Time) (*MemoryDB, time.Time, error) {
-	var b []byte
+var tracer = tracing.Tracer("github.com/moson-mo/goaurrpc/internal/memdb")
 
+// LoadDbFromFile loads package data from local JSON file
+func LoadDbFromFile(ctx context.Context, path string, lastmod time.Time) (*MemoryDB, time.Time, error) {
 	file, err := os.Stat(path)
 	if err != nil {
 		return nil, lastmod, err
@@ -27,29 +31,12 @@ func LoadDbFromFile(path string, lastmod time.Time) (*MemoryDB, time.Time, error
 		return nil, lastmod, errors.New("not modified")
 	}
 
-	if strings.HasSuffix(path, ".gz") {
-		gz, err := os.Open(path)
-		if err != nil {
-			return nil, lastmod, err
-		}
-		defer gz.Close()
-		r, err := gzip.NewReader(gz)
-		if err != nil {
-			return nil, lastmod, err
-		}
-		b, err = io.ReadAll(r)
-		if err != nil {
-			return nil, lastmod, err
-		}
-	} else {
-		var err error
-		b, err = os.ReadFile(path)
-		if err != nil {
-			return nil, lastmod, err
-		}
+	b, err := readFile(ctx, path)
+	if err != nil {
+		return nil, lastmod, err
 	}
 
-	memdb, err := bytesToMemoryDB(b)
+	memdb, err := bytesToMemoryDB(ctx, b)
 	if err != nil {
 		return nil, lastmod, err
 	}
@@ -57,28 +44,70 @@ func LoadDbFromFile(path string, lastmod time.Time) (*MemoryDB, time.Time, error
 	return memdb, file.ModTime(), nil
 }
 
+// reads a (gzip compressed) file
+func readFile(ctx context.Context, path string) ([]byte, error) {
+	name := "read"
+	if strings.HasSuffix(path, ".gz") {
+		name = "decompress"
+	}
+	_, span := tracer.Start(ctx, name, trace.WithAttributes(attribute.String("file.path", path)))
+	defer span.End()
+
+	if name == "read" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, tracing.Error(span, err)
+		}
+		return b, nil
+	}
+
+	gz, err := os.Open(path)
+	if err != nil {
+		return nil, tracing.Error(span, err)
+	}
+	defer gz.Close()
+	r, err := gzip.NewReader(gz)
+	if err != nil {
+		return nil, tracing.Error(span, err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, tracing.Error(span, err)
+	}
+	return b, nil
+}
+
 // LoadDbFromUrl loads package data from web hosted file (packages-meta-ext-v1.json.gz)
-func LoadDbFromUrl(url string, lastmod time.Time) (*MemoryDB, time.Time, error) {
-	b, newmod, err := aur.DownloadPackageData(url, lastmod)
+func LoadDbFromUrl(ctx context.Context, url string, lastmod time.Time) (*MemoryDB, time.Time, error) {
+	d, err := aur.DownloadPackageData(ctx, url, lastmod)
 	if err != nil {
 		return nil, lastmod, err
 	}
-	memdb, err := bytesToMemoryDB(b)
+	memdb, err := bytesToMemoryDB(ctx, d.Data)
 	if err != nil {
 		return nil, lastmod, err
 	}
-	return memdb, newmod, nil
+	memdb.ETag = d.ETag
+	memdb.DownloadSize = d.Size
+	return memdb, d.LastModified, nil
 }
 
 // constructs MemoryDB struct
-func bytesToMemoryDB(b []byte) (*MemoryDB, error) {
+func bytesToMemoryDB(ctx context.Context, b []byte) (*MemoryDB, error) {
 	db := MemoryDB{}
+	_, span := tracer.Start(ctx, "decode", trace.WithAttributes(attribute.Int("size", len(b))))
 	err := json.Unmarshal(b, &db.PackageSlice)
 	if err != nil {
+		tracing.Error(span, err)
+		span.End()
 		return nil, err
 	}
+	span.SetAttributes(attribute.Int("packages", len(db.PackageSlice)))
+	span.End()
 
+	_, span = tracer.Start(ctx, "build index")
 	db.fillHelperVars()
+	span.End()
 
 	return &db, nil
 }
@@ -1,10 +1,12 @@
 package memdb
 
 import (
+	"compress/gzip"
 	"context"
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -18,16 +20,16 @@ func TestLoadDbFromFile(t *testing.T) {
 	}
 
 	for _, file := range files {
-		db, _, err := LoadDbFromFile(file, time.Time{})
+		db, _, err := LoadDbFromFile(context.Background(), file, time.Time{})
 		assert.Nil(t, err, err)
 		assert.NotNil(t, db)
 		assert.Equal(t, 666, len(db.PackageNames), "Number of packages don't match")
 	}
 
 	// modified test
-	_, mod, _ := LoadDbFromFile(files[0], time.Time{})
+	_, mod, _ := LoadDbFromFile(context.Background(), files[0], time.Time{})
 	assert.NotEqual(t, mod, time.Time{}, "Modified date should be different")
-	_, nmod, err := LoadDbFromFile(files[0], mod)
+	_, nmod, err := LoadDbFromFile(context.Background(), files[0], mod)
 	assert.NotNil(t, err)
 	assert.Equal(t, nmod, mod)
 
@@ -39,7 +41,7 @@ func TestLoadDbFromFile(t *testing.T) {
 	}
 
 	for _, file := range brokenFiles {
-		db, _, err := LoadDbFromFile(file, time.Time{})
+		db, _, err := LoadDbFromFile(context.Background(), file, time.Time{})
 		assert.NotNil(t, err)
 		assert.Nil(t, db)
 	}
@@ -56,6 +58,14 @@ func TestLoadDbFromUrl(t *testing.T) {
 				w.Write(b[:42])
 				return
 			}
+			w.Header().Set("ETag", `"test"`)
+			if r.URL.Query().Get("gzip") == "yes" && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+				w.Header().Set("Content-Encoding", "gzip")
+				gz := gzip.NewWriter(w)
+				gz.Write(b)
+				gz.Close()
+				return
+			}
 			w.Write(b)
 		}),
 	}
@@ -63,30 +73,31 @@ func TestLoadDbFromUrl(t *testing.T) {
 	go httpSrv.Serve(l)
 	defer httpSrv.Shutdown(context.TODO())
 
-	urls := []string{"http://127.0.0.1:10669"}
+	urls := []string{"http://127.0.0.1:10669", "http://127.0.0.1:10669?gzip=yes"}
 
 	for _, url := range urls {
-		db, _, err := LoadDbFromUrl(url, time.Time{})
+		db, _, err := LoadDbFromUrl(context.Background(), url, time.Time{})
 		assert.Nil(t, err, err)
 		assert.NotNil(t, db)
 		assert.Equal(t, 666, len(db.PackageNames), "Number of packages don't match")
+		assert.Equal(t, `"test"`, db.ETag)
 	}
 
 	brokenUrls := []string{"https://sdfsdfhahdfagdfgdgdfgdg.agag/raw/main/test_data/test_packages.json", "http://127.0.0.1:10669?nonsense=yes"}
 
 	for _, url := range brokenUrls {
-		db, _, err := LoadDbFromUrl(url, time.Time{})
+		db, _, err := LoadDbFromUrl(context.Background(), url, time.Time{})
 		assert.NotNil(t, err)
 		assert.Nil(t, db)
 	}
 }
 
 func TestBytesToMemory(t *testing.T) {
-	db, err := bytesToMemoryDB([]byte("nonsense"))
+	db, err := bytesToMemoryDB(context.Background(), []byte("nonsense"))
 	assert.Nil(t, db)
 	assert.NotNil(t, err)
 
-	db, err = bytesToMemoryDB([]byte("[{\"Name\":\"testpkg\"}]"))
+	db, err = bytesToMemoryDB(context.Background(), []byte("[{\"Name\":\"testpkg\"}]"))
 	assert.NotNil(t, db)
 	assert.Nil(t, err, err)
 }
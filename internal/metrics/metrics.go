@@ -1,67 +1,166 @@
 package metrics
 
 import (
+	"runtime"
+
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
-var (
-	// Metrics to collect
-	Requests = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "rpc_requests",
-			Help: "Number of /rpc requests per method, type and \"by\" parameter.",
-		},
-		[]string{"method", "type", "by"},
-	)
-	RequestErrors = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "rpc_requests_error",
-			Help: "Number of /rpc requests that resulted in an error.",
-		},
-		[]string{"error"},
-	)
-	RateLimited = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: "rpc_requests_rate_limited",
-			Help: "Number of /rpc requests that ran into the rate-limit.",
-		},
-	)
-	HttpDuration = promauto.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "rpc_request_duration_seconds",
-			Help:    "Duration of /rpc requests.",
-			Buckets: []float64{0.0001, 0.0003, 0.0005, 0.0007, 0.0009, 0.001, 0.003, 0.005, 0.01, 0.03, 0.05, 0.07, 0.1, 0.5, 1, 10},
-		},
-		[]string{})
-	LastRefresh = prometheus.NewGauge(
-		prometheus.GaugeOpts{
-			Name: "rpc_data_last_refresh",
-			Help: "Last metadata refresh.",
-		},
-	)
-	ResponseSize = promauto.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "rpc_response_size_bytes",
-			Help:    "Response size of /rpc requests.",
-			Buckets: []float64{500, 1000, 5000, 10000, 50000, 100000, 1000000, 2000000},
-		},
-		[]string{"type"})
-	CacheHits = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: "rpc_cache_hits",
-			Help: "Number of search requests that could be served by a cache entry.",
-		},
+// Registry holds the metrics we collect for a server
+type Registry struct {
+	*prometheus.Registry
+
+	Requests       *prometheus.CounterVec
+	RequestErrors  *prometheus.CounterVec
+	RateLimited    prometheus.Counter
+	HttpDuration   *prometheus.HistogramVec
+	LastRefresh    prometheus.Gauge
+	DataPackages   prometheus.Gauge
+	ReloadDuration prometheus.Histogram
+	Reloads        *prometheus.CounterVec
+	UpstreamBytes  prometheus.Counter
+	ResponseSize   *prometheus.HistogramVec
+	CacheHits      prometheus.Counter
+	CacheEvictions prometheus.Counter
+	ApiKeyRequests *prometheus.CounterVec
+}
+
+// State provides the values of gauges that reflect the state of a server
+type State struct {
+	CacheEntries     func() int
+	RateLimitEntries func() int
+	DataStale        func() int // 1 if package data is older than DataStaleThreshold or has not been loaded
+}
+
+// NewRegistry returns a registry with the metrics we want to collect, the state gauges of a server,
+// Go runtime and process metrics and goaurrpc_build_info.
+// Each server has its own registry; the default prometheus registry is not used
+func NewRegistry(version string, state State) *Registry {
+	r := &Registry{
+		Registry: prometheus.NewRegistry(),
+		Requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rpc_requests",
+				Help: "Number of /rpc requests per method, type and \"by\" parameter.",
+			},
+			[]string{"method", "type", "by"},
+		),
+		RequestErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rpc_requests_error",
+				Help: "Number of /rpc requests that resulted in an error.",
+			},
+			[]string{"error"},
+		),
+		RateLimited: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "rpc_requests_rate_limited",
+				Help: "Number of /rpc requests that ran into the rate-limit.",
+			},
+		),
+		HttpDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "rpc_request_duration_seconds",
+				Help:    "Duration of /rpc requests per type, \"by\" parameter and API version. Rejected requests have empty labels.",
+				Buckets: []float64{0.0001, 0.0003, 0.0005, 0.0007, 0.0009, 0.001, 0.003, 0.005, 0.01, 0.03, 0.05, 0.07, 0.1, 0.5, 1, 10},
+			},
+			[]string{"type", "by", "version"},
+		),
+		LastRefresh: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "rpc_data_last_refresh",
+				Help: "Last metadata refresh.",
+			},
+		),
+		DataPackages: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "rpc_data_packages",
+				Help: "Number of packages in the loaded metadata.",
+			},
+		),
+		ReloadDuration: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "rpc_data_reload_duration_seconds",
+				Help:    "Duration of metadata reloads (including downloads).",
+				Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+			},
+		),
+		Reloads: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rpc_data_reloads",
+				Help: "Number of metadata reloads per result (ok, error, not modified).",
+			},
+			[]string{"result"},
+		),
+		UpstreamBytes: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "rpc_data_download_bytes",
+				Help: "Number of bytes downloaded from the AUR (as transferred, possibly compressed).",
+			},
+		),
+		ResponseSize: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "rpc_response_size_bytes",
+				Help:    "Response size of /rpc requests.",
+				Buckets: []float64{500, 1000, 5000, 10000, 50000, 100000, 1000000, 2000000},
+			},
+			[]string{"type"},
+		),
+		CacheHits: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "rpc_cache_hits",
+				Help: "Number of search requests that could be served by a cache entry.",
+			},
+		),
+		CacheEvictions: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "rpc_cache_evictions",
+				Help: "Number of search-cache entries that were removed because they expired or were purged by an admin.",
+			},
+		),
+		ApiKeyRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rpc_requests_api_key",
+				Help: "Number of requests per API key label.",
+			},
+			[]string{"label"},
+		),
+	}
+	r.MustRegister(
+		r.Requests,
+		r.RequestErrors,
+		r.RateLimited,
+		r.HttpDuration,
+		r.LastRefresh,
+		r.DataPackages,
+		r.ReloadDuration,
+		r.Reloads,
+		r.UpstreamBytes,
+		r.ResponseSize,
+		r.CacheHits,
+		r.CacheEvictions,
+		r.ApiKeyRequests,
+		gaugeFunc("rpc_cache_entries", "Number of entries in the search-cache.", state.CacheEntries),
+		gaugeFunc("rpc_rate_limit_entries", "Number of clients in the rate-limit table.", state.RateLimitEntries),
+		gaugeFunc("rpc_data_stale", "1 if the package data has not been loaded or is older than DataStaleThreshold (/readyz fails), 0 otherwise.", state.DataStale),
+		prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name:        "goaurrpc_build_info",
+				Help:        "A metric with a constant '1' value labeled by version and Go version goaurrpc was built with.",
+				ConstLabels: prometheus.Labels{"version": version, "goversion": runtime.Version()},
+			},
+			func() float64 { return 1 },
+		),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 	)
-)
+	return r
+}
 
-// RegisterMetrics registers the different metrics that we want to collect
-func RegisterMetrics() {
-	prometheus.Register(Requests)
-	prometheus.Register(RequestErrors)
-	prometheus.Register(RateLimited)
-	prometheus.Register(HttpDuration)
-	prometheus.Register(LastRefresh)
-	prometheus.Register(ResponseSize)
-	prometheus.Register(CacheHits)
+func gaugeFunc(name, help string, fn func() int) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{Name: name, Help: help},
+		func() float64 { return float64(fn()) },
+	)
 }
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRegistry(t *testing.T) {
+	// each server gets its own registry, so they can exist side by side
+	for i := 0; i < 3; i++ {
+		i := i
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Parallel()
+			reg := NewRegistry("v"+strconv.Itoa(i), State{
+				CacheEntries:     func() int { return i },
+				RateLimitEntries: func() int { return 2 * i },
+				DataStale:        func() int { return 1 },
+			})
+
+			families, err := reg.Gather()
+			assert.Nil(t, err)
+			values := make(map[string]float64)
+			for _, mf := range families {
+				if m := mf.GetMetric(); len(m) == 1 && m[0].GetGauge() != nil {
+					values[mf.GetName()] = m[0].GetGauge().GetValue()
+				}
+				if mf.GetName() == "goaurrpc_build_info" {
+					assert.Equal(t, "v"+strconv.Itoa(i), mf.GetMetric()[0].GetLabel()[1].GetValue())
+				}
+			}
+			assert.Equal(t, float64(1), values["goaurrpc_build_info"])
+			assert.Equal(t, float64(i), values["rpc_cache_entries"])
+			assert.Equal(t, float64(2*i), values["rpc_rate_limit_entries"])
+			assert.Equal(t, float64(1), values["rpc_data_stale"])
+
+			// collectors are not shared between registries
+			reg.CacheEvictions.Add(float64(i))
+			assert.Equal(t, float64(i), testutil.ToFloat64(reg.CacheEvictions))
+
+			n, err := testutil.GatherAndCount(reg, "rpc_requests", "rpc_data_reloads")
+			assert.Nil(t, err)
+			assert.Equal(t, 0, n)
+		})
+	}
+}
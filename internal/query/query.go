@@ -0,0 +1,178 @@
+package query
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/moson-mo/goaurrpc/internal/config"
+	"github.com/moson-mo/goaurrpc/internal/memdb"
+	"github.com/moson-mo/goaurrpc/internal/rpc"
+)
+
+/*
+	goaurrpc query runs info, search and suggest queries against a local copy of the metadata file.
+	Queries are performed with the same logic as /rpc requests.
+*/
+
+// Output formats
+const (
+	OutputTable  = "table"
+	OutputJSON   = "json"
+	OutputNDJSON = "ndjson"
+)
+
+// DefaultFile is the metadata file we load if -f is not given
+const DefaultFile = "packages-meta-ext-v1.json.gz"
+
+// query types we support
+var types = []string{"info", "search", "msearch", "suggest", "suggest-pkgbase"}
+
+// Run executes goaurrpc query with the given arguments and returns the exit code:
+// 0 on success, 1 on errors and 2 for invalid arguments
+func Run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("goaurrpc query", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	file := fs.String("f", DefaultFile, "Metadata `file` (JSON, optionally gzipped)")
+	by := fs.String("by", "", "Search/info by `field` (name, name-desc, maintainer, depends, makedepends, optdepends, checkdepends, provides, conflicts, replaces, keywords, groups, submitter, comaintainers)")
+	mode := fs.String("mode", "", "Search `mode` (contains or starts-with; API version 6 only)")
+	version := fs.Int("api-version", 5, "API `version` (5 or 6)")
+	maxResults := fs.Int("max-results", config.DefaultSettings().MaxResults, "Maximum number of results")
+	output := fs.String("o", OutputTable, "Output `format` (table, json or ndjson)")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: goaurrpc query [flags] <%s> <arg>...\n\nFlags:\n", strings.Join(types, "|"))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() < 2 || !inSlice(types, fs.Arg(0)) {
+		fs.Usage()
+		return 2
+	}
+	if *output != OutputTable && *output != OutputJSON && *output != OutputNDJSON {
+		fmt.Fprintln(stderr, "error: invalid output format '"+*output+"'. Use table, json or ndjson")
+		return 2
+	}
+
+	db, _, err := memdb.LoadDbFromFile(context.Background(), *file, time.Time{})
+	if err != nil {
+		fmt.Fprintln(stderr, "error: loading metadata:", err)
+		return 1
+	}
+
+	params := url.Values{}
+	params.Set("v", strconv.Itoa(*version))
+	params.Set("type", fs.Arg(0))
+	if *by != "" {
+		params.Set("by", *by)
+	}
+	if *mode != "" {
+		params.Set("mode", *mode)
+	}
+	setArgs(params, fs.Arg(0), *version, fs.Args()[1:])
+
+	result, err := rpc.Query(db, *maxResults, params)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+	if err = write(stdout, *output, result); err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+// sets the arguments like a client would send them
+func setArgs(params url.Values, rtype string, version int, args []string) {
+	switch {
+	case rtype == "info" && version == 6:
+		params["arg"] = args
+	case rtype == "info":
+		params["arg[]"] = args
+	default:
+		// multiple search terms are supported with version 6
+		params.Set("arg", strings.Join(args, " "))
+	}
+}
+
+// writes the result of a query in the given format
+func write(w io.Writer, output string, result any) error {
+	var items []any
+	switch r := result.(type) {
+	case rpc.RpcResult:
+		items = r.Results
+	case []string:
+		for _, name := range r {
+			items = append(items, name)
+		}
+	}
+
+	var b bytes.Buffer
+	switch output {
+	case OutputJSON:
+		j, err := json.MarshalIndent(result, "", "\t")
+		if err != nil {
+			return err
+		}
+		b.Write(append(j, '\n'))
+	case OutputNDJSON:
+		for _, item := range items {
+			j, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			b.Write(append(j, '\n'))
+		}
+	default:
+		if _, ok := result.([]string); ok {
+			for _, name := range items {
+				fmt.Fprintln(&b, name)
+			}
+			break
+		}
+		tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tVERSION\tDESCRIPTION")
+		for _, item := range items {
+			var pkg struct {
+				Name        string
+				Version     string
+				Description string
+			}
+			j, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if err = json.Unmarshal(j, &pkg); err != nil {
+				return err
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", pkg.Name, pkg.Version, pkg.Description)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	_, err := b.WriteTo(w)
+	return err
+}
+
+func inSlice(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
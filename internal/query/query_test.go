@@ -0,0 +1,78 @@
+package query
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+)
+
+const testFile = "../../test_data/test_packages.json"
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{[]string{"-by", "depends", "search", "caroline"}, 0,
+			"NAME           VERSION   DESCRIPTION\n" +
+				"attribute      8.14-10   This is a desciptive text for package attribute\n" +
+				"backgrounders  4.5.22-4  This is a desciptive text for package backgrounders\n", ""},
+		{[]string{"-o", "ndjson", "-by", "depends", "search", "caroline"}, 0, "", ""},
+		{[]string{"-o", "ndjson", "suggest", "attest"}, 0, "\"attest\"\n\"attestation\"\n\"attestations\"\n\"attested\"\n\"attesting\"\n\"attests\"\n", ""},
+		{[]string{"suggest-pkgbase", "attes"}, 0, "attest\nattestation\nattestations\nattested\nattesting\nattests\n", ""},
+		{[]string{"-api-version", "6", "-by", "depends", "info", "caroline", "reggies"}, 0, "", ""},
+		{[]string{"search", "a"}, 1, "", "error: Query arg too small.\n"},
+		{[]string{"-max-results", "3", "search", "at"}, 1, "", "error: Too many package results.\n"},
+		{[]string{"-f", "doesnotexist", "info", "attest"}, 1, "", ""},
+		{[]string{"-o", "yaml", "info", "attest"}, 2, "", ""},
+		{[]string{"unknown", "attest"}, 2, "", ""},
+		{[]string{"info"}, 2, "", ""},
+	}
+
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		code := Run(append([]string{"-f", testFile}, test.args...), &stdout, &stderr)
+		name := strings.Join(test.args, " ")
+
+		assert.Equal(t, test.code, code, name+": "+stderr.String())
+		if test.stdout != "" {
+			assert.Equal(t, test.stdout, stdout.String(), name)
+		}
+		if test.stderr != "" {
+			assert.Equal(t, test.stderr, stderr.String(), name)
+		}
+	}
+}
+
+func TestOutput(t *testing.T) {
+	// json: the same result as /rpc returns
+	var stdout bytes.Buffer
+	assert.Equal(t, 0, Run([]string{"-f", testFile, "-o", "json", "info", "attest", "attic"}, &stdout, &bytes.Buffer{}))
+	var result struct {
+		Resultcount int
+		Results     []struct{ Name string }
+		Type        string
+		Version     int
+	}
+	assert.Nil(t, json.Unmarshal(stdout.Bytes(), &result))
+	assert.Equal(t, 2, result.Resultcount)
+	assert.Equal(t, "multiinfo", result.Type)
+	assert.Equal(t, 5, result.Version)
+	assert.Equal(t, "attest", result.Results[0].Name)
+
+	// ndjson: one package per line
+	stdout.Reset()
+	assert.Equal(t, 0, Run([]string{"-f", testFile, "-o", "ndjson", "-api-version", "6", "-by", "depends", "info", "caroline", "reggies"}, &stdout, &bytes.Buffer{}))
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	assert.Len(t, lines, 4)
+	for _, line := range lines {
+		var pkg struct{ Name string }
+		assert.Nil(t, json.Unmarshal([]byte(line), &pkg))
+		assert.NotEmpty(t, pkg.Name)
+	}
+}
@@ -0,0 +1,128 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/moson-mo/goaurrpc/internal/tracing"
+
+	"github.com/goccy/go-json"
+)
+
+/*
+	Access log: an entry for each /rpc and /api request is written to AccessLogFile (or stdout) if AccessLog is enabled.
+	Formats:
+	combined: Apache combined log format, followed by latency (in ms), request type, search-cache and rate-limit outcome and trace ID:
+	          127.0.0.1 - - [02/Jan/2006:15:04:05 +0000] "GET /rpc?v=5&type=info&arg=yay HTTP/1.1" 200 412 "-" "curl/8.0" latency=0.153 type=info cache=- ratelimit=ok trace_id=-
+	json:     one AccessRecord per line
+*/
+
+// rate-limit outcomes
+const (
+	rateLimitOk           = "ok"
+	rateLimitLimited      = "limited"
+	rateLimitKey          = "key"
+	rateLimitKeyQuota     = "key-quota"
+	rateLimitKeyForbidden = "key-forbidden"
+	rateLimitKeyInvalid   = "key-invalid"
+)
+
+// accessInfo is filled in by our request handlers for the access log
+type accessInfo struct {
+	kind      string
+	cache     string
+	rateLimit string
+}
+
+type accessInfoKey struct{}
+
+// returns the access info of a request. Requests that don't pass our access middleware get a throwaway one
+func requestInfo(r *http.Request) *accessInfo {
+	if info, ok := r.Context().Value(accessInfoKey{}).(*accessInfo); ok {
+		return info
+	}
+	return &accessInfo{}
+}
+
+// accessMiddleware writes an access log entry for each request if the access log is enabled
+func (s *server) accessMiddleware(hf http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conf := s.conf()
+		if !conf.AccessLog {
+			hf(w, r)
+			return
+		}
+
+		start := time.Now()
+		info := &accessInfo{}
+		cw := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+		hf(cw, r.WithContext(context.WithValue(r.Context(), accessInfoKey{}, info)))
+
+		record := AccessRecord{
+			Time:      start,
+			Client:    getRealIP(r, conf.TrustedReverseProxies),
+			Method:    r.Method,
+			URI:       r.URL.RequestURI(),
+			Proto:     r.Proto,
+			Status:    cw.status,
+			Size:      cw.size,
+			Latency:   float64(time.Since(start).Microseconds()) / 1000,
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+			Type:      info.kind,
+			Cache:     info.cache,
+			RateLimit: info.rateLimit,
+			TraceID:   tracing.TraceID(r.Context()),
+		}
+		b, err := formatAccessRecord(record, conf.AccessLogFormat)
+		if err == nil {
+			if conf.AccessLogFile == "" {
+				_, err = os.Stdout.Write(b)
+			} else {
+				err = s.accessLog.write(conf.AccessLogFile, logRotation(conf, conf.LogMaxSize), b)
+			}
+		}
+		if err != nil {
+			s.log.main.Error("Error writing access log", "error", err)
+		}
+	}
+}
+
+// formats an access log entry (including the trailing newline)
+func formatAccessRecord(rec AccessRecord, format string) ([]byte, error) {
+	if format == "json" {
+		b, err := json.Marshal(rec)
+		return append(b, '\n'), err
+	}
+
+	size := "-"
+	if rec.Size > 0 {
+		size = strconv.Itoa(rec.Size)
+	}
+	return []byte(fmt.Sprintf("%s - - [%s] %q %d %s %q %q latency=%.3f type=%s cache=%s ratelimit=%s trace_id=%s\n",
+		rec.Client,
+		rec.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		rec.Method+" "+rec.URI+" "+rec.Proto,
+		rec.Status,
+		size,
+		dash(rec.Referer),
+		dash(rec.UserAgent),
+		rec.Latency,
+		dash(rec.Type),
+		dash(rec.Cache),
+		dash(rec.RateLimit),
+		dash(rec.TraceID),
+	)), nil
+}
+
+// returns "-" for empty values
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
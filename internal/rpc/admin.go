@@ -1,11 +1,19 @@
 package rpc
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/moson-mo/goaurrpc/internal/admin"
 	"github.com/moson-mo/goaurrpc/internal/config"
 	"github.com/moson-mo/goaurrpc/internal/consts"
 
@@ -13,16 +21,54 @@ import (
 	"github.com/goccy/go-json"
 )
 
-// middleware for authentication (API key)
+// AdminError is an error of the admin API with an HTTP status and a machine readable code
+type AdminError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *AdminError) Error() string {
+	return e.Message
+}
+
+var (
+	errUnauthorized     = &AdminError{http.StatusUnauthorized, "unauthorized", "Unauthorized"}
+	errMethodNotAllowed = &AdminError{http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed"}
+	errSettingNotFound  = &AdminError{http.StatusNotFound, "setting_not_found", "Setting not found"}
+	errJobNotFound      = &AdminError{http.StatusNotFound, "job_not_found", "Job not found"}
+	errNeedValue        = &AdminError{http.StatusBadRequest, "missing_value", "Need new value: ?value=..."}
+	errPersistDisabled  = &AdminError{http.StatusForbidden, "persist_disabled", "Persisting settings is disabled"}
+	errNoConfigFile     = &AdminError{http.StatusConflict, "no_config_file", "No config file specified"}
+)
+
+func errNotFound(message string) error {
+	return &AdminError{http.StatusNotFound, "not_found", message}
+}
+
+func errMissingValue(message string) error {
+	return &AdminError{http.StatusBadRequest, "missing_value", message}
+}
+
+func errInvalidValue(message string) error {
+	return &AdminError{http.StatusBadRequest, "invalid_value", message}
+}
+
+// middleware for authentication (API key and, with AdminClientCAFile, a client certificate)
 func (s *server) adminMiddleware(hf http.HandlerFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conf := s.conf()
 		key := r.Header.Get("APIKey")
 
 		// check api key
-		if key != s.conf.AdminAPIKey {
-			w.Header().Set("Content-Type", consts.ContentTypeText)
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("Unauthorized"))
+		if key != conf.AdminAPIKey {
+			sendAdminError(w, r, errUnauthorized)
+			return
+		}
+
+		// check client certificate
+		if conf.AdminClientCAFile != "" && !hasClientCert(r) {
+			sendAdminError(w, r, errUnauthorized)
 			return
 		}
 
@@ -30,257 +76,617 @@ func (s *server) adminMiddleware(hf http.HandlerFunc) http.Handler {
 	})
 }
 
+// admin job; returns a response or an error
+type adminJob func() (admin.Response, error)
+
+// returns the jobs that can be run via /admin/run-job/{name}
+func (s *server) adminJobs() map[string]adminJob {
+	return map[string]adminJob{
+		"reload-data": func() (admin.Response, error) {
+			err := s.reloadData(context.Background())
+			switch {
+			case err == nil:
+				return admin.Response{Message: "Successfully reloaded data"}, nil
+			case err.Error() == "not modified":
+				return admin.Response{Code: "not_modified", Message: "Reload skipped. Data has not changed"}, nil
+			case errors.Is(err, ErrReloadInProgress):
+				return admin.Response{}, &AdminError{http.StatusConflict, "reload_in_progress", err.Error()}
+			}
+			return admin.Response{}, &AdminError{http.StatusInternalServerError, "reload_failed", err.Error()}
+		},
+		"reload-config": func() (admin.Response, error) {
+			changes, restart, err := s.reloadConfig()
+			switch {
+			case errors.Is(err, ErrNoConfigFile):
+				return admin.Response{}, errNoConfigFile
+			case err != nil:
+				return admin.Response{}, &AdminError{http.StatusInternalServerError, "invalid_config", err.Error()}
+			}
+
+			res := admin.Response{
+				Message:         "Reloaded config file (" + strconv.Itoa(len(changes)) + " settings changed)",
+				Changes:         changes,
+				RestartRequired: restart,
+			}
+			for _, change := range changes {
+				res.Message += "\nChanged '" + change.Setting + "' from '" + fmt.Sprint(change.Old) + "' to '" + fmt.Sprint(change.New) + "'"
+			}
+			if len(restart) > 0 {
+				res.Warning = "Restart required to apply: " + strings.Join(restart, ", ")
+				res.Message += "\nWARNING: " + res.Warning
+			}
+			return res, nil
+		},
+		"wipe-cache": func() (admin.Response, error) {
+			numEntries := s.wipeSearchCache()
+			return admin.Response{Message: "Wiped search-cache (" + strconv.Itoa(numEntries) + " entries removed)", Value: numEntries}, nil
+		},
+		"wipe-ratelimits": func() (admin.Response, error) {
+			numEntries := s.wipeRateLimits()
+			return admin.Response{Message: "Wiped rate-limits (" + strconv.Itoa(numEntries) + " entries removed)", Value: numEntries}, nil
+		},
+		"cleanup-cache": func() (admin.Response, error) {
+			s.cleanupSearchCache()
+			return admin.Response{Message: "Cleaned up search-cache"}, nil
+		},
+		"cleanup-ratelimits": func() (admin.Response, error) {
+			s.cleanupRateLimits()
+			return admin.Response{Message: "Cleaned up rate-limits"}, nil
+		},
+	}
+}
+
 // handles jobs
 func (s *server) handleAdminJobs(w http.ResponseWriter, r *http.Request) {
 	name := chi.URLParam(r, "name")
 
-	switch name {
-	case "reload-data":
-		err := s.reloadData()
-		if err != nil {
-			if err.Error() == "not modified" {
-				sendAdminOk("Reload skipped. Data has not changed", w)
+	job, ok := s.adminJobs()[name]
+	if !ok {
+		sendAdminError(w, r, errJobNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		sendAdminError(w, r, errMethodNotAllowed)
+		return
+	}
+
+	// run job in the background
+	if r.URL.Query().Get("async") == "true" {
+		go func() {
+			res, err := job()
+			if err != nil {
+				s.log.admin.Error("Error running job", "job", name, "error", err)
 				return
 			}
-			sendAdminError(err.Error(), w)
-			return
-		}
-		sendAdminOk("Successfully reloaded data", w)
-	case "wipe-cache":
-		numEntries := s.wipeSearchCache()
-		sendAdminOk("Wiped search-cache ("+strconv.Itoa(numEntries)+" entries removed)", w)
-	case "wipe-ratelimits":
-		numEntries := s.wipeRateLimits()
-		sendAdminOk("Wiped rate-limits ("+strconv.Itoa(numEntries)+" entries removed)", w)
-	case "cleanup-cache":
-		s.cleanupSearchCache()
-		sendAdminOk("Cleaned up search-cache", w)
-	case "cleanup-ratelimits":
-		s.cleanupRateLimits()
-		sendAdminOk("Cleaned up rate-limits", w)
-	default:
-		w.Header().Set("Content-Type", consts.ContentTypeText)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Job not found"))
+			s.log.admin.Info("Finished job", "job", name, "result", res.Message)
+		}()
+		sendAdminResult(w, r, http.StatusAccepted, admin.Response{Status: "accepted", Message: "Started job '" + name + "'"})
+		return
+	}
+
+	res, err := job()
+	if err != nil {
+		sendAdminError(w, r, err)
+		return
 	}
+	res.Status = "ok"
+	sendAdminResult(w, r, http.StatusOK, res)
 }
 
 // handles settings
 func (s *server) handleAdminSettings(w http.ResponseWriter, r *http.Request) {
 	name := chi.URLParam(r, "name")
-	isPost := r.Method == "POST"
-	value := r.URL.Query().Get("value")
 
-	// update and return settings in JSON format
-	if strings.TrimRight(r.URL.Path, "/") == "/admin/settings" && !isPost {
-		sendSettings(s.conf, w)
+	if strings.TrimRight(r.URL.Path, "/") == "/admin/settings" {
+		switch r.Method {
+		case "GET":
+			// return settings in JSON format
+			sendAdminJson(*s.conf(), w)
+		case "PATCH":
+			s.patchSettings(w, r)
+		default:
+			sendAdminError(w, r, errMethodNotAllowed)
+		}
+		return
+	}
+
+	if name == "persist" {
+		s.handleAdminPersist(w, r)
+		return
+	}
+
+	rs, ok := findRuntimeSetting(name)
+	if !ok {
+		sendAdminError(w, r, errSettingNotFound)
 		return
 	}
 
-	// update / send individual option
-	s.sendChangeOption(name, value, isPost, w)
+	switch r.Method {
+	case "GET":
+		val := rs.get(s.conf())
+		sendAdminResult(w, r, http.StatusOK, admin.Response{
+			Status:  "ok",
+			Message: "Current setting for '" + rs.field + "' is '" + fmt.Sprint(val) + "'",
+			Setting: rs.field,
+			Value:   val,
+		})
+	case "POST":
+		value := r.URL.Query().Get("value")
+		s.log.admin.Info("Admin initiated change of setting", "setting", name, "value", value)
+		if value == "" {
+			sendAdminError(w, r, errNeedValue)
+			return
+		}
+
+		var change admin.SettingChange
+		err := s.changeSettings(func(c *config.Settings) error {
+			change = admin.SettingChange{Setting: rs.field, Old: rs.get(c)}
+			if err := rs.set(c, value); err != nil {
+				return errInvalidValue(err.Error())
+			}
+			change.New = rs.get(c)
+			return nil
+		})
+		if err != nil {
+			sendAdminError(w, r, asAdminError(err))
+			return
+		}
+
+		res := admin.Response{
+			Status:  "ok",
+			Message: "Changed '" + rs.field + "' from '" + fmt.Sprint(change.Old) + "' to '" + fmt.Sprint(change.New) + "'",
+			Setting: change.Setting,
+			Old:     change.Old,
+			New:     change.New,
+			Warning: settingWarning(change),
+		}
+		if res.Warning != "" {
+			res.Message += "\nWARNING: " + res.Warning
+		}
+		s.persistChanges(&res)
+		sendAdminResult(w, r, http.StatusOK, res)
+	default:
+		sendAdminError(w, r, errMethodNotAllowed)
+	}
 }
 
-// converts query param to int. Don't allow 0
-func convValueToInt(value string) (int, error) {
-	ival, err := strconv.Atoi(value)
+// changes multiple settings at once. Either all or none of the changes are applied
+func (s *server) patchSettings(w http.ResponseWriter, r *http.Request) {
+	var values map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		sendAdminError(w, r, &AdminError{http.StatusBadRequest, "invalid_body", "Invalid JSON body: " + err.Error()})
+		return
+	}
+	if len(values) == 0 {
+		sendAdminError(w, r, &AdminError{http.StatusBadRequest, "invalid_body", "No settings provided"})
+		return
+	}
+
+	// sort to get a stable order of changes
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	s.log.admin.Info("Admin initiated change of settings", "settings", fields)
+
+	var changes []admin.SettingChange
+	err := s.changeSettings(func(c *config.Settings) error {
+		changes = nil
+		for _, field := range fields {
+			rs, ok := findRuntimeSetting(field)
+			if !ok || rs.field != field {
+				return &AdminError{http.StatusBadRequest, "setting_not_changeable", "Setting '" + field + "' can not be changed at runtime"}
+			}
+
+			// we accept JSON strings, numbers and booleans
+			value := string(values[field])
+			var str string
+			if json.Unmarshal(values[field], &str) == nil {
+				value = str
+			}
+
+			old := rs.get(c)
+			if err := rs.set(c, value); err != nil {
+				return errInvalidValue("'" + field + "': " + err.Error())
+			}
+			changes = append(changes, admin.SettingChange{Setting: field, Old: old, New: rs.get(c)})
+		}
+		return nil
+	})
 	if err != nil {
-		return 0, err
+		sendAdminError(w, r, asAdminError(err))
+		return
 	}
 
-	if ival == 0 {
-		return 0, errors.New("Value can not be 0")
+	res := admin.Response{
+		Status:  "ok",
+		Message: "Changed " + strconv.Itoa(len(changes)) + " settings",
+		Changes: changes,
+	}
+	for _, change := range changes {
+		res.Message += "\nChanged '" + change.Setting + "' from '" + fmt.Sprint(change.Old) + "' to '" + fmt.Sprint(change.New) + "'"
+		if warning := settingWarning(change); warning != "" {
+			res.Warning = warning
+			res.Message += "\nWARNING: " + warning
+		}
 	}
+	s.persistChanges(&res)
+	sendAdminResult(w, r, http.StatusOK, res)
+}
 
-	return ival, nil
+// writes the current settings to our config file
+func (s *server) handleAdminPersist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		sendAdminError(w, r, errMethodNotAllowed)
+		return
+	}
+	if !s.conf().PersistSettings {
+		sendAdminError(w, r, errPersistDisabled)
+		return
+	}
+	if s.configFile == "" {
+		sendAdminError(w, r, errNoConfigFile)
+		return
+	}
+
+	s.log.admin.Info("Admin initiated persisting of settings")
+	skipped, err := s.persistSettings()
+	if err != nil {
+		sendAdminError(w, r, err)
+		return
+	}
+	res := admin.Response{
+		Status:    "ok",
+		Message:   "Persisted settings to '" + s.configFile + "'",
+		Persisted: true,
+	}
+	s.notPersisted(&res, skipped)
+	sendAdminResult(w, r, http.StatusOK, res)
 }
 
-// send settings in JSON format
-func sendSettings(settings config.Settings, w http.ResponseWriter) {
-	b, err := json.MarshalIndent(settings, "", "\t")
+// writes changed settings to our config file if enabled
+func (s *server) persistChanges(res *admin.Response) {
+	if !s.conf().PersistSettings || s.configFile == "" {
+		return
+	}
+
+	overridden, err := s.persistSettings()
 	if err != nil {
-		sendAdminError(err.Error(), w)
+		s.log.admin.Error("Error persisting settings", "error", err)
+		addWarning(res, "Settings could not be persisted: "+err.Error())
 		return
 	}
+	res.Persisted = true
 
-	w.Header().Set("Content-Type", consts.ContentTypeJson)
-	w.Write(b)
+	// we only report the changed settings that could not be written
+	var skipped []string
+	for _, field := range overridden {
+		if field == res.Setting || slices.ContainsFunc(res.Changes, func(c admin.SettingChange) bool { return c.Setting == field }) {
+			skipped = append(skipped, field)
+		}
+	}
+	s.notPersisted(res, skipped)
 }
 
-func (s *server) sendChangeOption(name, value string, isPost bool, w http.ResponseWriter) {
-	s.LogVerbose("Admin initiated change of setting '" + name + "' to '" + value + "'")
-
-	// get/set individual option
-	switch name {
-	case "aur-file-location":
-		pval := s.conf.AurFileLocation
-		if isPost {
-			if value != "" {
-				s.conf.AurFileLocation = value
-				sendAdminOk("Changed 'AurFileLocation' from '"+pval+"' to '"+value+"'", w)
-			} else {
-				sendAdminError("Need new value: ?value=...", w)
-			}
+// reports settings that were not written to our config file because they are overridden by environment variables or flags
+func (s *server) notPersisted(res *admin.Response, skipped []string) {
+	if len(skipped) == 0 {
+		return
+	}
+	s.log.admin.Warn("Settings overridden by environment variables or flags were not persisted", "settings", skipped)
+	res.NotPersisted = skipped
+	addWarning(res, "Settings overridden by environment variables or flags were not persisted: "+strings.Join(skipped, ", "))
+}
+
+// adds a warning to an admin response
+func addWarning(res *admin.Response, warning string) {
+	if res.Warning != "" {
+		res.Warning += "; "
+	}
+	res.Warning += warning
+	res.Message += "\nWARNING: " + warning
+}
+
+// returns a warning for risky setting changes
+func settingWarning(change admin.SettingChange) string {
+	if change.Setting == "RateLimit" && change.New == 0 {
+		return "Rate limit is disabled"
+	}
+	return ""
+}
+
+// handles client API keys
+func (s *server) handleAdminApiKeys(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+
+	switch {
+	case r.Method == "GET" && hash == "":
+		s.sendApiKeys(w)
+	case r.Method == "POST" && hash == "":
+		label := r.URL.Query().Get("label")
+		if label == "" {
+			sendAdminError(w, r, errMissingValue("Need label: ?label=..."))
 			return
 		}
-		sendAdminOk("Current setting for 'AurFileLocation' is '"+pval+"'", w)
-	case "max-results":
-		pval := strconv.Itoa(s.conf.MaxResults)
-		if isPost {
-			if value != "" {
-				ival, err := convValueToInt(value)
-				if err != nil {
-					sendAdminError(err.Error(), w)
-					return
-				}
-				s.conf.MaxResults = ival
-				sendAdminOk("Changed 'MaxResults' from '"+pval+"' to '"+value+"'", w)
-			} else {
-				sendAdminError("Need new value: ?value=...", w)
+		quota := 0
+		if q := r.URL.Query().Get("quota"); q != "" {
+			var err error
+			quota, err = strconv.Atoi(q)
+			if err != nil {
+				sendAdminError(w, r, errInvalidValue(err.Error()))
+				return
 			}
+		}
+		var endpoints []string
+		if e := r.URL.Query().Get("endpoints"); e != "" {
+			endpoints = strings.Split(e, ",")
+		}
+
+		s.log.admin.Info("Admin initiated creation of API key", "key", label)
+		key, ak, err := s.addApiKey(label, quota, endpoints)
+		if err != nil {
+			sendAdminError(w, r, err)
 			return
 		}
-		sendAdminOk("Current setting for 'MaxResults' is '"+pval+"'", w)
-	case "refresh-interval":
-		pval := strconv.Itoa(s.conf.RefreshInterval)
-		if isPost {
-			if value != "" {
-				ival, err := convValueToInt(value)
-				if err != nil {
-					sendAdminError(err.Error(), w)
-					return
-				}
-				s.conf.RefreshInterval = ival
-				sendAdminOk("Changed 'RefreshInterval' from '"+pval+"' to '"+value+"'", w)
-			} else {
-				sendAdminError("Need new value: ?value=...", w)
-			}
+
+		sendAdminJson(struct {
+			Key string
+			ApiKey
+		}{key, ak}, w)
+	case r.Method == "DELETE" && hash != "":
+		s.log.admin.Info("Admin initiated removal of API key", "hash", hash)
+		ak, found, err := s.removeApiKey(hash)
+		if err != nil {
+			sendAdminError(w, r, err)
 			return
 		}
-		sendAdminOk("Current setting for 'RefreshInterval' is '"+pval+"'", w)
-	case "rate-limit":
-		pval := strconv.Itoa(s.conf.RateLimit)
-		if isPost {
-			if value != "" {
-				ival, err := strconv.Atoi(value)
-				if err != nil {
-					sendAdminError(err.Error(), w)
-					return
-				}
-				s.conf.RateLimit = ival
-				warning := ""
-				if ival == 0 {
-					warning = "\nWARNING: Rate limit is disabled"
-				}
-				sendAdminOk("Changed 'RateLimit' from '"+pval+"' to '"+value+"'"+warning, w)
-			} else {
-				sendAdminError("Need new value: ?value=...", w)
-			}
+		if !found {
+			sendAdminError(w, r, errNotFound("API key not found"))
 			return
 		}
-		sendAdminOk("Current setting for 'RateLimit' is '"+pval+"'", w)
-	case "rate-limit-cleanup-interval":
-		pval := strconv.Itoa(s.conf.RateLimitCleanupInterval)
-		if isPost {
-			if value != "" {
-				ival, err := convValueToInt(value)
-				if err != nil {
-					sendAdminError(err.Error(), w)
-					return
-				}
-				s.conf.RateLimitCleanupInterval = ival
-				sendAdminOk("Changed 'RateLimitCleanupInterval' from '"+pval+"' to '"+value+"'", w)
-			} else {
-				sendAdminError("Need new value: ?value=...", w)
+		sendAdminOk(w, r, "Removed API key '"+ak.Label+"'")
+	default:
+		sendAdminError(w, r, errMethodNotAllowed)
+	}
+}
+
+// send API keys and their current usage in JSON format
+func (s *server) sendApiKeys(w http.ResponseWriter) {
+	s.mutKeys.RLock()
+	list := make([]ApiKeyStatus, 0, len(s.apiKeys))
+	for hash, ak := range s.apiKeys {
+		list = append(list, ApiKeyStatus{
+			ApiKey:      ak,
+			Requests:    s.keyLimits[hash].Requests,
+			WindowStart: s.keyLimits[hash].WindowStart,
+		})
+	}
+	s.mutKeys.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Label < list[j].Label
+	})
+
+	sendAdminJson(list, w)
+}
+
+// handles rate limits
+func (s *server) handleAdminRateLimits(w http.ResponseWriter, r *http.Request) {
+	ip := chi.URLParam(r, "ip")
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == "GET" && ip == "":
+		page, perPage := 1, 100
+		var err error
+		if p := query.Get("page"); p != "" {
+			if page, err = convValueToInt(p); err != nil || page < 1 {
+				sendAdminError(w, r, errInvalidValue("Invalid page number"))
+				return
 			}
-			return
 		}
-		sendAdminOk("Current setting for 'RateLimitCleanupInterval' is '"+pval+"'", w)
-	case "rate-limit-time-window":
-		pval := strconv.Itoa(s.conf.RateLimitTimeWindow)
-		if isPost {
-			if value != "" {
-				ival, err := convValueToInt(value)
-				if err != nil {
-					sendAdminError(err.Error(), w)
-					return
-				}
-				s.conf.RateLimitTimeWindow = ival
-				sendAdminOk("Changed 'RateLimitTimeWindow' from '"+pval+"' to '"+value+"'", w)
-			} else {
-				sendAdminError("Need new value: ?value=...", w)
+		if p := query.Get("per-page"); p != "" {
+			if perPage, err = convValueToInt(p); err != nil || perPage < 1 {
+				sendAdminError(w, r, errInvalidValue("Invalid page size"))
+				return
 			}
+		}
+		sortBy := query.Get("sort")
+		if !inSlice([]string{"", "requests", "ip", "window-start"}, sortBy) {
+			sendAdminError(w, r, errInvalidValue("Invalid sort field"))
 			return
 		}
-		sendAdminOk("Current setting for 'RateLimitTimeWindow' is '"+pval+"'", w)
-	case "cache-cleanup-interval":
-		pval := strconv.Itoa(s.conf.CacheCleanupInterval)
-		if isPost {
-			if value != "" {
-				ival, err := convValueToInt(value)
-				if err != nil {
-					sendAdminError(err.Error(), w)
-					return
-				}
-				s.conf.CacheCleanupInterval = ival
-				sendAdminOk("Changed 'CacheCleanupInterval' from '"+pval+"' to '"+value+"'", w)
-			} else {
-				sendAdminError("Need new value: ?value=...", w)
-			}
+		// sort by number of requests (descending) if not specified otherwise
+		desc := query.Get("order") == "desc" || query.Get("order") == "" && (sortBy == "" || sortBy == "requests")
+
+		sendAdminJson(s.listRateLimits(sortBy, desc, page, perPage), w)
+	case r.Method == "GET":
+		rl, found := s.getRateLimit(ip)
+		if !found {
+			sendAdminError(w, r, errNotFound("Rate limit not found"))
 			return
 		}
-		sendAdminOk("Current setting for 'CacheCleanupInterval' is '"+pval+"'", w)
-	case "cache-expiration-time":
-		pval := strconv.Itoa(s.conf.CacheExpirationTime)
-		if isPost {
-			if value != "" {
-				ival, err := convValueToInt(value)
-				if err != nil {
-					sendAdminError(err.Error(), w)
-					return
-				}
-				s.conf.CacheExpirationTime = ival
-				sendAdminOk("Changed 'CacheExpirationTime' from '"+pval+"' to '"+value+"'", w)
-			} else {
-				sendAdminError("Need new value: ?value=...", w)
-			}
+		sendAdminJson(RateLimitEntry{IP: ip, RateLimit: rl}, w)
+	case r.Method == "DELETE" && ip != "":
+		if !s.removeRateLimit(ip) {
+			sendAdminError(w, r, errNotFound("Rate limit not found"))
+			return
+		}
+		sendAdminOk(w, r, "Removed rate limit for '"+ip+"'")
+	case r.Method == "PUT" && ip != "":
+		if net.ParseIP(ip) == nil {
+			sendAdminError(w, r, errInvalidValue("Invalid IP address"))
+			return
+		}
+		limitVal := query.Get("limit")
+		banVal := query.Get("ban")
+		if limitVal == "" && banVal == "" {
+			sendAdminError(w, r, errMissingValue("Need new value: ?limit=... or ?ban=..."))
 			return
 		}
-		sendAdminOk("Current setting for 'CacheExpirationTime' is '"+pval+"'", w)
-	case "enable-search-cache":
-		pval := strconv.FormatBool(s.conf.EnableSearchCache)
-		if isPost {
-			if value != "" {
-				bval, err := strconv.ParseBool(value)
-				if err != nil {
-					sendAdminError(err.Error(), w)
-					return
-				}
-				s.conf.EnableSearchCache = bval
-				sendAdminOk("Changed 'EnableSearchCache' from '"+pval+"' to '"+strconv.FormatBool(s.conf.EnableSearchCache)+"'", w)
-			} else {
-				sendAdminError("Need new value: ?value=...", w)
+		// values that are not given stay unchanged
+		var limit *int
+		var banned *bool
+		if limitVal != "" {
+			l, err := strconv.Atoi(limitVal)
+			if err != nil {
+				sendAdminError(w, r, errInvalidValue(err.Error()))
+				return
+			}
+			if l < 0 {
+				sendAdminError(w, r, errInvalidValue("Limit can not be negative"))
+				return
+			}
+			limit = &l
+		}
+		if banVal != "" {
+			b, err := strconv.ParseBool(banVal)
+			if err != nil {
+				sendAdminError(w, r, errInvalidValue(err.Error()))
+				return
 			}
+			banned = &b
+		}
+
+		rl := s.setRateLimit(ip, limit, banned)
+		sendAdminJson(RateLimitEntry{IP: ip, RateLimit: rl}, w)
+	default:
+		sendAdminError(w, r, errMethodNotAllowed)
+	}
+}
+
+// handles search-cache entries
+func (s *server) handleAdminCache(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	// keys are url encoded query strings and need to be escaped in the path
+	if r.URL.RawPath != "" {
+		var err error
+		if key, err = url.PathUnescape(key); err != nil {
+			sendAdminError(w, r, errInvalidValue(err.Error()))
 			return
 		}
-		sendAdminOk("Current setting for 'EnableSearchCache' is '"+pval+"'", w)
+	}
+
+	switch {
+	case r.Method == "GET" && key == "":
+		sendAdminJson(s.listCacheEntries(), w)
+	case r.Method == "GET":
+		ce, found := s.getCacheEntry(key)
+		if !found {
+			sendAdminError(w, r, errNotFound("Cache entry not found"))
+			return
+		}
+		sendAdminJson(struct {
+			CacheEntryInfo
+			Result RpcResult
+		}{cacheEntryInfo(key, ce, time.Now()), ce.Result}, w)
+	case r.Method == "DELETE" && key == "":
+		filter := r.URL.Query()
+		if filter.Get("arg") == "" && filter.Get("type") == "" && filter.Get("by") == "" {
+			sendAdminError(w, r, errMissingValue("Need filter: ?arg=...&type=...&by=..."))
+			return
+		}
+		numEntries := s.purgeCacheEntries(filter)
+		sendAdminOk(w, r, "Purged search-cache ("+strconv.Itoa(numEntries)+" entries removed)")
+	case r.Method == "DELETE":
+		if !s.removeCacheEntry(key) {
+			sendAdminError(w, r, errNotFound("Cache entry not found"))
+			return
+		}
+		sendAdminOk(w, r, "Removed cache entry '"+key+"'")
+	default:
+		sendAdminError(w, r, errMethodNotAllowed)
+	}
+}
+
+// handles runtime statistics
+func (s *server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	stats := s.getStats()
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		sendAdminJson(stats, w)
+	case "text":
+		w.Header().Set("Content-Type", consts.ContentTypeText)
+		writeStatsText(stats, w)
 	default:
+		sendAdminError(w, r, errInvalidValue("Invalid format. Use 'json' or 'text'"))
+	}
+}
+
+// converts query param to int. Don't allow 0
+func convValueToInt(value string) (int, error) {
+	ival, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if ival == 0 {
+		return 0, errors.New("Value can not be 0")
+	}
+
+	return ival, nil
+}
+
+// errors returned by validation of our settings are invalid values
+func asAdminError(err error) error {
+	var ae *AdminError
+	if errors.As(err, &ae) {
+		return ae
+	}
+	return errInvalidValue(err.Error())
+}
+
+// checks if a client prefers plain text responses
+func wantsText(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/plain")
+}
+
+// returns a result either as JSON envelope or plain text (Accept: text/plain)
+func sendAdminResult(w http.ResponseWriter, r *http.Request, status int, res admin.Response) {
+	if wantsText(r) {
 		w.Header().Set("Content-Type", consts.ContentTypeText)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Setting not found"))
+		w.WriteHeader(status)
+		w.Write([]byte(res.Message))
+		return
+	}
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
+	w.Header().Set("Content-Type", consts.ContentTypeJson)
+	w.WriteHeader(status)
+	w.Write(b)
 }
 
 // returns error result
-func sendAdminError(message string, w http.ResponseWriter) {
-	w.Header().Set("Content-Type", consts.ContentTypeText)
-	w.WriteHeader(http.StatusInternalServerError)
-	w.Write([]byte(message))
+func sendAdminError(w http.ResponseWriter, r *http.Request, err error) {
+	ae := &AdminError{http.StatusInternalServerError, "internal_error", err.Error()}
+	errors.As(err, &ae)
+	sendAdminResult(w, r, ae.Status, admin.Response{
+		Status:  "error",
+		Code:    ae.Code,
+		Message: ae.Message,
+	})
 }
 
 // returns OK result
-func sendAdminOk(message string, w http.ResponseWriter) {
-	w.Header().Set("Content-Type", consts.ContentTypeText)
-	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte(message))
+func sendAdminOk(w http.ResponseWriter, r *http.Request, message string) {
+	sendAdminResult(w, r, http.StatusOK, admin.Response{Status: "ok", Message: message})
+}
+
+// returns data in JSON format
+func sendAdminJson(v any, w http.ResponseWriter) {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", consts.ContentTypeJson)
+	w.Write(b)
 }
@@ -0,0 +1,184 @@
+package rpc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+var (
+	ErrInvalidApiKey      = errors.New("Invalid API key.")
+	ErrEndpointNotAllowed = errors.New("Endpoint not allowed for this API key.")
+	ErrQuotaReached       = errors.New("Rate limit reached")
+)
+
+// get the API key a client passed either via header or query/form parameter
+func getClientApiKey(r *http.Request, params url.Values) string {
+	key := params.Get("apikey")
+	// we don't want the key to end up in our cache keys
+	params.Del("apikey")
+
+	if hkey := r.Header.Get("X-API-Key"); hkey != "" {
+		return hkey
+	}
+	return key
+}
+
+// returns the hex encoded SHA-256 hash of an API key
+func hashApiKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// generates a new random API key
+func generateApiKey() (string, error) {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// load API keys from our key file. A missing file results in an empty key list
+func loadApiKeys(path string) (map[string]ApiKey, error) {
+	keys := map[string]ApiKey{}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return keys, nil
+		}
+		return nil, err
+	}
+
+	var list []ApiKey
+	err = json.Unmarshal(b, &list)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range list {
+		keys[k.Hash] = k
+	}
+	return keys, nil
+}
+
+// write API keys to our key file (temp file + rename)
+func (s *server) saveApiKeys() error {
+	s.mutKeys.RLock()
+	list := make([]ApiKey, 0, len(s.apiKeys))
+	for _, k := range s.apiKeys {
+		list = append(list, k)
+	}
+	s.mutKeys.RUnlock()
+
+	b, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.conf().ApiKeyFile), ".apikeys-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.conf().ApiKeyFile)
+}
+
+// check if an API key is valid, allowed for the endpoint and has not exceeded its quota.
+func (s *server) checkApiKey(key, path string) (string, error) {
+	hash := hashApiKey(key)
+
+	s.mutKeys.Lock()
+	defer s.mutKeys.Unlock()
+
+	ak, ok := s.apiKeys[hash]
+	if !ok {
+		return "", ErrInvalidApiKey
+	}
+
+	if len(ak.Endpoints) > 0 && !endpointAllowed(ak.Endpoints, path) {
+		return ak.Label, ErrEndpointNotAllowed
+	}
+
+	// a new time window starts once the current one has passed; we don't wait for the cleanup job
+	window := time.Duration(s.conf().RateLimitTimeWindow) * time.Second
+	la, ok := s.keyLimits[hash]
+	if !ok || time.Since(la.WindowStart) > window {
+		la = RateLimit{WindowStart: time.Now()}
+	}
+	la.Requests++
+	s.keyLimits[hash] = la
+
+	// Quota of 0 -> unlimited
+	if ak.Quota != 0 && la.Requests > ak.Quota {
+		return ak.Label, ErrQuotaReached
+	}
+
+	return ak.Label, nil
+}
+
+// checks if path is one of the endpoints or below one of them ("/api" matches "/api/v6/..." but not "/apix")
+func endpointAllowed(endpoints []string, path string) bool {
+	for _, e := range endpoints {
+		e = strings.TrimSuffix(e, "/")
+		if path == e || strings.HasPrefix(path, e+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// adds a new API key and returns the (unhashed) key
+func (s *server) addApiKey(label string, quota int, endpoints []string) (string, ApiKey, error) {
+	key, err := generateApiKey()
+	if err != nil {
+		return "", ApiKey{}, err
+	}
+
+	ak := ApiKey{
+		Hash:      hashApiKey(key),
+		Label:     label,
+		Quota:     quota,
+		Endpoints: endpoints,
+		Created:   time.Now().UTC(),
+	}
+
+	s.mutKeys.Lock()
+	s.apiKeys[ak.Hash] = ak
+	s.mutKeys.Unlock()
+
+	return key, ak, s.saveApiKeys()
+}
+
+// removes an API key by its hash
+func (s *server) removeApiKey(hash string) (ApiKey, bool, error) {
+	s.mutKeys.Lock()
+	ak, ok := s.apiKeys[hash]
+	if ok {
+		delete(s.apiKeys, hash)
+		delete(s.keyLimits, hash)
+	}
+	s.mutKeys.Unlock()
+
+	if !ok {
+		return ak, false, nil
+	}
+	return ak, true, s.saveApiKeys()
+}
@@ -0,0 +1,145 @@
+package rpc
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// outcome of a search-cache lookup
+type cacheOutcome int
+
+const (
+	cacheNone  cacheOutcome = iota // no lookup; search-cache disabled or not a search
+	cacheHit                       // result was taken from the cache
+	cacheMiss                      // not found; the result can not be cached
+	cacheStore                     // not found; the result should be added to the cache
+)
+
+// returns the outcome for our access log
+func (o cacheOutcome) String() string {
+	switch o {
+	case cacheHit:
+		return "hit"
+	case cacheMiss, cacheStore:
+		return "miss"
+	}
+	return ""
+}
+
+// returns the number of search-cache entries
+func (s *server) cacheEntries() int {
+	s.mutCache.RLock()
+	defer s.mutCache.RUnlock()
+	return len(s.searchCache)
+}
+
+// returns search-cache entries (most hits first) and aggregated statistics
+func (s *server) listCacheEntries() CacheList {
+	s.mutCache.RLock()
+	defer s.mutCache.RUnlock()
+
+	now := time.Now()
+	list := CacheList{
+		Stats:   s.getCacheStats(),
+		Entries: make([]CacheEntryInfo, 0, len(s.searchCache)),
+	}
+	for k, ce := range s.searchCache {
+		list.Entries = append(list.Entries, cacheEntryInfo(k, ce, now))
+	}
+
+	sort.Slice(list.Entries, func(i, j int) bool {
+		if list.Entries[i].Hits == list.Entries[j].Hits {
+			return list.Entries[i].Key < list.Entries[j].Key
+		}
+		return list.Entries[i].Hits > list.Entries[j].Hits
+	})
+
+	return list
+}
+
+// returns aggregated search-cache statistics. mutCache must be held by the caller
+func (s *server) getCacheStats() CacheStats {
+	stats := CacheStats{
+		Entries: len(s.searchCache),
+		Hits:    int(s.cacheHits.Load()),
+		Misses:  int(s.cacheMisses.Load()),
+	}
+	for _, ce := range s.searchCache {
+		stats.Bytes += ce.Size
+	}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
+
+// returns a single search-cache entry
+func (s *server) getCacheEntry(key string) (*CacheEntry, bool) {
+	s.mutCache.RLock()
+	defer s.mutCache.RUnlock()
+	ce, ok := s.searchCache[key]
+	return ce, ok
+}
+
+// removes a single search-cache entry
+func (s *server) removeCacheEntry(key string) bool {
+	s.mutCache.Lock()
+	defer s.mutCache.Unlock()
+	_, ok := s.searchCache[key]
+	if ok {
+		delete(s.searchCache, key)
+		s.metrics.CacheEvictions.Inc()
+	}
+	s.log.admin.Info("Admin removed cache entry", "key", key)
+	return ok
+}
+
+// removes all search-cache entries matching the filter.
+// Entries match if their arg contains the "arg" filter value and type/by are equal
+func (s *server) purgeCacheEntries(filter url.Values) int {
+	s.mutCache.Lock()
+	defer s.mutCache.Unlock()
+
+	numEntries := 0
+	for k := range s.searchCache {
+		params, err := url.ParseQuery(k)
+		if err != nil {
+			continue
+		}
+		if !cacheKeyMatches(params, filter) {
+			continue
+		}
+		delete(s.searchCache, k)
+		numEntries++
+	}
+	s.metrics.CacheEvictions.Add(float64(numEntries))
+	s.log.admin.Info("Admin purged search-cache entries", "filter", filter.Encode(), "removed", numEntries)
+	return numEntries
+}
+
+// checks if the parameters of a cache entry match a filter
+func cacheKeyMatches(params, filter url.Values) bool {
+	if arg := filter.Get("arg"); arg != "" && !strings.Contains(getArg(params), strings.ToLower(arg)) {
+		return false
+	}
+	if t := filter.Get("type"); t != "" && params.Get("type") != t {
+		return false
+	}
+	if by := filter.Get("by"); by != "" && getBy(params) != by {
+		return false
+	}
+	return true
+}
+
+// converts a CacheEntry to CacheEntryInfo
+func cacheEntryInfo(key string, ce *CacheEntry, now time.Time) CacheEntryInfo {
+	return CacheEntryInfo{
+		Key:       key,
+		Age:       int(now.Sub(ce.TimeAdded).Seconds()),
+		Size:      ce.Size,
+		Hits:      int(ce.Hits.Load()),
+		TimeAdded: ce.TimeAdded,
+	}
+}
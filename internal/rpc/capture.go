@@ -0,0 +1,105 @@
+package rpc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	mrand "math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+/*
+	Request capture: sampled /rpc and /api requests are appended to a JSONL file (CaptureFile),
+	which can be replayed with "goaurrpc bench -log".
+	Client addresses are anonymized with a salted hash; the salt changes on each start.
+	The file is rotated when it exceeds CaptureMaxSize (or LogRotateInterval has passed); see logfile.go.
+*/
+
+// recorder writes captured requests to a file
+type recorder struct {
+	logFile
+	salt []byte
+}
+
+func newRecorder() *recorder {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	return &recorder{salt: salt}
+}
+
+// anonymizes a client address
+func (rec *recorder) anonymize(ip string) string {
+	h := sha256.Sum256(append(append([]byte{}, rec.salt...), ip...))
+	return hex.EncodeToString(h[:8])
+}
+
+// appends a record to our capture file
+func (rec *recorder) record(path string, rot rotation, record CaptureRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return rec.write(path, rot, append(b, '\n'))
+}
+
+// captureWriter records the status code and size of a response
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (cw *captureWriter) WriteHeader(status int) {
+	cw.status = status
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(b)
+	cw.size += n
+	return n, err
+}
+
+// captureMiddleware records sampled requests if request capture is enabled
+func (s *server) captureMiddleware(hf http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conf := s.conf()
+		if !conf.CaptureRequests || conf.CaptureSampleRate > 1 && mrand.Intn(conf.CaptureSampleRate) != 0 {
+			hf(w, r)
+			return
+		}
+
+		start := time.Now()
+		cw := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+		hf(cw, r)
+
+		// POST forms have been parsed by our handler
+		params := url.Values{}
+		for k, v := range r.URL.Query() {
+			params[k] = v
+		}
+		for k, v := range r.PostForm {
+			params[k] = v
+		}
+		// client API keys must not end up in our capture file
+		params.Del("apikey")
+
+		record := CaptureRecord{
+			Time:    start.UTC(),
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Params:  params,
+			Client:  s.recorder.anonymize(getRealIP(r, conf.TrustedReverseProxies)),
+			Status:  cw.status,
+			Size:    cw.size,
+			Latency: float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if err := s.recorder.record(conf.CaptureFile, logRotation(conf, conf.CaptureMaxSize), record); err != nil {
+			s.log.main.Error("Error capturing request", "error", err)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package rpc
 
 import (
+	"net/url"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/guregu/null.v4"
@@ -95,9 +97,153 @@ type SearchRecord struct {
 type RateLimit struct {
 	Requests    int
 	WindowStart time.Time
+	Limit       int  `json:",omitempty"` // custom limit; 0 -> RateLimit setting applies
+	Banned      bool `json:",omitempty"`
 }
 
+// RateLimitEntry is a data structure for returning rate limit records
+type RateLimitEntry struct {
+	IP string
+	RateLimit
+}
+
+// RateLimitList is a data structure for returning a page of rate limit records
+type RateLimitList struct {
+	Total   int
+	Page    int
+	PerPage int
+	Entries []RateLimitEntry
+}
+
+// ApiKey holds data for a client API key. Only the SHA-256 hash of the key is stored
+type ApiKey struct {
+	Hash      string
+	Label     string
+	Quota     int
+	Endpoints []string
+	Created   time.Time
+}
+
+// ApiKeyStatus is a data structure for listing API keys and their usage
+type ApiKeyStatus struct {
+	ApiKey
+	Requests    int
+	WindowStart time.Time
+}
+
+// CacheEntry holds a search result in our search-cache
 type CacheEntry struct {
 	Result    RpcResult
 	TimeAdded time.Time
+	Size      int          // size of the JSON response in bytes
+	Hits      atomic.Int64 // updated while holding a read lock
+}
+
+// CacheEntryInfo is a data structure for returning search-cache entries
+type CacheEntryInfo struct {
+	Key       string
+	Age       int // in seconds
+	Size      int
+	Hits      int
+	TimeAdded time.Time
+}
+
+// CacheStats is a data structure for returning aggregated search-cache statistics
+type CacheStats struct {
+	Entries  int
+	Bytes    int
+	Hits     int
+	Misses   int
+	HitRatio float64
+}
+
+// CacheList is a data structure for returning search-cache entries and statistics
+type CacheList struct {
+	Stats   CacheStats
+	Entries []CacheEntryInfo
+}
+
+// ReloadResult holds data about a package data reload
+type ReloadResult struct {
+	Time     time.Time
+	Duration int64 // in milliseconds
+	Result   string
+	Error    string `json:",omitempty"`
+}
+
+// HealthStatus is returned by /healthz and /readyz
+type HealthStatus struct {
+	Status      string        // ok or unavailable
+	Ready       bool          // package data has been loaded and is not stale
+	Reason      string        `json:",omitempty"` // why we are not ready
+	LastRefresh time.Time     `json:",omitempty"` // modification time of the loaded package data
+	DataAge     int64         // in seconds
+	Stale       bool          // package data is older than DataStaleThreshold
+	LastReload  *ReloadResult `json:",omitempty"` // most recent reload attempt
+}
+
+// MemoryStats is a data structure for returning Go runtime memory statistics
+type MemoryStats struct {
+	Alloc       uint64
+	TotalAlloc  uint64
+	Sys         uint64
+	HeapAlloc   uint64
+	HeapInuse   uint64
+	HeapObjects uint64
+	NumGC       uint32
+}
+
+// UpstreamInfo holds data about the upstream package data file
+type UpstreamInfo struct {
+	Location     string
+	LastModified time.Time
+	ETag         string
+}
+
+// ServerStats is a data structure for returning runtime statistics
+type ServerStats struct {
+	Version      string
+	Started      time.Time
+	Uptime       int64 // in seconds
+	LastRefresh  time.Time
+	Packages     int
+	PackageBases int
+	IndexSizes   map[string]int
+	Upstream     UpstreamInfo
+	Memory       MemoryStats
+	Goroutines   int
+	RateLimits   int
+	CacheEntries int
+	Reloads      []ReloadResult
+}
+
+// CaptureRecord is a captured request (a line in our capture file).
+// Method, Path and Params are used by "goaurrpc bench" to replay it
+type CaptureRecord struct {
+	Time    time.Time
+	Method  string
+	Path    string
+	Params  url.Values
+	Client  string // anonymized client address
+	Status  int
+	Size    int     // response size in bytes
+	Latency float64 // in milliseconds
+}
+
+// AccessRecord is an access log entry (AccessLogFormat json)
+type AccessRecord struct {
+	Time      time.Time
+	Client    string
+	Method    string
+	URI       string
+	Proto     string
+	Status    int
+	Size      int     // response size in bytes
+	Latency   float64 // in milliseconds
+	Referer   string
+	UserAgent string
+	Type      string // request type like "info" or "search"
+	Cache     string // search-cache outcome: hit, miss or empty if there was no lookup
+	RateLimit string // ok, limited, key (valid API key), key-quota, key-forbidden, key-invalid or empty if not checked
+	TraceID   string `json:",omitempty"` // trace ID of the request (own or from traceparent header)
 }
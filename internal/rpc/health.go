@@ -0,0 +1,95 @@
+package rpc
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/moson-mo/goaurrpc/internal/consts"
+
+	"github.com/goccy/go-json"
+)
+
+/*
+	Health checks:
+	/healthz (liveness) returns 200 as long as we are able to respond.
+	/readyz (readiness) returns 503 until package data has been loaded
+	and while it is older than DataStaleThreshold (if set). It also fails as soon as we are shutting down.
+	Both return a HealthStatus with details about our package data and the last reload attempt.
+*/
+
+// collects our health status
+func (s *server) health() HealthStatus {
+	h := HealthStatus{Status: "ok", Ready: true}
+
+	s.mut.RLock()
+	loaded := s.memDB != nil
+	lastRefresh := s.lastRefresh
+	s.mut.RUnlock()
+
+	if loaded {
+		h.LastRefresh = lastRefresh.UTC()
+		h.DataAge = int64(time.Since(lastRefresh).Seconds())
+		h.Stale = s.isStale(lastRefresh)
+	}
+
+	s.mutReloads.Lock()
+	if len(s.reloads) > 0 {
+		rr := s.reloads[len(s.reloads)-1]
+		h.LastReload = &rr
+	}
+	s.mutReloads.Unlock()
+
+	switch {
+	case s.draining.Load():
+		h.Ready, h.Reason = false, "shutting down"
+	case !loaded:
+		h.Ready, h.Reason = false, "package data has not been loaded yet"
+	case h.Stale:
+		h.Ready, h.Reason = false, "package data is stale"
+	}
+	return h
+}
+
+// checks if package data with the given modification time is older than DataStaleThreshold
+func (s *server) isStale(lastRefresh time.Time) bool {
+	threshold := time.Duration(s.conf().DataStaleThreshold) * time.Second
+	return threshold > 0 && time.Since(lastRefresh) > threshold
+}
+
+// returns 1 if our package data is stale, 0 otherwise (for our data_stale metric)
+func (s *server) dataStale() int {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	if s.memDB == nil || s.isStale(s.lastRefresh) {
+		return 1
+	}
+	return 0
+}
+
+// liveness probe
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	sendHealth(w, http.StatusOK, s.health())
+}
+
+// readiness probe
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	h := s.health()
+	if !h.Ready {
+		h.Status = "unavailable"
+		sendHealth(w, http.StatusServiceUnavailable, h)
+		return
+	}
+	sendHealth(w, http.StatusOK, h)
+}
+
+func sendHealth(w http.ResponseWriter, status int, h HealthStatus) {
+	b, err := json.MarshalIndent(h, "", "\t")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", consts.ContentTypeJson)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(b)
+}
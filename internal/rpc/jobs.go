@@ -1,88 +1,247 @@
 package rpc
 
 import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/moson-mo/goaurrpc/internal/config"
 	db "github.com/moson-mo/goaurrpc/internal/memdb"
-	"github.com/moson-mo/goaurrpc/internal/metrics"
+	"github.com/moson-mo/goaurrpc/internal/systemd"
+	"github.com/moson-mo/goaurrpc/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// start go-routines for periodic tasks
-func (s *server) startJobs(shutdown chan struct{}, wg *sync.WaitGroup) {
-	wg.Add(3)
+// start go-routines for periodic tasks. They are stopped when ctx is cancelled
+func (s *server) startJobs(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(5)
 
 	// starts a go routine that continuously refreshes the package data
 	go func() {
 		defer wg.Done()
-		for {
-			select {
-			case <-shutdown:
-				s.LogVerbose("Stopping routine: Data refresh")
-				return
-			case <-time.After(time.Duration(s.conf.RefreshInterval) * time.Second):
-				s.Log("Reloading package data...")
-				start := time.Now()
-				err := s.reloadData()
-				if err != nil {
-					if err.Error() == "not modified" {
-						s.Log("Reload skipped. File has not been modified.")
-					} else {
-						s.Log("Error reloading data: ", err)
-					}
+		s.runPeriodically(ctx, "Data refresh", func(c *config.Settings) int {
+			return c.RefreshInterval
+		}, func() {
+			s.log.refresh.Info("Reloading package data")
+			start := time.Now()
+			err := s.reloadData(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					s.log.refresh.Info("Reload cancelled", "error", err)
+				} else if err.Error() == "not modified" {
+					s.log.refresh.Info("Reload skipped. File has not been modified")
 				} else {
-					elapsed := time.Since(start)
-					s.Log("Successfully reloaded package data in", elapsed.Milliseconds(), "ms")
+					s.log.refresh.Error("Error reloading data", "error", err)
 				}
+			} else {
+				s.log.refresh.Info("Successfully reloaded package data", "duration_ms", time.Since(start).Milliseconds())
 			}
-		}
+		})
 	}()
 
 	// starts a go routine that removes rate limits if older than 24h
 	go func() {
 		defer wg.Done()
-		for {
-			select {
-			case <-shutdown:
-				s.LogVerbose("Stopping routine: Rate-Limit cleanup")
-				return
-			case <-time.After(time.Duration(s.conf.RateLimitCleanupInterval) * time.Second):
-				s.cleanupRateLimits()
-			}
-		}
+		s.runPeriodically(ctx, "Rate-Limit cleanup", func(c *config.Settings) int {
+			return c.RateLimitCleanupInterval
+		}, s.cleanupRateLimits)
 	}()
 
 	// start go routine that cleans up the search cache
 	go func() {
 		defer wg.Done()
+		s.runPeriodically(ctx, "Search-Cache cleanup", func(c *config.Settings) int {
+			return c.CacheCleanupInterval
+		}, s.cleanupSearchCache)
+	}()
+
+	// start go routine that pings the systemd watchdog (WatchdogSec)
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runWatchdog(ctx, interval)
+		}()
+	}
+
+	// start go routine that reloads our config file on SIGHUP or when it has been modified
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer wg.Done()
+		defer signal.Stop(hup)
+		s.watchConfig(ctx, hup, configWatchInterval)
+	}()
+
+	// start go routine that reloads our certificate on SIGHUP or when it has been modified
+	if s.certs != nil {
+		certHup := make(chan os.Signal, 1)
+		signal.Notify(certHup, syscall.SIGHUP)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer signal.Stop(certHup)
+			s.watchCertificates(ctx, certHup, configWatchInterval)
+		}()
+	}
+
+	// start go routine that reopens our log files on SIGUSR1
+	usr1 := make(chan os.Signal, 1)
+	notifyReopen(usr1)
+	go func() {
+		defer wg.Done()
+		defer signal.Stop(usr1)
 		for {
 			select {
-			case <-shutdown:
-				s.LogVerbose("Stopping routine: Search-Cache cleanup")
+			case <-ctx.Done():
+				s.log.main.Debug("Stopping routine", "routine", "Log reopen")
 				return
-			case <-time.After(time.Duration(s.conf.CacheCleanupInterval) * time.Second):
-				s.cleanupSearchCache()
+			case <-usr1:
+				s.reopenLogs()
+				s.log.main.Info("Received SIGUSR1. Reopened log files")
 			}
 		}
 	}()
 }
 
-// load data from file/url
-func (s *server) reloadData() error {
+// tells systemd we are still alive until ctx is done
+func (s *server) runWatchdog(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.log.main.Debug("Stopping routine", "routine", "Watchdog")
+			return
+		case <-ticker.C:
+			s.notify("WATCHDOG=1")
+		}
+	}
+}
+
+// closes our log, access log and capture files. They are reopened with the next write
+func (s *server) reopenLogs() {
+	s.logFile.reopen()
+	s.accessLog.reopen()
+	s.recorder.reopen()
+}
+
+// interval in which we check our config file for modifications
+const configWatchInterval = 5 * time.Second
+
+// reloads the config file when we receive a signal
+// or, with WatchConfigFile enabled, when it has been modified
+func (s *server) watchConfig(ctx context.Context, hup <-chan os.Signal, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	modTime := s.configModTime()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.log.main.Debug("Stopping routine", "routine", "Config watcher")
+			return
+		case <-hup:
+			s.log.refresh.Info("Received SIGHUP. Reloading config file")
+			if _, _, err := s.reloadConfig(); err != nil {
+				s.log.refresh.Error("Error reloading config file", "error", err)
+			}
+		case <-ticker.C:
+			t := s.configModTime()
+			if t.Equal(modTime) {
+				continue
+			}
+			modTime = t
+			if !s.conf().WatchConfigFile {
+				continue
+			}
+			s.log.refresh.Info("Config file has been modified. Reloading")
+			if _, _, err := s.reloadConfig(); err != nil {
+				s.log.refresh.Error("Error reloading config file", "error", err)
+			}
+		}
+	}
+}
+
+// returns the modification time of our config file
+func (s *server) configModTime() time.Time {
+	if s.configFile == "" {
+		return time.Time{}
+	}
+	fi, err := os.Stat(s.configFile)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// runs a job periodically until ctx is cancelled.
+// The interval (in seconds) is re-evaluated as soon as our settings change
+func (s *server) runPeriodically(ctx context.Context, name string, interval func(*config.Settings) int, job func()) {
+	last := time.Now()
+	for {
+		changed := s.settingsChanged()
+		next := last.Add(time.Duration(interval(s.conf())) * time.Second)
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.log.main.Debug("Stopping routine", "routine", name)
+			return
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+			job()
+			last = time.Now()
+		}
+	}
+}
+
+// number of reload results we keep for our statistics
+const maxReloadHistory = 10
+
+// ErrReloadInProgress is returned when a reload is requested while another one is still running
+var ErrReloadInProgress = errors.New("Data reload already in progress")
+
+// load data from file/url. Downloads are aborted when ctx is cancelled
+func (s *server) reloadData(ctx context.Context) (err error) {
+	if !atomic.CompareAndSwapInt32(&s.reloading, 0, 1) {
+		return ErrReloadInProgress
+	}
+	defer atomic.StoreInt32(&s.reloading, 0)
+
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "reloadData")
+	defer func() {
+		if err != nil && err.Error() != "not modified" {
+			tracing.Error(span, err)
+		}
+		span.End()
+		s.addReloadResult(start, err)
+	}()
+
 	/*
 		use local file for extensive testing -> ptr, err := db.LoadDbFromFile("packages.json")
 		we don't want to stress the aur server
 	*/
 	var ptr *db.MemoryDB
 	var lastRefresh time.Time
-	var err error
-	if s.conf.LoadFromFile {
-		ptr, lastRefresh, err = db.LoadDbFromFile(s.conf.AurFileLocation, s.lastRefresh)
+	conf := s.conf()
+	span.SetAttributes(attribute.String("aur_file_location", conf.AurFileLocation))
+	if conf.LoadFromFile {
+		ptr, lastRefresh, err = db.LoadDbFromFile(ctx, conf.AurFileLocation, s.lastRefresh)
 		if err != nil {
 			return err
 		}
 	} else {
-		ptr, lastRefresh, err = db.LoadDbFromUrl(s.conf.AurFileLocation, s.lastRefresh)
+		ptr, lastRefresh, err = db.LoadDbFromUrl(ctx, conf.AurFileLocation, s.lastRefresh)
 		if err != nil {
 			return err
 		}
@@ -91,19 +250,65 @@ func (s *server) reloadData() error {
 	defer s.mut.Unlock()
 	s.memDB = ptr
 	s.lastRefresh = lastRefresh
-	metrics.LastRefresh.Set(float64(lastRefresh.UTC().Unix()))
+	s.metrics.LastRefresh.Set(float64(lastRefresh.UTC().Unix()))
+	s.metrics.DataPackages.Set(float64(len(ptr.PackageNames)))
+	s.metrics.UpstreamBytes.Add(float64(ptr.DownloadSize))
 	return nil
 }
 
+// records the result of a data reload
+func (s *server) addReloadResult(start time.Time, err error) {
+	rr := ReloadResult{
+		Time:     start.UTC(),
+		Duration: time.Since(start).Milliseconds(),
+		Result:   "ok",
+	}
+	if err != nil {
+		rr.Result = "error"
+		rr.Error = err.Error()
+		if rr.Error == "not modified" {
+			rr.Result = "not modified"
+			rr.Error = ""
+		}
+	}
+
+	s.metrics.Reloads.WithLabelValues(rr.Result).Inc()
+	s.metrics.ReloadDuration.Observe(time.Since(start).Seconds())
+
+	s.mutReloads.Lock()
+	defer s.mutReloads.Unlock()
+	s.reloads = append(s.reloads, rr)
+	if len(s.reloads) > maxReloadHistory {
+		s.reloads = s.reloads[len(s.reloads)-maxReloadHistory:]
+	}
+}
+
 // clean up rate limit cache
 func (s *server) cleanupRateLimits() {
 	s.mutLimit.Lock()
 	defer s.mutLimit.Unlock()
 	t := time.Now()
+	window := time.Duration(s.conf().RateLimitTimeWindow) * time.Second
 	for ip, rl := range s.rateLimits {
-		if t.Sub(rl.WindowStart) > time.Duration(s.conf.RateLimitTimeWindow)*time.Second {
+		if t.Sub(rl.WindowStart) > window {
+			// custom limits and bans are kept, we only start a new time window
+			if rl.Limit != 0 || rl.Banned {
+				rl.Requests = 0
+				rl.WindowStart = t
+				s.rateLimits[ip] = rl
+				continue
+			}
 			delete(s.rateLimits, ip)
-			s.LogVeryVerbose("Removed rate limit for", ip)
+			logTrace(s.log.ratelimit, "Removed rate limit", "client", ip)
+		}
+	}
+
+	// API key quotas share the rate limit time window
+	s.mutKeys.Lock()
+	defer s.mutKeys.Unlock()
+	for hash, rl := range s.keyLimits {
+		if t.Sub(rl.WindowStart) > window {
+			delete(s.keyLimits, hash)
 		}
 	}
 }
@@ -113,10 +318,12 @@ func (s *server) cleanupSearchCache() {
 	s.mutCache.Lock()
 	defer s.mutCache.Unlock()
 	t := time.Now()
+	expiration := time.Duration(s.conf().CacheExpirationTime) * time.Second
 	for k, ce := range s.searchCache {
-		if t.Sub(ce.TimeAdded) > time.Duration(s.conf.CacheExpirationTime)*time.Second {
+		if t.Sub(ce.TimeAdded) > expiration {
 			delete(s.searchCache, k)
-			s.LogVeryVerbose("Removed cache entry for", k)
+			s.metrics.CacheEvictions.Inc()
+			logTrace(s.log.cache, "Removed cache entry", "key", k)
 		}
 	}
 }
@@ -126,8 +333,9 @@ func (s *server) wipeSearchCache() int {
 	s.mutCache.Lock()
 	defer s.mutCache.Unlock()
 	numEntries := len(s.searchCache)
-	s.searchCache = map[string]CacheEntry{}
-	s.LogVerbose("Admin wiped search-cache. Number of entries removed:", numEntries)
+	s.searchCache = map[string]*CacheEntry{}
+	s.metrics.CacheEvictions.Add(float64(numEntries))
+	s.log.admin.Info("Admin wiped search-cache", "removed", numEntries)
 	return numEntries
 }
 
@@ -137,6 +345,6 @@ func (s *server) wipeRateLimits() int {
 	defer s.mutLimit.Unlock()
 	numEntries := len(s.rateLimits)
 	s.rateLimits = map[string]RateLimit{}
-	s.LogVerbose("Admin wiped search-cache. Number of entries removed:", numEntries)
+	s.log.admin.Info("Admin wiped rate-limits", "removed", numEntries)
 	return numEntries
 }
@@ -0,0 +1,114 @@
+package rpc
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/moson-mo/goaurrpc/internal/config"
+	"github.com/moson-mo/goaurrpc/internal/systemd"
+)
+
+// name of the sockets passed by systemd that are used for our admin listener (FileDescriptorName=admin)
+const adminSocketName = "admin"
+
+// returns the sockets we serve requests on and the ones for our admin listener (if any):
+// the ones passed by systemd (socket activation), our (Admin)ListenAddresses or Port
+func (s *server) listeners(conf *config.Settings) ([]net.Listener, []net.Listener, error) {
+	named, err := systemd.ListenersWithNames()
+	if err != nil {
+		return nil, nil, err
+	}
+	var public, admin []net.Listener
+	for name, list := range named {
+		s.log.main.Info("Using sockets passed by systemd", "name", name, "count", len(list))
+		if name == adminSocketName {
+			admin = append(admin, list...)
+		} else {
+			public = append(public, list...)
+		}
+	}
+
+	if len(public) == 0 {
+		addresses := conf.ListenAddresses
+		if len(addresses) == 0 {
+			addresses = []string{":" + strconv.Itoa(conf.Port)}
+		}
+		if public, err = listenAll(addresses, conf); err != nil {
+			closeAll(admin)
+			return nil, nil, err
+		}
+	}
+	if len(admin) == 0 {
+		if admin, err = listenAll(conf.AdminListenAddresses, conf); err != nil {
+			closeAll(public)
+			return nil, nil, err
+		}
+	}
+	return public, admin, nil
+}
+
+// listens on each of our addresses
+func listenAll(addresses []string, conf *config.Settings) ([]net.Listener, error) {
+	var list []net.Listener
+	for _, addr := range addresses {
+		var l net.Listener
+		var err error
+		if path, ok := strings.CutPrefix(addr, config.UnixPrefix); ok {
+			l, err = listenUnix(path, conf)
+		} else {
+			l, err = net.Listen("tcp", addr)
+		}
+		if err != nil {
+			closeAll(list)
+			return nil, err
+		}
+		list = append(list, l)
+	}
+	return list, nil
+}
+
+func closeAll(list []net.Listener) {
+	for _, l := range list {
+		l.Close()
+	}
+}
+
+// creates a unix socket with SocketMode and SocketOwner.
+// A stale socket file, left behind by a previous run, is removed first
+func listenUnix(path string, conf *config.Settings) (net.Listener, error) {
+	mode, err := config.ParseSocketMode(conf.SocketMode)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, errors.New(path + " exists and is not a socket")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = setSocketOwner(path, mode, conf.SocketOwner); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// sends a state to systemd if we are running as a notify service
+func (s *server) notify(state string) {
+	if ok, err := systemd.Notify(state); err != nil {
+		s.log.main.Warn("Could not notify systemd", "state", state, "error", err)
+	} else if ok {
+		s.log.main.Debug("Notified systemd", "state", state)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package rpc
+
+import (
+	"errors"
+	"os"
+)
+
+// file modes and owners of unix sockets can't be set on this platform. SocketMode is ignored
+func setSocketOwner(path string, mode os.FileMode, owner string) error {
+	if owner != "" {
+		return errors.New("SocketOwner is not supported on this platform")
+	}
+	return nil
+}
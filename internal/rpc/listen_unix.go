@@ -0,0 +1,24 @@
+//go:build unix
+
+package rpc
+
+import (
+	"os"
+
+	"github.com/moson-mo/goaurrpc/internal/config"
+)
+
+// sets the file mode and owner (user:group) of a unix socket. An empty owner keeps the current one
+func setSocketOwner(path string, mode os.FileMode, owner string) error {
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+	if owner == "" {
+		return nil
+	}
+	uid, gid, err := config.LookupOwner(owner)
+	if err != nil {
+		return err
+	}
+	return os.Chown(path, uid, gid)
+}
@@ -1,24 +1,81 @@
 package rpc
 
 import (
-	"log"
+	"context"
+	"io"
+	"log/slog"
 )
 
-// Log writes a log message either to a file or stdout
-func (s *server) Log(a ...any) {
-	log.Println(a...)
+/*
+	Log messages are written with log/slog to LogFile (or stdout), either in logfmt (LogFormat "text") or as JSON.
+	Each subsystem has its own logger which adds a "subsystem" attribute.
+	The level is taken from the current settings snapshot, so changes via the admin API or a config reload apply immediately.
+	The -v and -vv flags are shortcuts for -log-level debug and -log-level trace.
+*/
+
+// LevelTrace is more verbose than debug. It's used for messages about single clients and cache entries
+const LevelTrace = slog.LevelDebug - 4
+
+// loggers for our subsystems
+type loggers struct {
+	main      *slog.Logger
+	refresh   *slog.Logger // package data and config reloads
+	ratelimit *slog.Logger // rate limits and API keys
+	cache     *slog.Logger // search-cache
+	admin     *slog.Logger // admin API
+}
+
+func newLoggers(w io.Writer, format string, level slog.Leveler) loggers {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: replaceLevel}
+	var h slog.Handler = slog.NewTextHandler(w, opts)
+	if format == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	l := slog.New(h)
+	return loggers{
+		main:      l,
+		refresh:   l.With("subsystem", "refresh"),
+		ratelimit: l.With("subsystem", "ratelimit"),
+		cache:     l.With("subsystem", "cache"),
+		admin:     l.With("subsystem", "admin"),
+	}
 }
 
-// LogVerbose writes log messages if the verbose flag is set
-func (s *server) LogVerbose(a ...any) {
-	if s.verbose {
-		s.Log(a...)
+// logs a message with level trace
+func logTrace(l *slog.Logger, msg string, args ...any) {
+	l.Log(context.Background(), LevelTrace, msg, args...)
+}
+
+// parses a level name from our settings. Unknown and empty names result in info
+func parseLevel(name string) slog.Level {
+	switch name {
+	case "trace":
+		return LevelTrace
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
 	}
+	return slog.LevelInfo
+}
+
+// confLevel reads the log level from the current settings
+type confLevel struct {
+	s *server
+}
+
+func (l confLevel) Level() slog.Level {
+	return parseLevel(l.s.conf().LogLevel)
 }
 
-// LogVeryVerbose writes log messages if the very verbose flag is set
-func (s *server) LogVeryVerbose(a ...any) {
-	if s.veryVerbose {
-		s.Log(a...)
+// prints "TRACE" instead of "DEBUG-4"
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := a.Value.Any().(slog.Level); ok && level == LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
 	}
+	return a
 }
@@ -0,0 +1,229 @@
+package rpc
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moson-mo/goaurrpc/internal/config"
+)
+
+/*
+	Log files (LogFile, AccessLogFile and CaptureFile) are rotated when they exceed a size limit
+	or when a new rotation period (LogRotateInterval) starts.
+	Rotated files are renamed to <path>.<timestamp> and optionally compressed (<path>.<timestamp>.gz).
+	Old backups are removed according to LogMaxBackups and LogMaxAge.
+	On SIGUSR1 all files are reopened, so external tools like logrotate can move them away.
+*/
+
+// timestamp format of rotated files
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotation settings of a log file
+type rotation struct {
+	maxSize  int64         // in bytes (0 = unlimited)
+	interval time.Duration // 0 = no time based rotation
+	backups  int           // number of rotated files to keep (0 = all)
+	maxAge   time.Duration // rotated files older than this are removed (0 = never)
+	compress bool
+}
+
+// returns the rotation settings for a log file with a size limit in MB
+func logRotation(c *config.Settings, maxSizeMB int) rotation {
+	return rotation{
+		maxSize:  int64(maxSizeMB) * 1024 * 1024,
+		interval: time.Duration(c.LogRotateInterval) * time.Second,
+		backups:  c.LogMaxBackups,
+		maxAge:   time.Duration(c.LogMaxAge) * time.Second,
+		compress: c.LogCompress,
+	}
+}
+
+// logFile appends lines to a file. The file is (re-)opened when its path changes
+// and rotated according to the rotation settings passed with each write
+type logFile struct {
+	mut     sync.Mutex
+	path    string
+	file    *os.File
+	size    int64
+	since   time.Time // age of the file's contents, used for time based rotation
+	millMut sync.Mutex
+	mills   sync.WaitGroup
+}
+
+// appends b to the file at path
+func (lf *logFile) write(path string, rot rotation, b []byte) error {
+	lf.mut.Lock()
+	defer lf.mut.Unlock()
+
+	if lf.file != nil && lf.path != path {
+		lf.closeFile()
+	}
+	if lf.file == nil {
+		if err := lf.open(path); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	if lf.size > 0 && (rot.maxSize > 0 && lf.size+int64(len(b)) > rot.maxSize ||
+		rot.interval > 0 && !now.Truncate(rot.interval).Equal(lf.since.Truncate(rot.interval))) {
+		if err := lf.rotate(rot, now); err != nil {
+			return err
+		}
+	}
+
+	n, err := lf.file.Write(b)
+	lf.size += int64(n)
+	return err
+}
+
+func (lf *logFile) open(path string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	lf.file, lf.path, lf.size, lf.since = f, path, fi.Size(), time.Now()
+	if fi.Size() > 0 {
+		lf.since = fi.ModTime()
+	}
+	return nil
+}
+
+// renames our file to <path>.<timestamp> and starts a new one.
+// Compression and removal of old backups happens in the background
+func (lf *logFile) rotate(rot rotation, now time.Time) error {
+	path := lf.path
+	lf.closeFile()
+	backup := path + "." + now.UTC().Format(backupTimeFormat)
+	if err := os.Rename(path, backup); err != nil {
+		return err
+	}
+	if err := lf.open(path); err != nil {
+		return err
+	}
+
+	lf.mills.Add(1)
+	go func() {
+		defer lf.mills.Done()
+		lf.millMut.Lock()
+		defer lf.millMut.Unlock()
+		// best effort; backups stay uncompressed / are removed with the next rotation if anything fails
+		if rot.compress {
+			compressFile(backup)
+		}
+		removeBackups(path, rot, now)
+	}()
+	return nil
+}
+
+// compresses a file to <path>.gz and removes the original
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// removes rotated files exceeding the number of backups or the maximum age
+func removeBackups(path string, rot rotation, now time.Time) {
+	if rot.backups == 0 && rot.maxAge == 0 {
+		return
+	}
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	type backup struct {
+		name string
+		time time.Time
+	}
+	var backups []backup
+	for _, e := range entries {
+		ts, ok := strings.CutPrefix(e.Name(), base+".")
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, strings.TrimSuffix(ts, ".gz"))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{e.Name(), t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].time.After(backups[j].time) })
+
+	for i, b := range backups {
+		if rot.backups > 0 && i >= rot.backups || rot.maxAge > 0 && now.Sub(b.time) > rot.maxAge {
+			os.Remove(filepath.Join(dir, b.name))
+		}
+	}
+}
+
+func (lf *logFile) closeFile() {
+	if lf.file != nil {
+		lf.file.Close()
+		lf.file = nil
+	}
+}
+
+// reopen closes our file. It is opened again with the next write,
+// so messages end up in a new file after it has been moved away
+func (lf *logFile) reopen() {
+	lf.mut.Lock()
+	defer lf.mut.Unlock()
+	lf.closeFile()
+}
+
+// close closes our file and waits for running compressions
+func (lf *logFile) close() {
+	lf.reopen()
+	lf.mills.Wait()
+}
+
+// logWriter writes our log messages to LogFile
+type logWriter struct {
+	s    *server
+	file *logFile
+	path string
+}
+
+func (w logWriter) Write(b []byte) (int, error) {
+	conf := w.s.conf()
+	if err := w.file.write(w.path, logRotation(conf, conf.LogMaxSize), b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
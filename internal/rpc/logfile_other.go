@@ -0,0 +1,8 @@
+//go:build !unix
+
+package rpc
+
+import "os"
+
+// there is no SIGUSR1 on this platform; log files are only reopened when they are rotated
+func notifyReopen(c chan<- os.Signal) {}
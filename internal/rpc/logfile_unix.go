@@ -0,0 +1,14 @@
+//go:build unix
+
+package rpc
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// relays SIGUSR1 to c
+func notifyReopen(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
@@ -0,0 +1,44 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/moson-mo/goaurrpc/internal/config"
+	db "github.com/moson-mo/goaurrpc/internal/memdb"
+	"gopkg.in/guregu/null.v4"
+)
+
+// Query runs a query against package data without starting a server.
+// The parameters are the same as for /rpc requests (v, type, by, mode, arg / arg[]).
+// Suggest queries return a []string, info and search queries a RpcResult
+func Query(memDB *db.MemoryDB, maxResults int, params url.Values) (any, error) {
+	if err := validateParameters(params); err != nil {
+		return nil, err
+	}
+
+	s := server{memDB: memDB}
+	s.log = newLoggers(io.Discard, "", slog.LevelError)
+	conf := config.DefaultSettings()
+	conf.MaxResults = maxResults
+	conf.EnableSearchCache = false
+	s.storeSettings(*conf)
+
+	rtype := params.Get("type")
+	if strings.Contains(rtype, "suggest") {
+		return s.getSuggestResult(getArg(params), strings.HasSuffix(rtype, "pkgbase")), nil
+	}
+
+	result, _ := s.runQuery(context.Background(), params, "")
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+	verInt, _ := strconv.Atoi(params.Get("v"))
+	result.Version = null.NewInt(int64(verInt), true)
+	return result, nil
+}
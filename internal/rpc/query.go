@@ -1,10 +1,12 @@
 package rpc
 
 import (
+	"context"
 	"sort"
 	"strings"
 
-	"github.com/moson-mo/goaurrpc/internal/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // construct result for "info" calls
@@ -35,7 +37,7 @@ func (s *server) getInfoResult(by string, args []string, isV6 bool) RpcResult {
 			}
 
 			// we can bail out if we got more packages than our maximum
-			if len(uniquePackages) > s.conf.MaxResults {
+			if len(uniquePackages) > s.conf().MaxResults {
 				rr.Resultcount = len(uniquePackages)
 				return rr
 			}
@@ -60,26 +62,39 @@ func (s *server) getInfoResult(by string, args []string, isV6 bool) RpcResult {
 }
 
 // construct result for "search" calls
-func (s *server) getSearchResult(rtype, by, mode, arg, cacheKey string, isV6 bool) (RpcResult, bool) {
+func (s *server) getSearchResult(ctx context.Context, rtype, by, mode, arg, cacheKey string, isV6 bool) (RpcResult, cacheOutcome) {
 	rr := RpcResult{
 		Type: rtype,
 	}
 
 	// get from search cache
-	if s.conf.EnableSearchCache {
+	cacheEnabled := s.conf().EnableSearchCache
+	if cacheEnabled {
+		_, span := tracer.Start(ctx, "cache lookup")
 		s.mutCache.RLock()
 		res, found := s.searchCache[cacheKey]
+		if found {
+			res.Hits.Add(1)
+			s.cacheHits.Add(1)
+		} else {
+			s.cacheMisses.Add(1)
+		}
 		s.mutCache.RUnlock()
+		span.SetAttributes(attribute.Bool("cache.hit", found))
+		span.End()
 		if found {
 			// update cache hits metric
-			metrics.CacheHits.Inc()
+			s.metrics.CacheHits.Inc()
 
-			return res.Result, false
+			return res.Result, cacheHit
 		}
 	}
 
 	// search
+	_, span := tracer.Start(ctx, "search", trace.WithAttributes(attribute.String("search.by", by)))
+	defer span.End()
 	found, cache := s.search(arg, by, mode, isV6)
+	span.SetAttributes(attribute.Int("results", len(found)))
 
 	for _, pkg := range found {
 		if isV6 {
@@ -90,7 +105,14 @@ func (s *server) getSearchResult(rtype, by, mode, arg, cacheKey string, isV6 boo
 		rr.Resultcount++
 	}
 
-	return rr, cache
+	outcome := cacheNone
+	switch {
+	case cacheEnabled && cache:
+		outcome = cacheStore
+	case cacheEnabled:
+		outcome = cacheMiss
+	}
+	return rr, outcome
 }
 
 // construct result for "suggest" calls
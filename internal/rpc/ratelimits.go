@@ -0,0 +1,99 @@
+package rpc
+
+import (
+	"sort"
+	"time"
+)
+
+// returns a sorted page of rate limit records
+func (s *server) listRateLimits(sortBy string, desc bool, page, perPage int) RateLimitList {
+	s.mutLimit.RLock()
+	entries := make([]RateLimitEntry, 0, len(s.rateLimits))
+	for ip, rl := range s.rateLimits {
+		entries = append(entries, RateLimitEntry{IP: ip, RateLimit: rl})
+	}
+	s.mutLimit.RUnlock()
+
+	less := func(i, j int) bool {
+		if entries[i].Requests == entries[j].Requests {
+			return entries[i].IP < entries[j].IP
+		}
+		return entries[i].Requests < entries[j].Requests
+	}
+	switch sortBy {
+	case "ip":
+		less = func(i, j int) bool {
+			return entries[i].IP < entries[j].IP
+		}
+	case "window-start":
+		less = func(i, j int) bool {
+			return entries[i].WindowStart.Before(entries[j].WindowStart)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if desc {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+
+	list := RateLimitList{
+		Total:   len(entries),
+		Page:    page,
+		PerPage: perPage,
+		Entries: []RateLimitEntry{},
+	}
+	from := (page - 1) * perPage
+	if from < len(entries) {
+		to := from + perPage
+		if to > len(entries) {
+			to = len(entries)
+		}
+		list.Entries = entries[from:to]
+	}
+	return list
+}
+
+// returns the number of rate limit records
+func (s *server) rateLimitEntries() int {
+	s.mutLimit.RLock()
+	defer s.mutLimit.RUnlock()
+	return len(s.rateLimits)
+}
+
+// returns the rate limit record for an IP address
+func (s *server) getRateLimit(ip string) (RateLimit, bool) {
+	s.mutLimit.RLock()
+	defer s.mutLimit.RUnlock()
+	rl, ok := s.rateLimits[ip]
+	return rl, ok
+}
+
+// sets a custom limit / ban for an IP address. Creates a new record if needed
+func (s *server) setRateLimit(ip string, limit *int, banned *bool) RateLimit {
+	s.mutLimit.Lock()
+	defer s.mutLimit.Unlock()
+	rl, ok := s.rateLimits[ip]
+	if !ok {
+		rl.WindowStart = time.Now()
+	}
+	if limit != nil {
+		rl.Limit = *limit
+	}
+	if banned != nil {
+		rl.Banned = *banned
+	}
+	s.rateLimits[ip] = rl
+	s.log.admin.Info("Admin changed rate limit", "client", ip, "limit", rl.Limit, "banned", rl.Banned)
+	return rl
+}
+
+// removes the rate limit record for an IP address
+func (s *server) removeRateLimit(ip string) bool {
+	s.mutLimit.Lock()
+	defer s.mutLimit.Unlock()
+	_, ok := s.rateLimits[ip]
+	delete(s.rateLimits, ip)
+	s.log.admin.Info("Admin removed rate limit", "client", ip)
+	return ok
+}
@@ -1,20 +1,49 @@
 package rpc
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/moson-mo/goaurrpc/internal/admin"
 	"github.com/moson-mo/goaurrpc/internal/config"
 	"github.com/moson-mo/goaurrpc/internal/consts"
+	"github.com/moson-mo/goaurrpc/internal/tracing"
+
+	"github.com/goccy/go-json"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace/noop"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
 )
 
 type responseResult struct {
@@ -48,9 +77,12 @@ var conf = config.Settings{
 	CacheExpirationTime:      300,
 	RateLimitTimeWindow:      86400,
 	LogFile:                  "/tmp/log.tst",
+	LogLevel:                 "trace",
 	EnableMetrics:            true,
 	EnableAdminApi:           true,
 	AdminAPIKey:              "test",
+	EnableApiKeys:            true,
+	ApiKeyFile:               "/tmp/apikeys.tst",
 }
 var confBroken = config.Settings{
 	Port:                     99999,
@@ -69,6 +101,8 @@ var confBroken = config.Settings{
 	EnableMetrics:            true,
 	EnableAdminApi:           true,
 	AdminAPIKey:              "test",
+	EnableApiKeys:            true,
+	ApiKeyFile:               "/tmp/apikeys.tst",
 }
 
 // setup our test suite
@@ -220,8 +254,8 @@ func (suite *RpcTestSuite) SetupSuite() {
 		"/admin/settings/cache-cleanup-interval":      {`Current setting for 'CacheCleanupInterval' is '60'`, consts.ContentTypeText},
 		"/admin/settings/cache-expiration-time":       {`Current setting for 'CacheExpirationTime' is '300'`, consts.ContentTypeText},
 		"/admin/settings/enable-search-cache":         {`Current setting for 'EnableSearchCache' is 'true'`, consts.ContentTypeText},
-		"/admin/settings":                             {"{\n\t\"Port\": 10667,\n\t\"AurFileLocation\": \"../../test_data/test_packages.json\",\n\t\"MaxResults\": 5000,\n\t\"RefreshInterval\": 600,\n\t\"RateLimit\": 4000,\n\t\"LoadFromFile\": true,\n\t\"RateLimitCleanupInterval\": 600,\n\t\"RateLimitTimeWindow\": 86400,\n\t\"TrustedReverseProxies\": [\n\t\t\"127.0.0.1\",\n\t\t\"::1\"\n\t],\n\t\"EnableSSL\": false,\n\t\"CertFile\": \"\",\n\t\"KeyFile\": \"\",\n\t\"EnableSearchCache\": true,\n\t\"CacheCleanupInterval\": 60,\n\t\"CacheExpirationTime\": 300,\n\t\"LogFile\": \"/tmp/log.tst\",\n\t\"EnableMetrics\": true,\n\t\"EnableAdminApi\": true,\n\t\"AdminAPIKey\": \"test\"\n}", consts.ContentTypeJson},
-		"/admin/settings/":                            {"{\n\t\"Port\": 10667,\n\t\"AurFileLocation\": \"../../test_data/test_packages.json\",\n\t\"MaxResults\": 5000,\n\t\"RefreshInterval\": 600,\n\t\"RateLimit\": 4000,\n\t\"LoadFromFile\": true,\n\t\"RateLimitCleanupInterval\": 600,\n\t\"RateLimitTimeWindow\": 86400,\n\t\"TrustedReverseProxies\": [\n\t\t\"127.0.0.1\",\n\t\t\"::1\"\n\t],\n\t\"EnableSSL\": false,\n\t\"CertFile\": \"\",\n\t\"KeyFile\": \"\",\n\t\"EnableSearchCache\": true,\n\t\"CacheCleanupInterval\": 60,\n\t\"CacheExpirationTime\": 300,\n\t\"LogFile\": \"/tmp/log.tst\",\n\t\"EnableMetrics\": true,\n\t\"EnableAdminApi\": true,\n\t\"AdminAPIKey\": \"test\"\n}", consts.ContentTypeJson},
+		"/admin/settings":                             {"{\n\t\"Port\": 10667,\n\t\"ListenAddresses\": null,\n\t\"SocketMode\": \"\",\n\t\"SocketOwner\": \"\",\n\t\"AdminListenAddresses\": null,\n\t\"AurFileLocation\": \"../../test_data/test_packages.json\",\n\t\"MaxResults\": 5000,\n\t\"RefreshInterval\": 600,\n\t\"RateLimit\": 4000,\n\t\"LoadFromFile\": true,\n\t\"RateLimitCleanupInterval\": 600,\n\t\"RateLimitTimeWindow\": 86400,\n\t\"TrustedReverseProxies\": [\n\t\t\"127.0.0.1\",\n\t\t\"::1\"\n\t],\n\t\"EnableSSL\": false,\n\t\"CertFile\": \"\",\n\t\"KeyFile\": \"\",\n\t\"TLSMinVersion\": \"\",\n\t\"TLSCipherSuites\": null,\n\t\"AdminClientCAFile\": \"\",\n\t\"HTTPRedirectPort\": 0,\n\t\"EnableSearchCache\": true,\n\t\"CacheCleanupInterval\": 60,\n\t\"CacheExpirationTime\": 300,\n\t\"DataStaleThreshold\": 0,\n\t\"ShutdownDelay\": 0,\n\t\"ShutdownTimeout\": 0,\n\t\"ReadHeaderTimeout\": 0,\n\t\"ReadTimeout\": 0,\n\t\"WriteTimeout\": 0,\n\t\"IdleTimeout\": 0,\n\t\"MaxHeaderBytes\": 0,\n\t\"MaxBodySize\": 0,\n\t\"MaxArgs\": 0,\n\t\"MaxArgLength\": 0,\n\t\"LogFile\": \"/tmp/log.tst\",\n\t\"LogLevel\": \"trace\",\n\t\"LogFormat\": \"\",\n\t\"AccessLog\": false,\n\t\"AccessLogFile\": \"\",\n\t\"AccessLogFormat\": \"\",\n\t\"LogMaxSize\": 0,\n\t\"LogRotateInterval\": 0,\n\t\"LogMaxBackups\": 0,\n\t\"LogMaxAge\": 0,\n\t\"LogCompress\": false,\n\t\"EnableTracing\": false,\n\t\"TracingEndpoint\": \"\",\n\t\"TracingSampleRate\": 0,\n\t\"EnableMetrics\": true,\n\t\"EnablePprof\": false,\n\t\"EnableAdminApi\": true,\n\t\"AdminAPIKey\": \"test\",\n\t\"EnableApiKeys\": true,\n\t\"ApiKeyFile\": \"/tmp/apikeys.tst\",\n\t\"PersistSettings\": false,\n\t\"WatchConfigFile\": false,\n\t\"CaptureRequests\": false,\n\t\"CaptureFile\": \"\",\n\t\"CaptureSampleRate\": 0,\n\t\"CaptureMaxSize\": 0\n}", consts.ContentTypeJson},
+		"/admin/settings/":                            {"{\n\t\"Port\": 10667,\n\t\"ListenAddresses\": null,\n\t\"SocketMode\": \"\",\n\t\"SocketOwner\": \"\",\n\t\"AdminListenAddresses\": null,\n\t\"AurFileLocation\": \"../../test_data/test_packages.json\",\n\t\"MaxResults\": 5000,\n\t\"RefreshInterval\": 600,\n\t\"RateLimit\": 4000,\n\t\"LoadFromFile\": true,\n\t\"RateLimitCleanupInterval\": 600,\n\t\"RateLimitTimeWindow\": 86400,\n\t\"TrustedReverseProxies\": [\n\t\t\"127.0.0.1\",\n\t\t\"::1\"\n\t],\n\t\"EnableSSL\": false,\n\t\"CertFile\": \"\",\n\t\"KeyFile\": \"\",\n\t\"TLSMinVersion\": \"\",\n\t\"TLSCipherSuites\": null,\n\t\"AdminClientCAFile\": \"\",\n\t\"HTTPRedirectPort\": 0,\n\t\"EnableSearchCache\": true,\n\t\"CacheCleanupInterval\": 60,\n\t\"CacheExpirationTime\": 300,\n\t\"DataStaleThreshold\": 0,\n\t\"ShutdownDelay\": 0,\n\t\"ShutdownTimeout\": 0,\n\t\"ReadHeaderTimeout\": 0,\n\t\"ReadTimeout\": 0,\n\t\"WriteTimeout\": 0,\n\t\"IdleTimeout\": 0,\n\t\"MaxHeaderBytes\": 0,\n\t\"MaxBodySize\": 0,\n\t\"MaxArgs\": 0,\n\t\"MaxArgLength\": 0,\n\t\"LogFile\": \"/tmp/log.tst\",\n\t\"LogLevel\": \"trace\",\n\t\"LogFormat\": \"\",\n\t\"AccessLog\": false,\n\t\"AccessLogFile\": \"\",\n\t\"AccessLogFormat\": \"\",\n\t\"LogMaxSize\": 0,\n\t\"LogRotateInterval\": 0,\n\t\"LogMaxBackups\": 0,\n\t\"LogMaxAge\": 0,\n\t\"LogCompress\": false,\n\t\"EnableTracing\": false,\n\t\"TracingEndpoint\": \"\",\n\t\"TracingSampleRate\": 0,\n\t\"EnableMetrics\": true,\n\t\"EnablePprof\": false,\n\t\"EnableAdminApi\": true,\n\t\"AdminAPIKey\": \"test\",\n\t\"EnableApiKeys\": true,\n\t\"ApiKeyFile\": \"/tmp/apikeys.tst\",\n\t\"PersistSettings\": false,\n\t\"WatchConfigFile\": false,\n\t\"CaptureRequests\": false,\n\t\"CaptureFile\": \"\",\n\t\"CaptureSampleRate\": 0,\n\t\"CaptureMaxSize\": 0\n}", consts.ContentTypeJson},
 	}
 
 	suite.ExpectedAdminResultsPOST = map[string]string{
@@ -260,14 +294,14 @@ func (suite *RpcTestSuite) SetupSuite() {
 		"/admin/settings/enable-search-cache?value=x":         `strconv.ParseBool: parsing "x": invalid syntax`,
 		"/admin/settings/enable-search-cache":                 `Need new value: ?value=...`,
 		"/admin/settings/nonsense":                            `Setting not found`,
-		"/admin/settings":                                     `Setting not found`,
-		"/admin/settings/":                                    `Setting not found`,
+		"/admin/settings":                                     `Method not allowed`,
+		"/admin/settings/":                                    `Method not allowed`,
 	}
 
 	var err error
-	suite.srv, err = New(conf, true, true, "")
+	suite.srv, err = New(conf, "")
 	suite.Nil(err, "Could not create rpc server")
-	suite.srv.setupRoutes()
+	suite.srv.setupRoutes(false)
 
 	// start webserver for some http tests
 	modTime := time.Now().UTC()
@@ -298,25 +332,35 @@ func (suite *RpcTestSuite) SetupSuite() {
 // run before each test
 func (suite *RpcTestSuite) SetupTest() {
 	// reset settings
-	suite.srv.conf = conf
+	suite.srv.storeSettings(conf)
 	suite.srv.lastRefresh = time.Time{}
-	suite.srv.reloadData()
+	suite.srv.reloadData(context.Background())
 }
 
 // cleanup
 func (suite *RpcTestSuite) TearDownSuite() {
-	log := suite.srv.conf.LogFile
+	log := suite.srv.conf().LogFile
 	_, err := os.Stat(log)
 	if log != "" && err == nil {
 		err = os.Remove(log)
 		suite.Nil(err)
 	}
 
+	os.Remove(conf.ApiKeyFile)
+
 	suite.httpSrv.Shutdown(context.TODO())
 
 	fmt.Println(">>> RPC tests completed")
 }
 
+// change settings of our test server
+func (suite *RpcTestSuite) changeSettings(fn func(*config.Settings)) {
+	suite.Nil(suite.srv.changeSettings(func(c *config.Settings) error {
+		fn(c)
+		return nil
+	}))
+}
+
 // test function returning a list of arguments
 func (suite *RpcTestSuite) TestGetArgumentList() {
 	for k, v := range suite.ExpectedArgumentsList {
@@ -335,7 +379,7 @@ func (suite *RpcTestSuite) TestGetArgumentSingle() {
 
 // test handlers
 func (suite *RpcTestSuite) TestRpcHandlers() {
-	suite.srv.conf.MaxResults = 10
+	suite.changeSettings(func(c *config.Settings) { c.MaxResults = 10 })
 
 	for i := 0; i < 2; i++ {
 		// get requests
@@ -366,8 +410,10 @@ func (suite *RpcTestSuite) TestRpcHandlers() {
 		}
 
 		// lets disable search cache and rate limit for the next iteration
-		suite.srv.conf.EnableSearchCache = false
-		suite.srv.conf.RateLimit = 0
+		suite.changeSettings(func(c *config.Settings) {
+			c.EnableSearchCache = false
+			c.RateLimit = 0
+		})
 	}
 }
 
@@ -380,15 +426,16 @@ func (suite *RpcTestSuite) TestAdminHandlers() {
 
 	suite.srv.router.ServeHTTP(rr, req)
 	suite.Nil(err, "Could not create GET request")
-	suite.Equal("Unauthorized", rr.Body.String(), "Should return 'Unauthorized'")
+	suite.Equal("{\n\t\"Status\": \"error\",\n\t\"Code\": \"unauthorized\",\n\t\"Message\": \"Unauthorized\"\n}", rr.Body.String())
 	suite.Equal(http.StatusUnauthorized, rr.Result().StatusCode)
-	suite.Equal(consts.ContentTypeText, rr.Result().Header.Get("Content-Type"))
+	suite.Equal(consts.ContentTypeJson, rr.Result().Header.Get("Content-Type"))
 
 	// get requests
 	for k, v := range suite.ExpectedAdminResultsGET {
 		rr := httptest.NewRecorder()
 		req, err := http.NewRequest("GET", k, nil)
 		req.Header.Add("APIKey", "test")
+		req.Header.Add("Accept", consts.ContentTypeText)
 		suite.Nil(err, "Could not create GET request")
 
 		suite.srv.router.ServeHTTP(rr, req)
@@ -398,12 +445,13 @@ func (suite *RpcTestSuite) TestAdminHandlers() {
 
 	// post requests
 	for k, v := range suite.ExpectedAdminResultsPOST {
-		suite.srv.conf.RateLimit = 4000
+		suite.changeSettings(func(c *config.Settings) { c.RateLimit = 4000 })
 
 		rr := httptest.NewRecorder()
 		req, err := http.NewRequest("POST", k, nil)
 		req.Header.Add("Content-Type", consts.ContentTypeForm)
 		req.Header.Add("APIKey", "test")
+		req.Header.Add("Accept", consts.ContentTypeText)
 		suite.Nil(err, "Could not create POST request")
 
 		suite.srv.router.ServeHTTP(rr, req)
@@ -413,33 +461,36 @@ func (suite *RpcTestSuite) TestAdminHandlers() {
 
 	// data reload - ok
 	suite.srv.lastRefresh = time.Time{}
-	suite.srv.conf.AurFileLocation = conf.AurFileLocation
+	suite.changeSettings(func(c *config.Settings) { c.AurFileLocation = conf.AurFileLocation })
 	rr = httptest.NewRecorder()
 	req, err = http.NewRequest("POST", "/admin/run-job/reload-data", nil)
 	req.Header.Add("APIKey", "test")
+	req.Header.Add("Accept", consts.ContentTypeText)
 
 	suite.srv.router.ServeHTTP(rr, req)
 	suite.Nil(err, "Could not create POST request")
 	suite.Equal("Successfully reloaded data", rr.Body.String(), "Should return 'Successfully reloaded data'")
-	suite.Equal(http.StatusAccepted, rr.Result().StatusCode)
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
 	suite.Equal(consts.ContentTypeText, rr.Result().Header.Get("Content-Type"))
 
 	// data reload, not changed
 	rr = httptest.NewRecorder()
 	req, err = http.NewRequest("POST", "/admin/run-job/reload-data", nil)
 	req.Header.Add("APIKey", "test")
+	req.Header.Add("Accept", consts.ContentTypeText)
 
 	suite.srv.router.ServeHTTP(rr, req)
 	suite.Nil(err, "Could not create POST request")
 	suite.Equal("Reload skipped. Data has not changed", rr.Body.String(), "Should return 'Reload skipped. Data has not changed'")
-	suite.Equal(http.StatusAccepted, rr.Result().StatusCode)
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
 	suite.Equal(consts.ContentTypeText, rr.Result().Header.Get("Content-Type"))
 
 	// data reload - fail
-	suite.srv.conf.AurFileLocation = "nonsense"
+	suite.changeSettings(func(c *config.Settings) { c.AurFileLocation = "nonsense" })
 	rr = httptest.NewRecorder()
 	req, err = http.NewRequest("POST", "/admin/run-job/reload-data", nil)
 	req.Header.Add("APIKey", "test")
+	req.Header.Add("Accept", consts.ContentTypeText)
 
 	suite.srv.router.ServeHTTP(rr, req)
 	suite.Nil(err, "Could not create POST request")
@@ -448,9 +499,611 @@ func (suite *RpcTestSuite) TestAdminHandlers() {
 	suite.Equal(consts.ContentTypeText, rr.Result().Header.Get("Content-Type"))
 }
 
+// test JSON responses of the admin API
+func (suite *RpcTestSuite) TestAdminResponses() {
+	request := func(method, url, body string) (*httptest.ResponseRecorder, admin.Response) {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		suite.Nil(err, "Could not create request")
+		req.Header.Add("APIKey", "test")
+		suite.srv.router.ServeHTTP(rr, req)
+
+		var res admin.Response
+		suite.Nil(json.Unmarshal(rr.Body.Bytes(), &res), "Input: "+url)
+		suite.Equal(consts.ContentTypeJson, rr.Result().Header.Get("Content-Type"), "Input: "+url)
+		return rr, res
+	}
+
+	// single setting
+	rr, res := request("GET", "/admin/settings/max-results", "")
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.Equal("MaxResults", res.Setting)
+	suite.Equal(float64(5000), res.Value)
+
+	rr, res = request("POST", "/admin/settings/rate-limit?value=0", "")
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.Equal("ok", res.Status)
+	suite.Equal(float64(4000), res.Old)
+	suite.Equal(float64(0), res.New)
+	suite.Equal("Rate limit is disabled", res.Warning)
+
+	rr, res = request("POST", "/admin/settings/max-results?value=x", "")
+	suite.Equal(http.StatusBadRequest, rr.Result().StatusCode)
+	suite.Equal("invalid_value", res.Code)
+
+	rr, res = request("POST", "/admin/settings/max-results", "")
+	suite.Equal(http.StatusBadRequest, rr.Result().StatusCode)
+	suite.Equal("missing_value", res.Code)
+
+	rr, res = request("POST", "/admin/settings/nonsense", "")
+	suite.Equal(http.StatusNotFound, rr.Result().StatusCode)
+	suite.Equal("setting_not_found", res.Code)
+
+	// multiple settings
+	rr, res = request("PATCH", "/admin/settings", `{"MaxResults":100,"EnableSearchCache":false,"AurFileLocation":"xyz"}`)
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.Equal([]admin.SettingChange{
+		{Setting: "AurFileLocation", Old: "../../test_data/test_packages.json", New: "xyz"},
+		{Setting: "EnableSearchCache", Old: true, New: false},
+		{Setting: "MaxResults", Old: float64(5000), New: float64(100)},
+	}, res.Changes)
+	suite.Equal(100, suite.srv.conf().MaxResults)
+	suite.False(suite.srv.conf().EnableSearchCache)
+
+	// nothing is changed if one of the values is invalid
+	for body, code := range map[string]string{
+		`{"MaxResults":1,"RateLimit":"x"}`: "invalid_value",
+		`{"MaxResults":1,"Port":1}`:        "setting_not_changeable",
+		`{"MaxResults":1,"max-results":2}`: "setting_not_changeable",
+		`{"MaxResults":1`:                  "invalid_body",
+		`{}`:                               "invalid_body",
+	} {
+		rr, res = request("PATCH", "/admin/settings", body)
+		suite.Equal(http.StatusBadRequest, rr.Result().StatusCode, "Input: "+body)
+		suite.Equal(code, res.Code, "Input: "+body)
+		suite.Equal(100, suite.srv.conf().MaxResults, "Input: "+body)
+	}
+
+	// jobs
+	rr, res = request("GET", "/admin/run-job/wipe-cache", "")
+	suite.Equal(http.StatusMethodNotAllowed, rr.Result().StatusCode)
+	suite.Equal("method_not_allowed", res.Code)
+
+	rr, res = request("POST", "/admin/run-job/nonsense", "")
+	suite.Equal(http.StatusNotFound, rr.Result().StatusCode)
+	suite.Equal("job_not_found", res.Code)
+
+	rr, res = request("POST", "/admin/run-job/wipe-ratelimits", "")
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.Equal(float64(0), res.Value)
+
+	rr, res = request("POST", "/admin/run-job/cleanup-cache?async=true", "")
+	suite.Equal(http.StatusAccepted, rr.Result().StatusCode)
+	suite.Equal("accepted", res.Status)
+
+	atomic.StoreInt32(&suite.srv.reloading, 1)
+	rr, res = request("POST", "/admin/run-job/reload-data", "")
+	atomic.StoreInt32(&suite.srv.reloading, 0)
+	suite.Equal(http.StatusConflict, rr.Result().StatusCode)
+	suite.Equal("reload_in_progress", res.Code)
+}
+
+// test writing settings to the config file
+func (suite *RpcTestSuite) TestPersistSettings() {
+	path := filepath.Join(suite.T().TempDir(), "goaurrpc.conf")
+	suite.Nil(os.WriteFile(path, []byte("{\n\t\"TrustedReverseProxies\": [\"127.0.0.1\", \"::1\"]\n}"), 0600))
+	defer suite.srv.SetConfigSource("", nil)
+
+	request := func(method, url string) (*httptest.ResponseRecorder, admin.Response) {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(method, url, nil)
+		suite.Nil(err, "Could not create request")
+		req.Header.Add("APIKey", "test")
+		suite.srv.router.ServeHTTP(rr, req)
+
+		var res admin.Response
+		suite.Nil(json.Unmarshal(rr.Body.Bytes(), &res), "Input: "+url)
+		return rr, res
+	}
+
+	// disabled
+	rr, res := request("POST", "/admin/settings/persist")
+	suite.Equal(http.StatusForbidden, rr.Result().StatusCode)
+	suite.Equal("persist_disabled", res.Code)
+
+	// no config file
+	suite.changeSettings(func(c *config.Settings) { c.PersistSettings = true })
+	rr, res = request("POST", "/admin/settings/persist")
+	suite.Equal(http.StatusConflict, rr.Result().StatusCode)
+	suite.Equal("no_config_file", res.Code)
+
+	suite.srv.SetConfigSource(path, nil)
+	rr, _ = request("GET", "/admin/settings/persist")
+	suite.Equal(http.StatusMethodNotAllowed, rr.Result().StatusCode)
+
+	rr, res = request("POST", "/admin/settings/persist")
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.True(res.Persisted)
+	b, err := os.ReadFile(path)
+	suite.Nil(err)
+	suite.Contains(string(b), `"TrustedReverseProxies": ["127.0.0.1", "::1"]`)
+	saved, err := config.LoadFromFile(path)
+	suite.Nil(err)
+	suite.Equal(suite.srv.conf(), saved)
+
+	// changes are persisted immediately
+	rr, res = request("POST", "/admin/settings/max-results?value=10")
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.True(res.Persisted)
+	saved, err = config.LoadFromFile(path)
+	suite.Nil(err)
+	suite.Equal(10, saved.MaxResults)
+	suite.Empty(res.NotPersisted)
+
+	// overridden settings are not written and reported
+	suite.T().Setenv(config.EnvName("MaxResults"), "20")
+	rr, res = request("POST", "/admin/settings/max-results?value=30")
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.True(res.Persisted)
+	suite.Equal([]string{"MaxResults"}, res.NotPersisted)
+	suite.Contains(res.Warning, "MaxResults")
+	saved, err = config.LoadFromFile(path)
+	suite.Nil(err)
+	suite.Equal(10, saved.MaxResults)
+
+	rr, res = request("POST", "/admin/settings/rate-limit?value=100")
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.Empty(res.NotPersisted)
+
+	rr, res = request("POST", "/admin/settings/persist")
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.Equal([]string{"MaxResults"}, res.NotPersisted)
+
+	// backups of previous files
+	backups, err := filepath.Glob(path + ".*.bak")
+	suite.Nil(err)
+	suite.NotEmpty(backups)
+}
+
+// test reloading the config file
+func (suite *RpcTestSuite) TestReloadConfig() {
+	path := filepath.Join(suite.T().TempDir(), "goaurrpc.conf")
+	defer suite.srv.SetConfigSource("", nil)
+
+	writeConf := func(fn func(c *config.Settings)) {
+		c := conf
+		fn(&c)
+		b, err := json.Marshal(c)
+		suite.Nil(err)
+		suite.Nil(os.WriteFile(path+".tmp", b, 0600))
+		suite.Nil(os.Rename(path+".tmp", path))
+	}
+
+	request := func() (*httptest.ResponseRecorder, admin.Response) {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/admin/run-job/reload-config", nil)
+		suite.Nil(err, "Could not create request")
+		req.Header.Add("APIKey", "test")
+		suite.srv.router.ServeHTTP(rr, req)
+
+		var res admin.Response
+		suite.Nil(json.Unmarshal(rr.Body.Bytes(), &res))
+		return rr, res
+	}
+
+	// no config file
+	rr, res := request()
+	suite.Equal(http.StatusConflict, rr.Result().StatusCode)
+	suite.Equal("no_config_file", res.Code)
+
+	// runtime settings are applied, others need a restart
+	suite.srv.SetConfigSource(path, nil)
+	writeConf(func(c *config.Settings) {
+		c.MaxResults = 100
+		c.TrustedReverseProxies = []string{"10.0.0.1"}
+		c.Port = 1234
+	})
+	rr, res = request()
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.Equal([]admin.SettingChange{
+		{Setting: "MaxResults", Old: float64(5000), New: float64(100)},
+		{Setting: "TrustedReverseProxies", Old: []any{"127.0.0.1", "::1"}, New: []any{"10.0.0.1"}},
+	}, res.Changes)
+	suite.Equal([]string{"Port"}, res.RestartRequired)
+	suite.Equal(100, suite.srv.conf().MaxResults)
+	suite.Equal([]string{"10.0.0.1"}, suite.srv.conf().TrustedReverseProxies)
+	suite.Equal(conf.Port, suite.srv.conf().Port)
+
+	// invalid config is not applied
+	writeConf(func(c *config.Settings) { c.MaxResults = 0 })
+	rr, res = request()
+	suite.Equal(http.StatusInternalServerError, rr.Result().StatusCode)
+	suite.Equal("invalid_config", res.Code)
+	suite.Equal(100, suite.srv.conf().MaxResults)
+
+	// SIGHUP and modified file
+	ctx, cancel := context.WithCancel(context.Background())
+	hup := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		suite.srv.watchConfig(ctx, hup, 10*time.Millisecond)
+		close(done)
+	}()
+
+	writeConf(func(c *config.Settings) { c.MaxResults = 200 })
+	hup <- syscall.SIGHUP
+	suite.Eventually(func() bool { return suite.srv.conf().MaxResults == 200 }, 5*time.Second, 10*time.Millisecond)
+
+	suite.changeSettings(func(c *config.Settings) { c.WatchConfigFile = true })
+	time.Sleep(50 * time.Millisecond)
+	writeConf(func(c *config.Settings) {
+		c.MaxResults = 300
+		c.WatchConfigFile = true
+	})
+	suite.Nil(os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	suite.Eventually(func() bool { return suite.srv.conf().MaxResults == 300 }, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+
+	// persisting keeps restart-only settings that were changed in the file
+	writeConf(func(c *config.Settings) {
+		c.Port = 1234
+		c.PersistSettings = true
+	})
+	_, res = request()
+	suite.Equal([]string{"Port"}, res.RestartRequired)
+	rr = httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/admin/settings/max-results?value=10", nil)
+	suite.Require().NoError(err)
+	req.Header.Add("APIKey", "test")
+	suite.srv.router.ServeHTTP(rr, req)
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	saved, err := config.LoadFromFile(path)
+	suite.Require().NoError(err)
+	suite.Equal(1234, saved.Port)
+	suite.Equal(10, saved.MaxResults)
+	suite.Equal(conf.Port, suite.srv.conf().Port)
+}
+
+// test request capture
+func (suite *RpcTestSuite) TestCaptureRequests() {
+	path := filepath.Join(suite.T().TempDir(), "requests.jsonl")
+	request := func(method, url, body string) int {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		suite.Nil(err, "Could not create request")
+		req.Header.Add("APIKey", "test")
+		if body != "" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		req.RemoteAddr = "192.168.1.10:1234"
+		suite.srv.router.ServeHTTP(rr, req)
+		return rr.Result().StatusCode
+	}
+	records := func() []CaptureRecord {
+		b, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		suite.Nil(err)
+		var list []CaptureRecord
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			var rec CaptureRecord
+			suite.Nil(json.Unmarshal([]byte(line), &rec), line)
+			list = append(list, rec)
+		}
+		return list
+	}
+
+	// disabled
+	request("GET", "/rpc?v=5&type=info&arg=attest", "")
+	suite.Nil(records())
+
+	// enabling requires a file
+	suite.Equal(http.StatusBadRequest, request("POST", "/admin/settings/capture-requests?value=true", ""))
+	suite.changeSettings(func(c *config.Settings) { c.CaptureFile = path })
+	suite.Equal(http.StatusOK, request("POST", "/admin/settings/capture-requests?value=true", ""))
+
+	request("GET", "/rpc?v=5&type=info&arg=attest&apikey=secret", "")
+	request("POST", "/rpc", "v=5&type=search&arg=at")
+	request("GET", "/rpc/v5/suggest/at", "")
+	request("GET", "/admin/settings", "")
+
+	list := records()
+	suite.Len(list, 3)
+	suite.Equal("GET", list[0].Method)
+	suite.Equal("/rpc", list[0].Path)
+	suite.Equal(url.Values{"v": {"5"}, "type": {"info"}, "arg": {"attest"}}, list[0].Params)
+	suite.Equal(http.StatusUnauthorized, list[0].Status) // invalid API key
+	suite.NotZero(list[0].Size)
+	suite.Len(list[0].Client, 16)
+	suite.NotContains(list[0].Client, "192.168")
+	suite.Equal(list[0].Client, list[1].Client)
+	suite.Equal("POST", list[1].Method)
+	suite.Equal(url.Values{"v": {"5"}, "type": {"search"}, "arg": {"at"}}, list[1].Params)
+	suite.Equal(http.StatusOK, list[1].Status)
+	suite.Equal("/rpc/v5/suggest/at", list[2].Path)
+	suite.Empty(list[2].Params)
+
+	// sampling
+	suite.Equal(http.StatusOK, request("POST", "/admin/settings/capture-sample-rate?value=1000000", ""))
+	for i := 0; i < 10; i++ {
+		request("GET", "/rpc?v=5&type=info&arg=attest", "")
+	}
+	suite.Len(records(), 3)
+
+	// rotation
+	rec := newRecorder()
+	for i := 0; i < 3; i++ {
+		suite.Nil(rec.record(path, rotation{maxSize: 200}, list[0]))
+	}
+	rec.close()
+	backups, _ := filepath.Glob(path + ".*")
+	suite.NotEmpty(backups)
+	suite.Len(records(), 1)
+
+	// disabled at runtime
+	suite.Equal(http.StatusOK, request("POST", "/admin/settings/capture-requests?value=false", ""))
+	request("GET", "/rpc?v=5&type=info&arg=attest", "")
+	suite.Len(records(), 1)
+	suite.srv.recorder.close()
+	suite.srv.wipeRateLimits()
+}
+
+// test access log and log levels
+func (suite *RpcTestSuite) TestLogging() {
+	path := filepath.Join(suite.T().TempDir(), "access.log")
+	request := func(method, url string) int {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(method, url, nil)
+		suite.Nil(err, "Could not create request")
+		req.Header.Add("APIKey", "test")
+		req.Header.Set("User-Agent", "test-agent")
+		req.RemoteAddr = "192.168.1.11:1234"
+		suite.srv.router.ServeHTTP(rr, req)
+		return rr.Result().StatusCode
+	}
+	lines := func() []string {
+		b, err := os.ReadFile(path)
+		suite.Nil(err)
+		return strings.Split(strings.TrimSpace(string(b)), "\n")
+	}
+
+	suite.changeSettings(func(c *config.Settings) {
+		c.AccessLogFile = path
+		c.AccessLogFormat = "json"
+	})
+	suite.Equal(http.StatusOK, request("POST", "/admin/settings/access-log?value=true"))
+	request("GET", "/rpc?v=5&type=search&arg=attest")
+	request("GET", "/rpc?v=5&type=search&arg=attest")
+	request("GET", "/rpc?v=5&type=info&arg=attest&apikey=nonsense")
+	request("GET", "/rpc/v5/suggest/at")
+	request("GET", "/admin/settings")
+
+	var list []AccessRecord
+	for _, line := range lines() {
+		var rec AccessRecord
+		suite.Nil(json.Unmarshal([]byte(line), &rec), line)
+		list = append(list, rec)
+	}
+	suite.Len(list, 4)
+	suite.Equal("192.168.1.11", list[0].Client)
+	suite.Equal("/rpc?v=5&type=search&arg=attest", list[0].URI)
+	suite.Equal(http.StatusOK, list[0].Status)
+	suite.NotZero(list[0].Size)
+	suite.Equal("test-agent", list[0].UserAgent)
+	suite.Equal("search", list[0].Type)
+	suite.Equal("miss", list[0].Cache)
+	suite.Equal("ok", list[0].RateLimit)
+	suite.Equal("hit", list[1].Cache)
+	suite.Equal(http.StatusUnauthorized, list[2].Status)
+	suite.Equal("key-invalid", list[2].RateLimit)
+	suite.Equal("", list[2].Cache)
+	suite.Equal("suggest", list[3].Type)
+
+	// combined format
+	suite.changeSettings(func(c *config.Settings) { c.AccessLogFormat = "combined" })
+	request("GET", "/rpc?v=5&type=info&arg=attest")
+	l := lines()
+	suite.Regexp(`^192\.168\.1\.11 - - \[.+\] "GET /rpc\?v=5&type=info&arg=attest HTTP/1\.1" 200 \d+ "-" "test-agent" latency=[\d.]+ type=info cache=- ratelimit=ok trace_id=-$`, l[len(l)-1])
+
+	// log level
+	suite.Equal(http.StatusOK, request("POST", "/admin/settings/log-level?value=warn"))
+	suite.Equal(slog.LevelWarn, confLevel{suite.srv}.Level())
+	suite.Equal(http.StatusBadRequest, request("POST", "/admin/settings/log-level?value=verbose"))
+	suite.Equal("warn", suite.srv.conf().LogLevel)
+
+	var buf bytes.Buffer
+	logs := newLoggers(&buf, "text", LevelTrace)
+	logTrace(logs.cache, "Removed cache entry", "key", "x")
+	suite.Contains(buf.String(), `level=TRACE msg="Removed cache entry" subsystem=cache key=x`)
+	buf.Reset()
+	logs = newLoggers(&buf, "json", slog.LevelInfo)
+	logs.admin.Debug("hidden")
+	logs.admin.Info("shown")
+	suite.Contains(buf.String(), `"level":"INFO","msg":"shown","subsystem":"admin"`)
+	suite.NotContains(buf.String(), "hidden")
+
+	suite.srv.accessLog.close()
+	suite.srv.wipeRateLimits()
+	suite.srv.wipeSearchCache()
+}
+
+// test tracing with a fake OTLP collector
+func (suite *RpcTestSuite) TestTracing() {
+	var mut sync.Mutex
+	spans := make(map[string][]*tracepb.Span)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		suite.Nil(err)
+		var req coltracepb.ExportTraceServiceRequest
+		suite.Nil(proto.Unmarshal(b, &req))
+		mut.Lock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					spans[span.Name] = append(spans[span.Name], span)
+				}
+			}
+		}
+		mut.Unlock()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(nil)
+	}))
+	defer collector.Close()
+	// served gzip encoded, so we get download and decompress spans
+	aur := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := os.ReadFile("../../test_data/test_packages.json")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write(b)
+		gz.Close()
+	}))
+	defer aur.Close()
+
+	stop, err := tracing.Setup(collector.URL+"/v1/traces", 1, "test")
+	suite.Nil(err)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	path := filepath.Join(suite.T().TempDir(), "access.log")
+	suite.changeSettings(func(c *config.Settings) {
+		c.AccessLog = true
+		c.AccessLogFile = path
+		c.AccessLogFormat = "json"
+	})
+
+	// incoming trace context is continued
+	suite.srv.wipeSearchCache()
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/rpc?v=5&type=search&arg=attest", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	suite.srv.router.ServeHTTP(rr, req)
+	suite.Equal(http.StatusOK, rr.Code)
+	rr = httptest.NewRecorder()
+	suite.srv.router.ServeHTTP(rr, httptest.NewRequest("GET", "/rpc?v=5&type=info&arg=attest", nil))
+	suite.Equal(http.StatusOK, rr.Code)
+
+	// reload
+	suite.changeSettings(func(c *config.Settings) {
+		c.AurFileLocation = aur.URL
+		c.LoadFromFile = false
+	})
+	suite.srv.lastRefresh = time.Time{}
+	suite.Nil(suite.srv.reloadData(context.Background()))
+
+	suite.Nil(stop(context.Background()))
+	for _, name := range []string{"GET /rpc", "ratelimit", "validate", "cache lookup", "search", "info", "serialize", "write",
+		"reloadData", "download", "decompress", "decode", "build index"} {
+		suite.NotEmpty(spans[name], name)
+	}
+	suite.Len(spans["GET /rpc"], 2)
+	server := spans["GET /rpc"][0]
+	suite.Equal(traceID, hex.EncodeToString(server.TraceId))
+	suite.Equal("00f067aa0ba902b7", hex.EncodeToString(server.ParentSpanId))
+	suite.Equal(server.SpanId, spans["search"][0].ParentSpanId)
+	suite.Equal(spans["reloadData"][0].SpanId, spans["download"][0].ParentSpanId)
+
+	// trace IDs in the access log
+	b, err := os.ReadFile(path)
+	suite.Nil(err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	suite.Len(lines, 2)
+	var rec AccessRecord
+	suite.Nil(json.Unmarshal([]byte(lines[0]), &rec))
+	suite.Equal(traceID, rec.TraceID)
+	suite.Nil(json.Unmarshal([]byte(lines[1]), &rec))
+	suite.Equal(hex.EncodeToString(spans["GET /rpc"][1].TraceId), rec.TraceID)
+
+	suite.srv.accessLog.close()
+	suite.srv.wipeRateLimits()
+	suite.srv.wipeSearchCache()
+
+	// tracing enabled on startup
+	conf := *suite.srv.conf()
+	conf.LogFile = ""
+	conf.EnableTracing = true
+	conf.TracingEndpoint = collector.URL + "/v1/traces"
+	srv, err := New(conf, "test")
+	suite.Require().NoError(err)
+	suite.Require().NotNil(srv.stopTracing)
+	suite.Nil(srv.stopTracing(context.Background()))
+}
+
+// test log file rotation
+func (suite *RpcTestSuite) TestLogRotation() {
+	dir := suite.T().TempDir()
+	path := filepath.Join(dir, "test.log")
+	backups := func() []string {
+		files, err := filepath.Glob(path + ".*")
+		suite.Nil(err)
+		return files
+	}
+	line := []byte(strings.Repeat("x", 99) + "\n")
+
+	// size based; old backups are removed
+	lf := &logFile{}
+	for i := 0; i < 10; i++ {
+		suite.Nil(lf.write(path, rotation{maxSize: 250, backups: 2}, line))
+		time.Sleep(2 * time.Millisecond) // backups are named by timestamp
+	}
+	lf.close()
+	suite.Len(backups(), 2)
+	fi, err := os.Stat(path)
+	suite.Nil(err)
+	suite.Equal(int64(200), fi.Size())
+
+	// time based with compression
+	for _, b := range backups() {
+		os.Remove(b)
+	}
+	suite.Nil(lf.write(path, rotation{interval: time.Hour, compress: true}, line))
+	lf.since = time.Now().Add(-time.Hour)
+	suite.Nil(lf.write(path, rotation{interval: time.Hour, compress: true}, line))
+	lf.close()
+	suite.Len(backups(), 1)
+	suite.True(strings.HasSuffix(backups()[0], ".gz"), backups()[0])
+	f, err := os.Open(backups()[0])
+	suite.Nil(err)
+	gz, err := gzip.NewReader(f)
+	suite.Nil(err)
+	b, err := io.ReadAll(gz)
+	f.Close()
+	suite.Nil(err)
+	suite.Equal(300, len(b))
+
+	// retention by age
+	old := path + "." + time.Now().Add(-48*time.Hour).UTC().Format(backupTimeFormat)
+	suite.Nil(os.WriteFile(old, line, 0644))
+	removeBackups(path, rotation{maxAge: 24 * time.Hour}, time.Now())
+	suite.NoFileExists(old)
+	suite.Len(backups(), 1)
+
+	// reopen after the file has been moved away (SIGUSR1)
+	suite.changeSettings(func(c *config.Settings) {
+		c.AccessLog = true
+		c.AccessLogFile = path
+	})
+	request := func() {
+		req, err := http.NewRequest("GET", "/rpc?v=5&type=info&arg=attest", nil)
+		suite.Nil(err)
+		suite.srv.router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	request()
+	suite.Nil(os.Rename(path, path+".moved"))
+	request()
+	suite.NoFileExists(path)
+	suite.srv.reopenLogs()
+	request()
+	suite.FileExists(path)
+	suite.srv.accessLog.close()
+	suite.srv.wipeRateLimits()
+}
+
 // test rate limit
 func (suite *RpcTestSuite) TestRateLimit() {
-	suite.srv.conf.RateLimit = 1
+	suite.changeSettings(func(c *config.Settings) { c.RateLimit = 1 })
 
 	for i := 0; i < 10; i++ {
 		rr := httptest.NewRecorder()
@@ -523,24 +1176,413 @@ func (suite *RpcTestSuite) TestRateLimit() {
 	}
 }
 
+// test client API keys
+func (suite *RpcTestSuite) TestApiKeys() {
+	suite.changeSettings(func(c *config.Settings) { c.RateLimit = 1 })
+
+	// create key
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/admin/apikeys?label=ci&quota=2&endpoints=/rpc", nil)
+	suite.Nil(err, "Could not create POST request")
+	req.Header.Add("APIKey", "test")
+
+	suite.srv.router.ServeHTTP(rr, req)
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.Equal(consts.ContentTypeJson, rr.Result().Header.Get("Content-Type"))
+
+	var created struct {
+		Key  string
+		Hash string
+	}
+	suite.Nil(json.Unmarshal(rr.Body.Bytes(), &created))
+	suite.Equal(hashApiKey(created.Key), created.Hash)
+
+	// key file should contain the hash only
+	keys, err := loadApiKeys(conf.ApiKeyFile)
+	suite.Nil(err)
+	suite.Contains(keys, created.Hash)
+	b, _ := os.ReadFile(conf.ApiKeyFile)
+	suite.NotContains(string(b), created.Key)
+
+	// requests with a valid key are not subject to the IP based rate limit
+	for i := 0; i < 3; i++ {
+		rr = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "/rpc?v=5&type=info&arg=attest", nil)
+		suite.Nil(err, "Could not create GET request")
+		req.Header.Add("X-API-Key", created.Key)
+
+		suite.srv.router.ServeHTTP(rr, req)
+		if i < 2 {
+			suite.Equal(http.StatusOK, rr.Result().StatusCode, "request number: ", i)
+			suite.Contains(rr.Body.String(), `"resultcount":1`)
+		} else {
+			suite.Equal(http.StatusTooManyRequests, rr.Result().StatusCode)
+			suite.Contains(rr.Body.String(), "Rate limit reached")
+		}
+	}
+
+	// key passed as parameter, endpoint not allowed
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/api/v6/info/attest?apikey="+created.Key, nil)
+	suite.Nil(err, "Could not create GET request")
+
+	suite.srv.router.ServeHTTP(rr, req)
+	suite.Equal(http.StatusForbidden, rr.Result().StatusCode)
+	suite.Contains(rr.Body.String(), ErrEndpointNotAllowed.Error())
+
+	// endpoints are no plain prefixes
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/rpc.php?v=5&type=info&arg=attest&apikey="+created.Key, nil)
+	suite.Nil(err, "Could not create GET request")
+
+	suite.srv.router.ServeHTTP(rr, req)
+	suite.Equal(http.StatusForbidden, rr.Result().StatusCode)
+	suite.True(endpointAllowed([]string{"/api/"}, "/api/v6/info/attest"))
+	suite.True(endpointAllowed([]string{"/rpc"}, "/rpc"))
+	suite.False(endpointAllowed([]string{"/rpc"}, "/rpcx"))
+
+	// invalid key
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/rpc?v=5&type=info&arg=attest&apikey=wrong", nil)
+	suite.Nil(err, "Could not create GET request")
+
+	suite.srv.router.ServeHTTP(rr, req)
+	suite.Equal(http.StatusUnauthorized, rr.Result().StatusCode)
+	suite.Contains(rr.Body.String(), ErrInvalidApiKey.Error())
+
+	// list keys
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/admin/apikeys", nil)
+	suite.Nil(err, "Could not create GET request")
+	req.Header.Add("APIKey", "test")
+
+	suite.srv.router.ServeHTTP(rr, req)
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.Contains(rr.Body.String(), `"Label": "ci"`)
+	suite.Contains(rr.Body.String(), `"Requests": 3`)
+
+	// a new quota window starts once the current one has passed
+	suite.srv.mutKeys.Lock()
+	la := suite.srv.keyLimits[created.Hash]
+	la.WindowStart = time.Now().Add(-time.Duration(suite.srv.conf().RateLimitTimeWindow+1) * time.Second)
+	suite.srv.keyLimits[created.Hash] = la
+	suite.srv.mutKeys.Unlock()
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/rpc?v=5&type=info&arg=attest", nil)
+	suite.Nil(err, "Could not create GET request")
+	req.Header.Add("X-API-Key", created.Key)
+
+	suite.srv.router.ServeHTTP(rr, req)
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+
+	// remove key
+	for i, expected := range []int{http.StatusOK, http.StatusNotFound} {
+		rr = httptest.NewRecorder()
+		req, err = http.NewRequest("DELETE", "/admin/apikeys/"+created.Hash, nil)
+		suite.Nil(err, "Could not create DELETE request")
+		req.Header.Add("APIKey", "test")
+
+		suite.srv.router.ServeHTTP(rr, req)
+		suite.Equal(expected, rr.Result().StatusCode, "request number: ", i)
+	}
+	keys, err = loadApiKeys(conf.ApiKeyFile)
+	suite.Nil(err)
+	suite.NotContains(keys, created.Hash)
+}
+
+// test /admin/ratelimits handlers
+func (suite *RpcTestSuite) TestAdminRateLimits() {
+	suite.srv.wipeRateLimits()
+	suite.srv.mutLimit.Lock()
+	suite.srv.rateLimits["10.0.0.1"] = RateLimit{Requests: 5, WindowStart: time.Now()}
+	suite.srv.rateLimits["10.0.0.2"] = RateLimit{Requests: 50, WindowStart: time.Now()}
+	suite.srv.rateLimits["10.0.0.3"] = RateLimit{Requests: 10, WindowStart: time.Now()}
+	suite.srv.mutLimit.Unlock()
+
+	adminRequest := func(method, url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(method, url, nil)
+		suite.Nil(err, "Could not create request")
+		req.Header.Add("APIKey", "test")
+		req.Header.Add("Accept", consts.ContentTypeText)
+		suite.srv.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// list, sorted by number of requests
+	rr := adminRequest("GET", "/admin/ratelimits?per-page=2")
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	var list RateLimitList
+	suite.Nil(json.Unmarshal(rr.Body.Bytes(), &list))
+	suite.Equal(3, list.Total)
+	suite.Len(list.Entries, 2)
+	suite.Equal("10.0.0.2", list.Entries[0].IP)
+	suite.Equal("10.0.0.3", list.Entries[1].IP)
+
+	rr = adminRequest("GET", "/admin/ratelimits?per-page=2&page=2&order=desc")
+	suite.Nil(json.Unmarshal(rr.Body.Bytes(), &list))
+	suite.Len(list.Entries, 1)
+	suite.Equal("10.0.0.1", list.Entries[0].IP)
+
+	rr = adminRequest("GET", "/admin/ratelimits?sort=ip")
+	suite.Nil(json.Unmarshal(rr.Body.Bytes(), &list))
+	suite.Equal("10.0.0.1", list.Entries[0].IP)
+
+	rr = adminRequest("GET", "/admin/ratelimits?sort=nonsense")
+	suite.Equal("Invalid sort field", rr.Body.String())
+	rr = adminRequest("GET", "/admin/ratelimits?page=0")
+	suite.Equal("Invalid page number", rr.Body.String())
+
+	// single record
+	rr = adminRequest("GET", "/admin/ratelimits/10.0.0.2")
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.Contains(rr.Body.String(), `"Requests": 50`)
+	rr = adminRequest("GET", "/admin/ratelimits/10.9.9.9")
+	suite.Equal(http.StatusNotFound, rr.Result().StatusCode)
+
+	// custom limit & ban
+	rr = adminRequest("PUT", "/admin/ratelimits/10.0.0.1")
+	suite.Equal("Need new value: ?limit=... or ?ban=...", rr.Body.String())
+	rr = adminRequest("PUT", "/admin/ratelimits/10.0.0.1?limit=x")
+	suite.Equal(http.StatusBadRequest, rr.Result().StatusCode)
+	rr = adminRequest("PUT", "/admin/ratelimits/10.0.0.1?limit=6")
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.Contains(rr.Body.String(), `"Limit": 6`)
+	suite.False(suite.srv.isRateLimited("10.0.0.1"))
+	suite.True(suite.srv.isRateLimited("10.0.0.1"))
+
+	rr = adminRequest("PUT", "/admin/ratelimits/10.0.0.4?ban=true")
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.Contains(rr.Body.String(), `"Banned": true`)
+	suite.True(suite.srv.isRateLimited("10.0.0.4"))
+
+	// values that are not given stay unchanged
+	rr = adminRequest("PUT", "/admin/ratelimits/10.0.0.4?limit=3")
+	suite.Contains(rr.Body.String(), `"Banned": true`)
+	suite.Contains(rr.Body.String(), `"Limit": 3`)
+	rr = adminRequest("PUT", "/admin/ratelimits/10.0.0.4?ban=false")
+	suite.NotContains(rr.Body.String(), `"Banned"`)
+	suite.Contains(rr.Body.String(), `"Limit": 3`)
+	rr = adminRequest("PUT", "/admin/ratelimits/10.0.0.4?ban=true")
+	suite.Contains(rr.Body.String(), `"Limit": 3`)
+
+	rr = adminRequest("PUT", "/admin/ratelimits/10.0.0.5?limit=-1")
+	suite.Equal("Limit can not be negative", rr.Body.String())
+	rr = adminRequest("PUT", "/admin/ratelimits/nonsense?ban=true")
+	suite.Equal("Invalid IP address", rr.Body.String())
+	_, found := suite.srv.getRateLimit("nonsense")
+	suite.False(found)
+
+	// bans and custom limits apply even if rate limiting is disabled
+	rateLimit := suite.srv.conf().RateLimit
+	suite.changeSettings(func(c *config.Settings) { c.RateLimit = 0 })
+	suite.True(suite.srv.isRateLimited("10.0.0.4"))
+	suite.True(suite.srv.isRateLimited("10.0.0.1"))
+	suite.False(suite.srv.isRateLimited("10.0.0.6"))
+	_, found = suite.srv.getRateLimit("10.0.0.6")
+	suite.False(found)
+	suite.changeSettings(func(c *config.Settings) { c.RateLimit = rateLimit })
+
+	// bans and custom limits survive a cleanup
+	suite.srv.mutLimit.Lock()
+	rl := suite.srv.rateLimits["10.0.0.4"]
+	rl.WindowStart = time.Now().AddDate(0, 0, -2)
+	suite.srv.rateLimits["10.0.0.4"] = rl
+	suite.srv.mutLimit.Unlock()
+	suite.srv.cleanupRateLimits()
+	rl, found = suite.srv.getRateLimit("10.0.0.4")
+	suite.True(found)
+	suite.True(rl.Banned)
+	suite.Equal(0, rl.Requests)
+
+	// remove
+	rr = adminRequest("DELETE", "/admin/ratelimits/10.0.0.4")
+	suite.Equal("Removed rate limit for '10.0.0.4'", rr.Body.String())
+	rr = adminRequest("DELETE", "/admin/ratelimits/10.0.0.4")
+	suite.Equal(http.StatusNotFound, rr.Result().StatusCode)
+	rr = adminRequest("DELETE", "/admin/ratelimits")
+	suite.Equal(http.StatusMethodNotAllowed, rr.Result().StatusCode)
+
+	suite.srv.wipeRateLimits()
+}
+
+// test /admin/cache handlers
+func (suite *RpcTestSuite) TestAdminCache() {
+	suite.srv.wipeSearchCache()
+
+	request := func(method, url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(method, url, nil)
+		suite.Nil(err, "Could not create request")
+		req.Header.Add("APIKey", "test")
+		req.Header.Add("Accept", consts.ContentTypeText)
+		suite.srv.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// fill cache; second request for attest is a cache hit
+	request("GET", "/rpc?v=5&type=search&arg=attest")
+	request("GET", "/rpc?v=5&type=search&arg=attest")
+	request("GET", "/rpc?v=5&type=search&by=name&arg=australia")
+
+	rr := request("GET", "/admin/cache")
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	var list CacheList
+	suite.Nil(json.Unmarshal(rr.Body.Bytes(), &list))
+	suite.Equal(2, list.Stats.Entries)
+	suite.Equal(1, list.Stats.Hits)
+	suite.Equal(2, list.Stats.Misses)
+	suite.InDelta(0.33, list.Stats.HitRatio, 0.01)
+	suite.Len(list.Entries, 2)
+	suite.Equal("arg=attest&type=search&v=5", list.Entries[0].Key)
+	suite.Equal(1, list.Entries[0].Hits)
+	suite.Greater(list.Entries[0].Size, 0)
+	suite.Equal(list.Entries[0].Size+list.Entries[1].Size, list.Stats.Bytes)
+
+	// single entry (escaped key)
+	key := url.PathEscape("arg=attest&type=search&v=5")
+	rr = request("GET", "/admin/cache/"+key)
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.Contains(rr.Body.String(), `"resultcount": 6`)
+	rr = request("GET", "/admin/cache/nonsense")
+	suite.Equal(http.StatusNotFound, rr.Result().StatusCode)
+
+	// purge by pattern
+	evictions := testutil.ToFloat64(suite.srv.metrics.CacheEvictions)
+	rr = request("DELETE", "/admin/cache")
+	suite.Equal("Need filter: ?arg=...&type=...&by=...", rr.Body.String())
+	rr = request("DELETE", "/admin/cache?arg=ustra")
+	suite.Equal("Purged search-cache (1 entries removed)", rr.Body.String())
+	suite.Equal(1, len(suite.srv.searchCache))
+
+	// remove single entry
+	rr = request("DELETE", "/admin/cache/"+key)
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	rr = request("DELETE", "/admin/cache/"+key)
+	suite.Equal(http.StatusNotFound, rr.Result().StatusCode)
+	rr = request("PUT", "/admin/cache")
+	suite.Equal(http.StatusMethodNotAllowed, rr.Result().StatusCode)
+	suite.Equal(evictions+2, testutil.ToFloat64(suite.srv.metrics.CacheEvictions))
+
+	suite.srv.wipeRateLimits()
+}
+
+// test /admin/stats handler
+func (suite *RpcTestSuite) TestAdminStats() {
+	suite.changeSettings(func(c *config.Settings) { c.AurFileLocation = "nonsense" })
+	suite.srv.reloadData(context.Background())
+
+	request := func(url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", url, nil)
+		suite.Nil(err, "Could not create GET request")
+		req.Header.Add("APIKey", "test")
+		suite.srv.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := request("/admin/stats")
+	suite.Equal(http.StatusOK, rr.Result().StatusCode)
+	suite.Equal(consts.ContentTypeJson, rr.Result().Header.Get("Content-Type"))
+	var stats ServerStats
+	suite.Nil(json.Unmarshal(rr.Body.Bytes(), &stats))
+	suite.Equal(666, stats.Packages)
+	suite.Greater(stats.PackageBases, 0)
+	suite.Equal(666, stats.IndexSizes["PackageNames"])
+	suite.Greater(stats.Memory.Sys, uint64(0))
+	suite.Greater(stats.Goroutines, 0)
+	suite.NotEmpty(stats.Reloads)
+	suite.LessOrEqual(len(stats.Reloads), maxReloadHistory)
+	last := stats.Reloads[len(stats.Reloads)-1]
+	suite.Equal("error", last.Result)
+	suite.Equal("stat nonsense: no such file or directory", last.Error)
+
+	rr = request("/admin/stats?format=text")
+	suite.Equal(consts.ContentTypeText, rr.Result().Header.Get("Content-Type"))
+	suite.Contains(rr.Body.String(), "packages: 666\n")
+	suite.Regexp(`reload\.\d+: \S+ error \d+ms stat nonsense: no such file or directory\n$`, rr.Body.String())
+
+	rr = request("/admin/stats?format=xml")
+	suite.Equal(http.StatusBadRequest, rr.Result().StatusCode)
+	var res admin.Response
+	suite.Nil(json.Unmarshal(rr.Body.Bytes(), &res))
+	suite.Equal(admin.Response{Status: "error", Code: "invalid_value", Message: "Invalid format. Use 'json' or 'text'"}, res)
+}
+
+// test settings snapshots
+func (suite *RpcTestSuite) TestChangeSettings() {
+	before := suite.srv.conf()
+
+	// invalid settings are rejected
+	err := suite.srv.changeSettings(func(c *config.Settings) error {
+		c.MaxResults = 0
+		return nil
+	})
+	suite.NotNil(err)
+	suite.Equal(before, suite.srv.conf())
+
+	// old snapshot is not modified
+	changed := suite.srv.settingsChanged()
+	suite.changeSettings(func(c *config.Settings) {
+		c.MaxResults = 1
+		c.TrustedReverseProxies[0] = "10.0.0.1"
+	})
+	suite.Equal(5000, before.MaxResults)
+	suite.Equal("127.0.0.1", before.TrustedReverseProxies[0])
+	suite.Equal(1, suite.srv.conf().MaxResults)
+	suite.Equal("10.0.0.1", suite.srv.conf().TrustedReverseProxies[0])
+
+	// waiting routines got notified
+	select {
+	case <-changed:
+	default:
+		suite.Fail("settingsChanged channel has not been closed")
+	}
+}
+
+// test that jobs pick up interval changes immediately
+func (suite *RpcTestSuite) TestJobIntervalChange() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{}, 1)
+	go suite.srv.runPeriodically(ctx, "Test", func(c *config.Settings) int {
+		return c.CacheCleanupInterval
+	}, func() {
+		select {
+		case done <- struct{}{}:
+		default:
+		}
+	})
+
+	// lower the interval from 60 seconds to 1 second
+	suite.changeSettings(func(c *config.Settings) { c.CacheCleanupInterval = 1 })
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		suite.Fail("Job has not been run after changing the interval")
+	}
+	cancel()
+}
+
 // test create server
 func (suite *RpcTestSuite) TestListen() {
-	suite.srv.conf.RateLimitCleanupInterval = 1
-	suite.srv.conf.RefreshInterval = 1
-	suite.srv.conf.CacheCleanupInterval = 1
-	suite.srv.conf.CacheExpirationTime = 1
+	suite.changeSettings(func(c *config.Settings) {
+		c.RateLimitCleanupInterval = 1
+		c.RefreshInterval = 1
+		c.CacheCleanupInterval = 1
+		c.CacheExpirationTime = 1
+	})
 	suite.srv.lastRefresh = time.Time{}
 
+	res := make(chan error, 1)
 	go func() {
-		err := suite.srv.Listen()
-		suite.Equal(http.ErrServerClosed, err)
+		res <- suite.srv.Listen()
 	}()
 
 	suite.srv.mutLimit.Lock()
 	suite.srv.rateLimits["test"] = RateLimit{WindowStart: time.Now().AddDate(0, 0, -2), Requests: 1}
 	suite.srv.mutLimit.Unlock()
 	suite.srv.mutCache.Lock()
-	suite.srv.searchCache["test"] = CacheEntry{}
+	suite.srv.searchCache["test"] = &CacheEntry{}
 	suite.srv.mutCache.Unlock()
 	time.Sleep(1200 * time.Millisecond)
 	suite.srv.mutLimit.Lock()
@@ -551,48 +1593,528 @@ func (suite *RpcTestSuite) TestListen() {
 	suite.srv.mutCache.Unlock()
 	time.Sleep(1200 * time.Millisecond)
 	suite.srv.Stop()
-	srv, err := New(confBroken, false, false, "")
+	// wait until we have shut down, so nothing leaks into the next test
+	suite.Equal(http.ErrServerClosed, <-res)
+	srv, err := New(confBroken, "")
+	suite.Nil(err)
+	suite.NotNil(srv.Listen())
+}
+
+// test listening on unix sockets and notifying systemd
+func (suite *RpcTestSuite) TestListenAddresses() {
+	dir := suite.T().TempDir()
+	notify, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "notify.sock"), Net: "unixgram"})
+	suite.Nil(err)
+	defer notify.Close()
+	suite.T().Setenv("NOTIFY_SOCKET", filepath.Join(dir, "notify.sock"))
+	expectState := func(state string) {
+		buf := make([]byte, 64)
+		notify.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, err := notify.Read(buf)
+		suite.Nil(err)
+		suite.Equal(state, string(buf[:n]))
+	}
+
+	// a stale socket from a previous run
+	path := filepath.Join(dir, "rpc.sock")
+	stale, err := net.Listen("unix", path)
+	suite.Nil(err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	conf := *suite.srv.conf()
+	conf.LogFile = ""
+	conf.ListenAddresses = []string{"unix:" + path, "127.0.0.1:0"}
+	conf.SocketMode = "0600"
+	srv, err := New(conf, "")
+	suite.Nil(err)
+	res := make(chan error, 1)
+	go func() {
+		res <- srv.Listen()
+	}()
+	expectState("READY=1")
+
+	fi, err := os.Stat(path)
+	suite.Nil(err)
+	suite.Equal(os.FileMode(0600), fi.Mode().Perm())
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://localhost/rpc?v=5&type=info&arg=attest")
+	suite.Require().NoError(err)
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	suite.Equal(http.StatusOK, resp.StatusCode)
+	suite.Contains(string(b), `"resultcount":1`)
+
+	srv.Stop()
+	expectState("STOPPING=1")
+	suite.Equal(http.ErrServerClosed, <-res)
+	_, err = os.Stat(path)
+	suite.True(os.IsNotExist(err), "Socket should have been removed")
+
+	// we don't replace other files or create sockets in missing directories
+	suite.Nil(os.WriteFile(path, nil, 0600))
+	conf.ListenAddresses = []string{"unix:" + path}
+	srv, err = New(conf, "")
+	suite.Nil(err)
+	suite.ErrorContains(srv.Listen(), "is not a socket")
+	conf.ListenAddresses = []string{"unix:" + filepath.Join(dir, "missing", "rpc.sock")}
+	srv, err = New(conf, "")
 	suite.Nil(err)
 	suite.NotNil(srv.Listen())
 }
 
+// test serving admin, metrics, pprof and health routes on a separate listener
+func (suite *RpcTestSuite) TestAdminListener() {
+	dir := suite.T().TempDir()
+	unixClient := func(path string) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}}
+	}
+	public, admin := unixClient(filepath.Join(dir, "rpc.sock")), unixClient(filepath.Join(dir, "admin.sock"))
+	get := func(client *http.Client, path string) int {
+		req, err := http.NewRequest("GET", "http://localhost"+path, nil)
+		suite.Nil(err)
+		req.Header.Set("APIKey", "test")
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	conf := *suite.srv.conf()
+	conf.LogFile = ""
+	conf.ListenAddresses = []string{"unix:" + filepath.Join(dir, "rpc.sock")}
+	conf.AdminListenAddresses = []string{"unix:" + filepath.Join(dir, "admin.sock")}
+	conf.EnablePprof = true
+	srv, err := New(conf, "")
+	suite.Nil(err)
+	res := make(chan error, 1)
+	go func() {
+		res <- srv.Listen()
+	}()
+	suite.Eventually(func() bool {
+		return get(admin, "/healthz") == http.StatusOK
+	}, 3*time.Second, 10*time.Millisecond)
+
+	for _, path := range []string{"/healthz", "/readyz", "/metrics", "/admin/settings", "/admin/openapi.json", "/debug/pprof/"} {
+		suite.Equal(http.StatusOK, get(admin, path), path)
+		suite.Equal(http.StatusNotFound, get(public, path), path)
+	}
+	suite.Equal(http.StatusOK, get(public, "/rpc?v=5&type=info&arg=attest"))
+	suite.Equal(http.StatusNotFound, get(admin, "/rpc?v=5&type=info&arg=attest"))
+
+	srv.Stop()
+	suite.Equal(http.ErrServerClosed, <-res)
+
+	// without a separate listener, pprof is not exposed
+	suite.changeSettings(func(c *config.Settings) { c.EnablePprof = true })
+	suite.srv.setupRoutes(false)
+	suite.Nil(suite.srv.adminRouter)
+	rr := httptest.NewRecorder()
+	suite.srv.router.ServeHTTP(rr, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	suite.Equal(http.StatusNotFound, rr.Code)
+	suite.changeSettings(func(c *config.Settings) { c.EnablePprof = false })
+}
+
+// certificate and key for our TLS tests
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// returns a certificate for localhost, signed by parent (self-signed if nil)
+func (suite *RpcTestSuite) newCert(commonName string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.Nil(err)
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parentCert, parentKey := &tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, parentCert, &key.PublicKey, parentKey)
+	suite.Nil(err)
+	cert, err := x509.ParseCertificate(der)
+	suite.Nil(err)
+	return &testCert{cert, key}
+}
+
+// writes certificate and key as PEM files
+func (c *testCert) write(dir, name string) (string, string) {
+	keyDer, _ := x509.MarshalECPrivateKey(c.key)
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+// test TLS settings, client certificates, certificate reloads and redirects to HTTPS
+func (suite *RpcTestSuite) TestTLS() {
+	dir := suite.T().TempDir()
+	ca := suite.newCert("goaurrpc test CA", nil)
+	client := suite.newCert("admin", ca)
+	rogue := suite.newCert("admin", nil)
+	certFile, keyFile := suite.newCert("first", ca).write(dir, "server")
+	caFile, _ := ca.write(dir, "ca")
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	freePort := func() int {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		suite.Require().NoError(err)
+		defer l.Close()
+		return l.Addr().(*net.TCPAddr).Port
+	}
+	redirectPort, tlsPort := freePort(), freePort()
+
+	conf := *suite.srv.conf()
+	conf.LogFile = ""
+	conf.ListenAddresses = []string{"unix:" + filepath.Join(dir, "rpc.sock"), "127.0.0.1:" + strconv.Itoa(tlsPort)}
+	conf.EnableSSL = true
+	conf.CertFile, conf.KeyFile = certFile, keyFile
+	conf.TLSMinVersion = "1.3"
+	conf.AdminClientCAFile = caFile
+	conf.HTTPRedirectPort = redirectPort
+	srv, err := New(conf, "")
+	suite.Nil(err)
+	res := make(chan error, 1)
+	go func() {
+		res <- srv.Listen()
+	}()
+
+	// a new connection for each request, so we see reloaded certificates
+	get := func(tc *tls.Config, path string) (*http.Response, error) {
+		client := http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", filepath.Join(dir, "rpc.sock"))
+			},
+			TLSClientConfig:   tc,
+			DisableKeepAlives: true,
+		}}
+		req, err := http.NewRequest("GET", "https://localhost"+path, nil)
+		suite.Nil(err)
+		req.Header.Set("APIKey", "test")
+		resp, err := client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+	base := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+	served := func(tc *tls.Config) string {
+		resp, err := get(tc, "/healthz")
+		if err != nil {
+			return ""
+		}
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	suite.Eventually(func() bool { return served(base) == "first" }, 3*time.Second, 10*time.Millisecond)
+
+	// minimum TLS version
+	resp, err := get(base, "/rpc?v=5&type=info&arg=attest")
+	suite.Require().NoError(err)
+	suite.Equal(http.StatusOK, resp.StatusCode)
+	suite.Equal(uint16(tls.VersionTLS13), resp.TLS.Version)
+	old := base.Clone()
+	old.MaxVersion = tls.VersionTLS12
+	_, err = get(old, "/rpc?v=5&type=info&arg=attest")
+	suite.NotNil(err)
+
+	// the admin API requires a client certificate issued by our CA
+	resp, err = get(base, "/admin/settings")
+	suite.Require().NoError(err)
+	suite.Equal(http.StatusUnauthorized, resp.StatusCode)
+	withCert := base.Clone()
+	withCert.Certificates = []tls.Certificate{client.tlsCert()}
+	resp, err = get(withCert, "/admin/settings")
+	suite.Require().NoError(err)
+	suite.Equal(http.StatusOK, resp.StatusCode)
+	withRogue := base.Clone()
+	withRogue.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		cert := rogue.tlsCert() // sent although it isn't issued by one of the requested CAs
+		return &cert, nil
+	}
+	_, err = get(withRogue, "/admin/settings")
+	suite.NotNil(err)
+
+	// plain HTTP is redirected
+	plain := http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err = plain.Get("http://localhost:" + strconv.Itoa(redirectPort) + "/rpc?v=5&type=info&arg=attest")
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusPermanentRedirect, resp.StatusCode)
+	suite.Equal("https://localhost:"+strconv.Itoa(tlsPort)+"/rpc?v=5&type=info&arg=attest", resp.Header.Get("Location"))
+	for host, location := range map[string]string{
+		"[::1]:80":    "https://[::1]:8443/",
+		"[::1]":       "https://[::1]:8443/",
+		"localhost":   "https://localhost:8443/",
+		"1.2.3.4:123": "https://1.2.3.4:8443/",
+	} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = host
+		redirectToHTTPS(8443)(rr, req)
+		suite.Equal(location, rr.Header().Get("Location"), host)
+	}
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v6/info/attest", nil)
+	req.Host = "[::1]"
+	redirectToHTTPS(443)(rr, req)
+	suite.Equal("https://[::1]/api/v6/info/attest", rr.Header().Get("Location"))
+	unix, err := net.Listen("unix", filepath.Join(dir, "other.sock"))
+	suite.Require().NoError(err)
+	_, ok := httpsPort(nil)
+	suite.False(ok)
+	_, ok = httpsPort([]net.Listener{unix})
+	suite.False(ok)
+	unix.Close()
+
+	// certificate is reloaded on SIGHUP
+	suite.newCert("second", ca).write(dir, "server")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hup := make(chan os.Signal, 1)
+	go srv.watchCertificates(ctx, hup, time.Hour)
+	hup <- syscall.SIGHUP
+	suite.Eventually(func() bool { return served(base) == "second" }, 3*time.Second, 10*time.Millisecond)
+
+	// and when the files have been modified
+	reloaded, err := srv.certs.reloadIfModified()
+	suite.Nil(err)
+	suite.False(reloaded)
+	suite.newCert("third", ca).write(dir, "server")
+	future := time.Now().Add(time.Minute)
+	suite.Nil(os.Chtimes(certFile, future, future))
+	reloaded, err = srv.certs.reloadIfModified()
+	suite.Nil(err)
+	suite.True(reloaded)
+	suite.Equal("third", served(base))
+
+	// broken files don't replace our current certificate
+	suite.Nil(os.WriteFile(certFile, []byte("broken"), 0600))
+	future = future.Add(time.Minute)
+	suite.Nil(os.Chtimes(certFile, future, future))
+	_, err = srv.certs.reloadIfModified()
+	suite.NotNil(err)
+	suite.Equal("third", served(base))
+	_, err = srv.certs.reloadIfModified()
+	suite.Nil(err) // reported once per modification
+	future = future.Add(time.Minute)
+	suite.Nil(os.Chtimes(certFile, future, future))
+	_, err = srv.certs.reloadIfModified()
+	suite.NotNil(err)
+
+	srv.Stop()
+	suite.Equal(http.ErrServerClosed, <-res)
+}
+
+// test request limits and server timeouts
+func (suite *RpcTestSuite) TestRequestLimits() {
+	request := func(method, url, body string) (int, RpcResult) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if method == "POST" {
+			req.Header.Set("Content-Type", consts.ContentTypeForm)
+		}
+		suite.srv.router.ServeHTTP(rr, req)
+		var res RpcResult
+		suite.Nil(json.Unmarshal(rr.Body.Bytes(), &res), rr.Body.String())
+		return rr.Code, res
+	}
+
+	suite.changeSettings(func(c *config.Settings) {
+		c.MaxBodySize = 100
+		c.MaxArgs = 2
+		c.MaxArgLength = 10
+	})
+
+	// body size
+	code, res := request("POST", "/rpc", "v=5&type=info&arg[]=attest")
+	suite.Equal(http.StatusOK, code)
+	suite.Equal("multiinfo", res.Type)
+	code, res = request("POST", "/rpc", "v=5&type=info&arg[]="+strings.Repeat("a", 100))
+	suite.Equal(http.StatusRequestEntityTooLarge, code)
+	suite.Equal(ErrBodyTooLarge.Error(), res.Error)
+	code, res = request("POST", "/api/v6/info", "arg="+strings.Repeat("a", 100))
+	suite.Equal(http.StatusRequestEntityTooLarge, code)
+	suite.Equal(int64(6), res.Version.Int64)
+
+	// number of arguments
+	code, res = request("GET", "/rpc?v=5&type=info&arg[]=a1&arg[]=a2&arg[]=a3", "")
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(ErrTooManyArgs.Error(), res.Error)
+	code, res = request("GET", "/api/v6/info?arg=a1&arg=a2&arg=a3", "")
+	suite.Equal(http.StatusBadRequest, code)
+	suite.Equal(ErrTooManyArgs.Error(), res.Error)
+	code, _ = request("GET", "/api/v6/info?arg=a1&arg=a2", "")
+	suite.Equal(http.StatusOK, code)
+
+	// argument length
+	code, res = request("GET", "/rpc?v=5&type=search&arg=attestattest", "")
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(ErrArgTooLong.Error(), res.Error)
+	code, res = request("GET", "/api/v6/search/attestattest", "")
+	suite.Equal(http.StatusBadRequest, code)
+	suite.Equal(ErrArgTooLong.Error(), res.Error)
+
+	// clients that don't send their headers are disconnected
+	conf := *suite.srv.conf()
+	conf.ReadHeaderTimeout = 1
+	srv := newHTTPServer(&conf, suite.srv.router)
+	suite.Equal(time.Second, srv.ReadHeaderTimeout)
+	suite.Equal(conf.MaxHeaderBytes, srv.MaxHeaderBytes)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.Nil(err)
+	go srv.Serve(l)
+	defer srv.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	suite.Nil(err)
+	defer conn.Close()
+	start := time.Now()
+	_, err = conn.Write([]byte("GET /rpc?v=5&type=info&arg=attest HTTP/1.1\r\nHost: localhost\r\n"))
+	suite.Nil(err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadAll(conn)
+	suite.Nil(err, "Connection should have been closed by the server")
+	suite.Less(time.Since(start), 3*time.Second)
+
+	suite.srv.wipeRateLimits()
+}
+
+// test graceful shutdown
+func (suite *RpcTestSuite) TestShutdown() {
+	suite.changeSettings(func(c *config.Settings) { c.ShutdownTimeout = 1 })
+	defer suite.srv.draining.Store(false)
+
+	started := make(chan struct{}, 1)
+	start := func(release <-chan struct{}) (*http.Server, string) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		suite.Nil(err)
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			w.Write([]byte("done"))
+		})}
+		go srv.Serve(l)
+		return srv, "http://" + l.Addr().String()
+	}
+	get := func(url string, res chan<- error) {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		res <- err
+	}
+
+	// in-flight requests are drained; we are unready while shutting down
+	release := make(chan struct{})
+	srv, url := start(release)
+	res := make(chan error, 1)
+	go get(url, res)
+	<-started
+	ctx, cancelJobs := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		suite.srv.shutdown(cancelJobs, srv)
+		close(stopped)
+	}()
+	suite.Eventually(func() bool {
+		rr := httptest.NewRecorder()
+		suite.srv.router.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+		return rr.Code == http.StatusServiceUnavailable && strings.Contains(rr.Body.String(), "shutting down")
+	}, time.Second, 10*time.Millisecond)
+	suite.NotNil(ctx.Err(), "Jobs should have been cancelled")
+	close(release)
+	suite.Nil(<-res)
+	<-stopped
+
+	// hanging requests are cut off after ShutdownTimeout
+	srv, url = start(make(chan struct{}))
+	res = make(chan error, 1)
+	go get(url, res)
+	<-started
+	begin := time.Now()
+	suite.srv.shutdown(func() {}, srv)
+	suite.Less(time.Since(begin), 3*time.Second)
+	suite.NotNil(<-res)
+
+	// downloads are aborted when our jobs are cancelled
+	suite.changeSettings(func(c *config.Settings) {
+		c.AurFileLocation = "http://127.0.0.1:10668/test_packages.json?nomod=1"
+		c.LoadFromFile = false
+	})
+	suite.srv.lastRefresh = time.Time{}
+	suite.ErrorIs(suite.srv.reloadData(ctx), context.Canceled)
+}
+
 // test data reload
 func (suite *RpcTestSuite) TestReload() {
 	// since we reload data before each test, this should throw "not modified"
-	err := suite.srv.reloadData()
+	err := suite.srv.reloadData(context.Background())
 	suite.Equal("not modified", err.Error(), "Should be \"not modified\"")
 
 	// should reload data
 	suite.srv.lastRefresh = time.Time{}
-	err = suite.srv.reloadData()
+	err = suite.srv.reloadData(context.Background())
 	suite.Nil(err, "Error reloading data")
 
 	modTime := time.Now().UTC()
 
 	// reload from http
-	suite.srv.conf.AurFileLocation = "http://127.0.0.1:10668/test_packages.json"
-	suite.srv.conf.LoadFromFile = false
+	suite.changeSettings(func(c *config.Settings) {
+		c.AurFileLocation = "http://127.0.0.1:10668/test_packages.json"
+		c.LoadFromFile = false
+	})
 	suite.srv.lastRefresh = modTime.Add(time.Hour * -1)
-	err = suite.srv.reloadData()
+	err = suite.srv.reloadData(context.Background())
 	suite.NotNil(err, "Error should not be nil")
 	suite.Equal("not modified", err.Error(), "Should be \"not modified\"")
 
 	suite.srv.lastRefresh = time.Now().UTC().Add(time.Second * 1)
-	err = suite.srv.reloadData()
+	err = suite.srv.reloadData(context.Background())
 	suite.Nil(err, err)
 
 	// test for servers not providing "Last-Modified" header
-	suite.srv.conf.AurFileLocation = "http://127.0.0.1:10668/test_packages.json?nomod=1"
-	suite.srv.conf.LoadFromFile = false
+	suite.changeSettings(func(c *config.Settings) {
+		c.AurFileLocation = "http://127.0.0.1:10668/test_packages.json?nomod=1"
+		c.LoadFromFile = false
+	})
 	suite.srv.lastRefresh = modTime.Add(time.Hour * -1)
-	err = suite.srv.reloadData()
+	err = suite.srv.reloadData(context.Background())
 	suite.Nil(err, err)
 }
 
 // purposefully crash reload function
 func (suite *RpcTestSuite) TestBrokenReload() {
-	suite.srv.conf.AurFileLocation = "x"
-	suite.NotNil(suite.srv.reloadData(), "Should return an error")
+	suite.changeSettings(func(c *config.Settings) { c.AurFileLocation = "x" })
+	suite.NotNil(suite.srv.reloadData(context.Background()), "Should return an error")
 }
 
 // test stats
@@ -606,6 +2128,99 @@ func (suite *RpcTestSuite) TestStats() {
 	suite.Equal(consts.ContentTypeHtml, rr.Result().Header.Get("Content-Type"))
 }
 
+// test health and readiness endpoints
+func (suite *RpcTestSuite) TestHealth() {
+	request := func(url string) (int, HealthStatus) {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", url, nil)
+		suite.Nil(err, "Could not create GET request")
+		suite.srv.router.ServeHTTP(rr, req)
+		suite.Equal(consts.ContentTypeJson, rr.Result().Header.Get("Content-Type"))
+		var h HealthStatus
+		suite.Nil(json.Unmarshal(rr.Body.Bytes(), &h))
+		return rr.Code, h
+	}
+
+	code, h := request("/healthz")
+	suite.Equal(http.StatusOK, code)
+	suite.Equal("ok", h.Status)
+	code, h = request("/readyz")
+	suite.Equal(http.StatusOK, code)
+	suite.True(h.Ready)
+	suite.False(h.Stale)
+	suite.NotNil(h.LastReload)
+	suite.Equal(0, suite.srv.dataStale())
+
+	// stale data
+	suite.changeSettings(func(c *config.Settings) { c.DataStaleThreshold = 60 })
+	suite.srv.mut.Lock()
+	lastRefresh := suite.srv.lastRefresh
+	suite.srv.lastRefresh = time.Now().Add(-time.Hour)
+	suite.srv.mut.Unlock()
+	code, h = request("/readyz")
+	suite.Equal(http.StatusServiceUnavailable, code)
+	suite.Equal("unavailable", h.Status)
+	suite.Equal("package data is stale", h.Reason)
+	suite.True(h.Stale)
+	suite.GreaterOrEqual(h.DataAge, int64(3600))
+	suite.Equal(1, suite.srv.dataStale())
+	code, h = request("/healthz")
+	suite.Equal(http.StatusOK, code)
+	suite.True(h.Stale)
+
+	// no data loaded yet
+	suite.srv.mut.Lock()
+	memDB := suite.srv.memDB
+	suite.srv.memDB = nil
+	suite.srv.lastRefresh = lastRefresh
+	suite.srv.mut.Unlock()
+	code, h = request("/readyz")
+	suite.Equal(http.StatusServiceUnavailable, code)
+	suite.Equal("package data has not been loaded yet", h.Reason)
+	suite.Equal(1, suite.srv.dataStale())
+
+	suite.srv.mut.Lock()
+	suite.srv.memDB = memDB
+	suite.srv.mut.Unlock()
+}
+
+// test our metrics endpoint
+func (suite *RpcTestSuite) TestMetrics() {
+	request := func(url string) string {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", url, nil)
+		suite.Nil(err, "Could not create GET request")
+		req.RemoteAddr = "192.168.1.12:1234"
+		suite.srv.router.ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+
+	suite.srv.wipeSearchCache()
+	request("/rpc?v=5&type=info&arg=attest")
+	request("/rpc/v5/search/attest?by=name")
+	request("/rpc?v=5&type=nonsense&arg=attest")
+
+	body := request("/metrics")
+	for _, metric := range []string{
+		`goaurrpc_build_info{goversion="` + runtime.Version() + `",version=""} 1`,
+		`rpc_data_packages 666`,
+		`rpc_cache_entries 1`,
+		`rpc_rate_limit_entries 1`,
+		`rpc_data_stale 0`,
+		`rpc_request_duration_seconds_count{by="name",type="info",version="5"}`,
+		`rpc_request_duration_seconds_count{by="name",type="search",version="5"}`,
+		`rpc_request_duration_seconds_count{by="",type="",version=""}`,
+		`rpc_data_reloads{result="ok"}`,
+		`rpc_data_reload_duration_seconds_count`,
+		`go_goroutines`,
+	} {
+		suite.Contains(body, metric)
+	}
+
+	suite.srv.wipeRateLimits()
+	suite.srv.wipeSearchCache()
+}
+
 // run our tests
 func TestRPCTestSuite(t *testing.T) {
 	suite.Run(t, new(RpcTestSuite))
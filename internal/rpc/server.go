@@ -4,7 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -12,6 +13,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/moson-mo/goaurrpc/internal/config"
@@ -19,11 +22,14 @@ import (
 	"github.com/moson-mo/goaurrpc/internal/doc"
 	db "github.com/moson-mo/goaurrpc/internal/memdb"
 	"github.com/moson-mo/goaurrpc/internal/metrics"
+	"github.com/moson-mo/goaurrpc/internal/tracing"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/goccy/go-json"
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/guregu/null.v4"
 )
 
@@ -33,85 +39,259 @@ type server struct {
 	mut         sync.RWMutex
 	mutLimit    sync.RWMutex
 	mutCache    sync.RWMutex
-	conf        config.Settings
+	mutKeys     sync.RWMutex
+	mutReloads  sync.Mutex
+	mutConf     sync.Mutex
+	mutPersist  sync.Mutex
+	settings    atomic.Value // *config.Settings
+	confChanged chan struct{}
+	pendingConf *config.Settings // settings of the last config reload; holds restart-only values not applied yet
+	configFile  string
+	configFlags *config.Flags
 	stop        chan os.Signal
 	rateLimits  map[string]RateLimit
-	searchCache map[string]CacheEntry
-	verbose     bool
-	veryVerbose bool
+	searchCache map[string]*CacheEntry
+	apiKeys     map[string]ApiKey
+	keyLimits   map[string]RateLimit
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+	reloads     []ReloadResult
+	reloading   int32
+	draining    atomic.Bool // set when we are shutting down; /readyz fails
+	recorder    *recorder
+	metrics     *metrics.Registry
+	logFile     *logFile
+	accessLog   *logFile
+	log         loggers
+	stopTracing func(context.Context) error
+	started     time.Time
 	ver         string
 	lastRefresh time.Time
 	router      chi.Router
+	adminRouter chi.Router    // nil if admin routes are served by router
+	certs       *certReloader // nil without EnableSSL
 }
 
 // New creates a new server and immediately loads package data into memory
-func New(settings config.Settings, verbose, vverbose bool, version string) (*server, error) {
+func New(settings config.Settings, version string) (*server, error) {
 	s := server{
 		rateLimits:  make(map[string]RateLimit),
-		searchCache: make(map[string]CacheEntry),
+		searchCache: make(map[string]*CacheEntry),
+		apiKeys:     make(map[string]ApiKey),
+		keyLimits:   make(map[string]RateLimit),
 		stop:        make(chan os.Signal, 1),
-		verbose:     verbose,
-		veryVerbose: vverbose,
 		ver:         version,
+		started:     time.Now(),
+		recorder:    newRecorder(),
+		logFile:     &logFile{},
+		accessLog:   &logFile{},
 	}
 
 	// prep logging
+	var logOut io.Writer = os.Stdout
 	if settings.LogFile != "" {
-		f, err := os.OpenFile(settings.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err := s.logFile.open(settings.LogFile); err != nil {
+			return nil, err
+		}
+		logOut = logWriter{s: &s, file: s.logFile, path: settings.LogFile}
+	}
+	s.log = newLoggers(logOut, settings.LogFormat, confLevel{&s})
+	// our loggers read their level from the settings
+	s.storeSettings(settings)
+
+	// metrics are collected even if they are not exposed
+	s.metrics = metrics.NewRegistry(version, metrics.State{
+		CacheEntries:     s.cacheEntries,
+		RateLimitEntries: s.rateLimitEntries,
+		DataStale:        s.dataStale,
+	})
+
+	// prep tracing
+	if settings.EnableTracing {
+		stop, err := tracing.Setup(settings.TracingEndpoint, settings.TracingSampleRate, version)
 		if err != nil {
 			return nil, err
 		}
-		log.SetOutput(f)
+		s.stopTracing = stop
+		s.log.main.Info("Tracing enabled", "endpoint", settings.TracingEndpoint, "sample_rate", settings.TracingSampleRate)
 	}
-	log.SetFlags(log.LstdFlags | log.Lmsgprefix)
-	log.SetPrefix("| ")
 
-	signal.Notify(s.stop, os.Interrupt)
+	signal.Notify(s.stop, os.Interrupt, syscall.SIGTERM)
 
-	s.conf = settings
+	if settings.RateLimit == 0 {
+		s.log.ratelimit.Warn("Rate limiting is disabled", "setting", "RateLimit")
+	}
+
+	// load client API keys
+	if settings.EnableApiKeys {
+		keys, err := loadApiKeys(settings.ApiKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		s.apiKeys = keys
+		s.log.ratelimit.Info("Loaded API keys", "count", len(keys))
+	}
 
 	// load data
-	s.Log("Loading package data...")
+	s.log.refresh.Info("Loading package data")
 	start := time.Now()
-	err := s.reloadData()
+	err := s.reloadData(context.Background())
 	if err != nil {
 		return nil, err
 	}
-	s.Log("Loaded package data in", time.Since(start).Milliseconds(), "ms.")
-	s.Log("Server started. Ready for client connections...")
+	s.log.refresh.Info("Loaded package data", "duration_ms", time.Since(start).Milliseconds())
+	s.log.main.Info("Server started. Ready for client connections", "version", s.ver)
 	return &s, nil
 }
 
-// Listen creates a rest API endpoint and starts listening for requests
+// SetConfigSource sets the file and command line flags our settings have been loaded from.
+// They are used when our config is reloaded or settings are persisted via the admin API
+func (s *server) SetConfigSource(path string, flags *config.Flags) {
+	s.configFile = path
+	s.configFlags = flags
+	s.mutConf.Lock()
+	s.pendingConf = nil
+	s.mutConf.Unlock()
+}
+
+// Listen creates a rest API endpoint and starts listening for requests.
+// On SIGINT / SIGTERM (or Stop) it drains in-flight requests and returns http.ErrServerClosed once we have shut down
 func (s *server) Listen() error {
+	conf := s.conf()
+	listeners, adminListeners, err := s.listeners(conf)
+	if err != nil {
+		return err
+	}
+	tlsConfig, redirectListener, err := s.setupTLS(conf)
+	if err != nil {
+		closeAll(listeners)
+		closeAll(adminListeners)
+		return err
+	}
+
 	wg := sync.WaitGroup{}
-	shutdown := make(chan struct{})
+	ctx, cancelJobs := context.WithCancel(context.Background())
 	// start period tasks
-	s.startJobs(shutdown, &wg)
+	s.startJobs(ctx, &wg)
 
 	// set up router
-	s.setupRoutes()
-
-	srv := http.Server{
-		Addr:    ":" + strconv.Itoa(s.conf.Port),
-		Handler: s.router,
+	s.setupRoutes(len(adminListeners) > 0)
+
+	srv := newHTTPServer(conf, s.router)
+	srv.TLSConfig = tlsConfig
+	servers := []*http.Server{srv}
+	var adminSrv, redirectSrv *http.Server
+	if s.adminRouter != nil {
+		adminSrv = newHTTPServer(conf, s.adminRouter)
+		adminSrv.TLSConfig = tlsConfig
+		servers = append(servers, adminSrv)
+	}
+	if redirectListener != nil {
+		if port, ok := httpsPort(listeners); ok {
+			redirectSrv = newHTTPServer(conf, redirectToHTTPS(port))
+			servers = append(servers, redirectSrv)
+		} else {
+			// can happen with sockets passed by systemd
+			s.log.main.Warn("Not redirecting plain HTTP requests to HTTPS: There is no TCP listener", "setting", "HTTPRedirectPort")
+			redirectListener.Close()
+			redirectListener = nil
+		}
 	}
 
-	// shut down if we get the interrupt signal
+	// shut down if we get the interrupt / terminate signal
+	done := make(chan struct{})
 	go func() {
-		<-s.stop
-		s.Log("Server is shutting down...")
-		close(shutdown)
-
+		defer close(done)
+		sig := <-s.stop
+		s.log.main.Info("Server is shutting down", "signal", sig.String())
+		s.shutdown(cancelJobs, servers...)
 		wg.Wait()
-		srv.Shutdown(context.Background())
+
+		s.recorder.close()
+		s.accessLog.close()
+		if s.stopTracing != nil {
+			if err := s.stopTracing(context.Background()); err != nil {
+				s.log.main.Error("Error flushing traces", "error", err)
+			}
+		}
+		s.logFile.close()
 	}()
 
 	// Listen for requests
-	if s.conf.EnableSSL {
-		return srv.ListenAndServeTLS(s.conf.CertFile, s.conf.KeyFile)
+	errs := make(chan error, len(listeners)+len(adminListeners)+1)
+	serve := func(srv *http.Server, l net.Listener, useTLS bool) {
+		if useTLS {
+			errs <- srv.ServeTLS(l, "", "") // our certificate is provided by TLSConfig
+		} else {
+			errs <- srv.Serve(l)
+		}
+	}
+	for _, l := range listeners {
+		s.log.main.Info("Listening for requests", "address", l.Addr().String(), "tls", conf.EnableSSL)
+		go serve(srv, l, conf.EnableSSL)
+	}
+	for _, l := range adminListeners {
+		s.log.main.Info("Listening for admin requests", "address", l.Addr().String(), "tls", conf.EnableSSL)
+		go serve(adminSrv, l, conf.EnableSSL)
+	}
+	if redirectListener != nil {
+		s.log.main.Info("Redirecting plain HTTP requests to HTTPS", "address", redirectListener.Addr().String())
+		go serve(redirectSrv, redirectListener, false)
 	}
-	return srv.ListenAndServe()
+	s.notify("READY=1")
+
+	// if one of our listeners fails, we shut down
+	err = <-errs
+	if err != http.ErrServerClosed {
+		s.Stop()
+	}
+	<-done
+	return err
+}
+
+// returns a http server with our timeouts and limits
+func newHTTPServer(conf *config.Settings, handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(conf.ReadHeaderTimeout) * time.Second,
+		ReadTimeout:       time.Duration(conf.ReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(conf.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(conf.IdleTimeout) * time.Second,
+		MaxHeaderBytes:    conf.MaxHeaderBytes,
+	}
+}
+
+// shuts down gracefully: we report to be unready, wait ShutdownDelay seconds so load balancers
+// can take us out of rotation and drain in-flight requests of our servers for up to ShutdownTimeout seconds
+func (s *server) shutdown(cancelJobs context.CancelFunc, servers ...*http.Server) {
+	conf := s.conf()
+	s.draining.Store(true)
+	s.notify("STOPPING=1")
+	cancelJobs()
+
+	if conf.ShutdownDelay > 0 {
+		s.log.main.Info("Waiting before draining requests", "delay_s", conf.ShutdownDelay)
+		time.Sleep(time.Duration(conf.ShutdownDelay) * time.Second)
+	}
+
+	ctx := context.Background()
+	if conf.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(conf.ShutdownTimeout)*time.Second)
+		defer cancel()
+	}
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				s.log.main.Warn("Could not drain all requests in time. Closing remaining connections", "error", err)
+				srv.Close()
+			}
+		}(srv)
+	}
+	wg.Wait()
 }
 
 // Stop stops the server
@@ -119,89 +299,125 @@ func (s *server) Stop() {
 	s.stop <- os.Interrupt
 }
 
-// set up our routes
-func (s *server) setupRoutes() {
+// set up our routes. With a separate admin listener,
+// admin, metrics, pprof and health check routes are served by adminRouter instead of router
+func (s *server) setupRoutes(separateAdmin bool) {
 	// routes
 	s.router = chi.NewRouter()
+	handle := s.traceMiddleware(s.accessMiddleware(s.captureMiddleware(s.handleRequest)))
 
-	s.router.HandleFunc("/rpc", s.handleRequest)
-	s.router.HandleFunc("/rpc/", s.handleRequest)
-	s.router.HandleFunc("/rpc.php", s.handleRequest)  // deprecated
-	s.router.HandleFunc("/rpc.php/", s.handleRequest) // deprecated
-	s.router.HandleFunc("/rpc/stats", s.handleStats)
+	s.router.HandleFunc("/rpc", handle)
+	s.router.HandleFunc("/rpc/", handle)
+	s.router.HandleFunc("/rpc.php", handle)  // deprecated
+	s.router.HandleFunc("/rpc.php/", handle) // deprecated
+	s.router.HandleFunc("/rpc/stats", s.traceMiddleware(s.accessMiddleware(s.handleStats)))
 
 	// v5 with url paths
-	s.router.HandleFunc("/rpc/v{version}/{type}/{arg}", s.handleRequest)
-	s.router.HandleFunc("/rpc/v{version}/{type}", s.handleRequest)
+	s.router.HandleFunc("/rpc/v{version}/{type}/{arg}", handle)
+	s.router.HandleFunc("/rpc/v{version}/{type}", handle)
 
 	// v6
-	s.router.HandleFunc("/api", s.handleRequest)
-	s.router.HandleFunc("/api/", s.handleRequest)
-	s.router.HandleFunc("/api/v{version}/{type}/{by}/{mode}/{arg}", s.handleRequest)
-	s.router.HandleFunc("/api/v{version}/{type}/{by}/{arg}", s.handleRequest)
-	s.router.HandleFunc("/api/v{version}/{type}/{arg}", s.handleRequest)
-	s.router.HandleFunc("/api/v{version}/{type}", s.handleRequest)
-
-	// metrics
-	if s.conf.EnableMetrics {
-		metrics.RegisterMetrics()
-		s.router.Handle("/metrics", promhttp.Handler())
-	}
-
-	// admin api
-	if s.conf.EnableAdminApi {
-		s.router.Handle("/admin/run-job/{name}", s.adminMiddleware(s.handleAdminJobs))
-		s.router.Handle("/admin/settings/{name}", s.adminMiddleware(s.handleAdminSettings))
-		s.router.Handle("/admin/settings", s.adminMiddleware(s.handleAdminSettings))
-		s.router.Handle("/admin/settings/", s.adminMiddleware(s.handleAdminSettings))
-	}
+	s.router.HandleFunc("/api", handle)
+	s.router.HandleFunc("/api/", handle)
+	s.router.HandleFunc("/api/v{version}/{type}/{by}/{mode}/{arg}", handle)
+	s.router.HandleFunc("/api/v{version}/{type}/{by}/{arg}", handle)
+	s.router.HandleFunc("/api/v{version}/{type}/{arg}", handle)
+	s.router.HandleFunc("/api/v{version}/{type}", handle)
 
 	// swagger
 	s.router.HandleFunc("/rpc/swagger", doc.SwaggerRpcHandler)
 	s.router.HandleFunc("/rpc/swagger/", doc.SwaggerRpcHandler)
 	s.router.HandleFunc("/api/swagger", doc.SwaggerApiHandler)
 	s.router.HandleFunc("/api/swagger/", doc.SwaggerApiHandler)
-	if s.conf.EnableAdminApi {
-		s.router.HandleFunc("/admin/swagger", doc.SwaggerAdminHandler)
-		s.router.HandleFunc("/admin/swagger/", doc.SwaggerAdminHandler)
-	}
 	s.router.HandleFunc("/rpc/openapi.json", doc.SpecRpcHandler)
 	s.router.HandleFunc("/api/openapi.json", doc.SpecApiHandler)
-	s.router.HandleFunc("/admin/openapi.json", doc.SpecAdminHandler)
 	s.router.HandleFunc("/rpc/olddoc.html", doc.SpecOldHandler)
+
+	admin := s.router
+	s.adminRouter = nil
+	if separateAdmin {
+		s.adminRouter = chi.NewRouter()
+		admin = s.adminRouter
+
+		// pprof is never exposed on our public listener
+		if s.conf().EnablePprof {
+			admin.Mount("/debug", middleware.Profiler())
+		}
+	}
+
+	// health checks
+	admin.HandleFunc("/healthz", s.handleHealthz)
+	admin.HandleFunc("/readyz", s.handleReadyz)
+
+	// metrics
+	if s.conf().EnableMetrics {
+		admin.Handle("/metrics", promhttp.HandlerFor(s.metrics, promhttp.HandlerOpts{}))
+	}
+
+	// admin api
+	if s.conf().EnableAdminApi {
+		admin.Handle("/admin/run-job/{name}", s.adminMiddleware(s.handleAdminJobs))
+		admin.Handle("/admin/settings/{name}", s.adminMiddleware(s.handleAdminSettings))
+		admin.Handle("/admin/settings", s.adminMiddleware(s.handleAdminSettings))
+		admin.Handle("/admin/settings/", s.adminMiddleware(s.handleAdminSettings))
+		admin.Handle("/admin/ratelimits", s.adminMiddleware(s.handleAdminRateLimits))
+		admin.Handle("/admin/ratelimits/{ip}", s.adminMiddleware(s.handleAdminRateLimits))
+		admin.Handle("/admin/cache", s.adminMiddleware(s.handleAdminCache))
+		admin.Handle("/admin/cache/{key}", s.adminMiddleware(s.handleAdminCache))
+		admin.Handle("/admin/stats", s.adminMiddleware(s.handleAdminStats))
+		if s.conf().EnableApiKeys {
+			admin.Handle("/admin/apikeys", s.adminMiddleware(s.handleAdminApiKeys))
+			admin.Handle("/admin/apikeys/{hash}", s.adminMiddleware(s.handleAdminApiKeys))
+		}
+		admin.HandleFunc("/admin/swagger", doc.SwaggerAdminHandler)
+		admin.HandleFunc("/admin/swagger/", doc.SwaggerAdminHandler)
+	}
+	admin.HandleFunc("/admin/openapi.json", doc.SpecAdminHandler)
 }
 
 // handles client connections
 func (s *server) handleRequest(w http.ResponseWriter, r *http.Request) {
-	// response time metrics
-	timer := prometheus.NewTimer(metrics.HttpDuration.WithLabelValues())
-	defer timer.ObserveDuration()
+	// response time metrics; labels are set once our parameters have been validated
+	start := time.Now()
+	labels := []string{"", "", ""}
+	defer func() {
+		s.metrics.HttpDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	}()
+
+	// settings snapshot for this request
+	conf := s.conf()
+	ctx := r.Context()
 
 	// get clients IP address
-	ip := getRealIP(r, s.conf.TrustedReverseProxies)
-	s.LogVeryVerbose("Client connected:", ip, "->", "["+r.Method+"]", r.URL)
+	ip := getRealIP(r, conf.TrustedReverseProxies)
+	info := requestInfo(r)
 
 	// get API parameters
-	params := s.composeParameters(r)
+	params, paramsErr := s.composeParameters(w, r, conf)
 
 	rtype := params.Get("type")
 	by := getBy(params)
 	version := params.Get("v")
 	verInt, _ := strconv.Atoi(version)
 	callback := params.Get("callback")
-	mode := params.Get("mode")
 	arg := getArg(params)
-	args := getArgsList(params)
 	isV6 := verInt == 6
+	apiKey := getClientApiKey(r, params)
 	cacheKey := params.Encode()
 
-	// rate limit check
-	if s.isRateLimited(ip) {
-		// update rate limited metric
-		metrics.RateLimited.Inc()
+	if paramsErr != nil {
+		s.writeError(http.StatusRequestEntityTooLarge, paramsErr.Error(), verInt, "", w)
+		return
+	}
 
-		s.LogVerbose("Client reached rate limit:", ip, "-", "User-Agent:", r.UserAgent())
-		writeError(429, "Rate limit reached", verInt, "", w)
+	// API key and rate limit check
+	_, span := tracer.Start(ctx, "ratelimit")
+	outcome, code, err := s.checkLimits(r, conf, ip, apiKey)
+	span.SetAttributes(attribute.String("ratelimit.outcome", outcome))
+	span.End()
+	info.rateLimit = outcome
+	if err != nil {
+		s.writeError(code, err.Error(), verInt, "", w)
 		return
 	}
 
@@ -212,31 +428,45 @@ func (s *server) handleRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// validate our parameters
-	err := validateParameters(params)
+	_, span = tracer.Start(ctx, "validate")
+	err = validateParameters(params)
+	if err == nil {
+		err = validateLimits(params, conf.MaxArgs, conf.MaxArgLength)
+	}
+	if err != nil {
+		tracing.Error(span, err)
+	}
+	span.End()
 	errCode := 200
 	if isV6 {
 		errCode = 400
 	}
 	if err != nil {
 		if errors.Is(err, ErrCallBack) {
-			writeError(errCode, err.Error(), verInt, "", w)
+			s.writeError(errCode, err.Error(), verInt, "", w)
 			return
 		}
-		writeError(errCode, err.Error(), verInt, callback, w)
+		s.writeError(errCode, err.Error(), verInt, callback, w)
 		return
 	}
 
+	info.kind = rtype
+	labels = []string{rtype, by, version}
+
 	// update requests metric
-	metrics.Requests.WithLabelValues(r.Method, rtype, by).Inc()
+	s.metrics.Requests.WithLabelValues(r.Method, rtype, by).Inc()
 
 	// handle suggest calls
 	if strings.Contains(rtype, "suggest") {
 		isOpenSearch := strings.HasPrefix(rtype, "opensearch")
 		isBaseSearch := strings.HasSuffix(rtype, "pkgbase")
 
+		_, span = tracer.Start(ctx, "suggest")
 		s.mut.RLock()
 		results := s.getSuggestResult(arg, isBaseSearch)
 		s.mut.RUnlock()
+		span.SetAttributes(attribute.Int("results", len(results)))
+		span.End()
 
 		var content any
 		var contentType string
@@ -249,7 +479,9 @@ func (s *server) handleRequest(w http.ResponseWriter, r *http.Request) {
 			contentType = consts.ContentTypeJson
 		}
 
+		_, span = tracer.Start(ctx, "serialize")
 		b, err := json.Marshal(content)
+		span.End()
 
 		if err != nil {
 			w.WriteHeader(500)
@@ -257,51 +489,77 @@ func (s *server) handleRequest(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		_, span = tracer.Start(ctx, "write")
 		w.Header().Set("Content-Type", contentType)
 		w.Write(b)
+		span.End()
 		return
 	}
 
 	// handle info / search calls
+	result, cache := s.runQuery(ctx, params, cacheKey)
+	info.cache = cache.String()
+
+	// set version number
+	result.Version = null.NewInt(int64(verInt), verInt != 0)
+
+	// return JSON to client
+	size := s.writeResult(ctx, &result, callback, w)
+
+	// add to search cache
+	if cache == cacheStore {
+		s.addToCache(result, cacheKey, size)
+	}
+}
+
+// runs an info or search query with validated parameters.
+// Returns the result and the outcome of the search-cache lookup
+func (s *server) runQuery(ctx context.Context, params url.Values, cacheKey string) (RpcResult, cacheOutcome) {
+	rtype := params.Get("type")
+	by := getBy(params)
+	mode := params.Get("mode")
+	arg := getArg(params)
+	args := getArgsList(params)
+	isV6 := params.Get("v") == "6"
+
 	result := RpcResult{}
-	cache := false
+	cache := cacheNone
 	s.mut.RLock()
 	switch rtype {
 	case "info", "multiinfo":
+		_, span := tracer.Start(ctx, "info", trace.WithAttributes(attribute.Int("args", len(args))))
 		result = s.getInfoResult(by, args, isV6)
+		span.End()
 	case "search", "msearch":
-		result, cache = s.getSearchResult(rtype, by, mode, arg, cacheKey, isV6)
+		result, cache = s.getSearchResult(ctx, rtype, by, mode, arg, cacheKey, isV6)
 	}
 	s.mut.RUnlock()
 
 	// don't return data if we exceed max number of results
-	if result.Resultcount > s.conf.MaxResults {
+	if result.Resultcount > s.conf().MaxResults {
 		result.Error = "Too many package results."
 		result.Resultcount = 0
 		result.Results = nil
 		result.Type = "error"
 	}
-
-	// add to search cache
-	if cache {
-		s.addToCache(result, params.Encode())
-	}
-
-	// set version number
-	result.Version = null.NewInt(int64(verInt), verInt != 0)
-
-	// return JSON to client
-	writeResult(&result, callback, w)
+	return result, cache
 }
 
 // get API parameters from url query/form or path
-func (s *server) composeParameters(r *http.Request) url.Values {
+func (s *server) composeParameters(w http.ResponseWriter, r *http.Request, conf *config.Settings) (url.Values, error) {
 	// check if we got a GET or POST request
 	var params url.Values
+	var err error
 	if r.Method == "GET" {
 		params = r.URL.Query()
 	} else {
-		r.ParseForm()
+		if conf.MaxBodySize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, int64(conf.MaxBodySize))
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(r.ParseForm(), &maxBytesErr) {
+			err = ErrBodyTooLarge
+		}
 		params = r.PostForm
 	}
 
@@ -328,7 +586,37 @@ func (s *server) composeParameters(r *http.Request) url.Values {
 		params.Set("mode", mp)
 	}
 
-	return params
+	return params, err
+}
+
+// checks the API key / rate limit of a client; clients with a valid key are not subject to IP based rate limits.
+// Returns the rate-limit outcome and, if the request must be rejected, a status code and error
+func (s *server) checkLimits(r *http.Request, conf *config.Settings, ip, apiKey string) (string, int, error) {
+	if apiKey != "" && conf.EnableApiKeys {
+		label, err := s.checkApiKey(apiKey, r.URL.Path)
+		switch err {
+		case nil:
+			s.metrics.ApiKeyRequests.WithLabelValues(label).Inc()
+			return rateLimitKey, 0, nil
+		case ErrQuotaReached:
+			s.metrics.RateLimited.Inc()
+			s.log.ratelimit.Debug("API key reached quota", "key", label, "client", ip)
+			return rateLimitKeyQuota, 429, err
+		case ErrEndpointNotAllowed:
+			return rateLimitKeyForbidden, 403, err
+		default:
+			s.log.ratelimit.Debug("Client provided invalid API key", "client", ip)
+			return rateLimitKeyInvalid, 401, err
+		}
+	}
+	if s.isRateLimited(ip) {
+		// update rate limited metric
+		s.metrics.RateLimited.Inc()
+
+		s.log.ratelimit.Debug("Client reached rate limit", "client", ip, "user_agent", r.UserAgent())
+		return rateLimitLimited, 429, errors.New("Rate limit reached")
+	}
+	return rateLimitOk, 0, nil
 }
 
 // check if rate limit is reached. Create / update the record.
@@ -336,20 +624,23 @@ func (s *server) isRateLimited(ip string) bool {
 	s.mutLimit.Lock()
 	defer s.mutLimit.Unlock()
 
-	// RateLimit of 0 -> Skip check
-	if s.conf.RateLimit == 0 {
-		return false
-	}
-
+	limit := s.conf().RateLimit
 	la, ok := s.rateLimits[ip]
 	if ok {
+		// bans and custom limits apply even if RateLimit is 0
 		la.Requests++
 		s.rateLimits[ip] = la
-		if la.Requests > s.conf.RateLimit {
+		if la.Banned {
 			return true
 		}
-	} else {
-		s.LogVeryVerbose("Rate limit added:", ip)
+		if la.Limit != 0 {
+			limit = la.Limit
+		}
+		if limit != 0 && la.Requests > limit {
+			return true
+		}
+	} else if limit != 0 { // RateLimit of 0 -> no need to track clients
+		logTrace(s.log.ratelimit, "Rate limit added", "client", ip)
 		s.rateLimits[ip] = RateLimit{
 			Requests:    1,
 			WindowStart: time.Now(),
@@ -359,11 +650,11 @@ func (s *server) isRateLimited(ip string) bool {
 }
 
 // add search results to cache.
-func (s *server) addToCache(result RpcResult, key string) {
-	if !s.conf.EnableSearchCache {
+func (s *server) addToCache(result RpcResult, key string, size int) {
+	if !s.conf().EnableSearchCache {
 		return
 	}
 	s.mutCache.Lock()
 	defer s.mutCache.Unlock()
-	s.searchCache[key] = CacheEntry{Result: result, TimeAdded: time.Now()}
+	s.searchCache[key] = &CacheEntry{Result: result, TimeAdded: time.Now(), Size: size}
 }
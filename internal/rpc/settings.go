@@ -0,0 +1,258 @@
+package rpc
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"strconv"
+
+	"github.com/moson-mo/goaurrpc/internal/admin"
+	"github.com/moson-mo/goaurrpc/internal/config"
+)
+
+/*
+	Settings are kept in an immutable snapshot which is swapped atomically.
+	Readers obtain the current snapshot with conf() and must never modify it.
+	Changes are made on a copy via changeSettings.
+*/
+
+// conf returns the current settings snapshot
+func (s *server) conf() *config.Settings {
+	return s.settings.Load().(*config.Settings)
+}
+
+// stores a new settings snapshot and notifies everyone waiting for changes
+func (s *server) storeSettings(settings config.Settings) {
+	s.mutConf.Lock()
+	defer s.mutConf.Unlock()
+	s.settings.Store(&settings)
+	if s.confChanged != nil {
+		close(s.confChanged)
+	}
+	s.confChanged = make(chan struct{})
+}
+
+// changeSettings applies fn to a copy of the current settings,
+// validates the result and swaps the snapshot. Nothing is changed if fn returns an error
+func (s *server) changeSettings(fn func(*config.Settings) error) error {
+	s.mutConf.Lock()
+	defer s.mutConf.Unlock()
+
+	settings := *s.conf()
+	// the slices are shared with the current snapshot; don't let fn modify them in place
+	settings.TrustedReverseProxies = append([]string{}, settings.TrustedReverseProxies...)
+	settings.ListenAddresses = slices.Clone(settings.ListenAddresses)
+	settings.AdminListenAddresses = slices.Clone(settings.AdminListenAddresses)
+	settings.TLSCipherSuites = slices.Clone(settings.TLSCipherSuites)
+	if err := fn(&settings); err != nil {
+		return err
+	}
+
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	s.settings.Store(&settings)
+	close(s.confChanged)
+	s.confChanged = make(chan struct{})
+	return nil
+}
+
+// settingsChanged returns a channel that is closed as soon as the settings change
+func (s *server) settingsChanged() <-chan struct{} {
+	s.mutConf.Lock()
+	defer s.mutConf.Unlock()
+	return s.confChanged
+}
+
+// writes the current settings back to our config file.
+// Returns the settings that were skipped because they are overridden by environment variables or flags
+func (s *server) persistSettings() ([]string, error) {
+	s.mutPersist.Lock()
+	defer s.mutPersist.Unlock()
+
+	overridden := config.Overridden(s.configFlags)
+	if err := s.persistedSettings().SaveToFile(s.configFile, overridden...); err != nil {
+		return nil, err
+	}
+	s.log.admin.Info("Persisted settings", "file", s.configFile)
+	return overridden, nil
+}
+
+// returns the settings to be written to our config file.
+// Settings that need a restart are taken from the last config reload;
+// otherwise we'd overwrite changes that have not been applied yet
+func (s *server) persistedSettings() *config.Settings {
+	s.mutConf.Lock()
+	defer s.mutConf.Unlock()
+
+	settings := *s.conf()
+	if s.pendingConf == nil {
+		return &settings
+	}
+	cur := reflect.ValueOf(&settings).Elem()
+	pending := reflect.ValueOf(s.pendingConf).Elem()
+	for i := 0; i < cur.NumField(); i++ {
+		if restartSettings[cur.Type().Field(i).Name] {
+			cur.Field(i).Set(pending.Field(i))
+		}
+	}
+	return &settings
+}
+
+// ErrNoConfigFile is returned when our settings have not been loaded from a file
+var ErrNoConfigFile = errors.New("No config file specified")
+
+// settings that are only applied on startup
+var restartSettings = map[string]bool{
+	"Port":                 true,
+	"EnableSSL":            true,
+	"CertFile":             true,
+	"KeyFile":              true,
+	"LogFile":              true,
+	"LogFormat":            true,
+	"EnableTracing":        true,
+	"TracingEndpoint":      true,
+	"TracingSampleRate":    true,
+	"EnableMetrics":        true,
+	"EnableAdminApi":       true,
+	"EnableApiKeys":        true,
+	"ApiKeyFile":           true,
+	"ReadHeaderTimeout":    true,
+	"ReadTimeout":          true,
+	"WriteTimeout":         true,
+	"IdleTimeout":          true,
+	"MaxHeaderBytes":       true,
+	"ListenAddresses":      true,
+	"SocketMode":           true,
+	"SocketOwner":          true,
+	"AdminListenAddresses": true,
+	"EnablePprof":          true,
+	"TLSMinVersion":        true,
+	"TLSCipherSuites":      true,
+	"AdminClientCAFile":    true,
+	"HTTPRedirectPort":     true,
+}
+
+// re-reads our config file (plus environment variables and flags) and applies all settings that can be changed at runtime.
+// Returns the changed settings and the ones that need a restart to take effect
+func (s *server) reloadConfig() (changes []admin.SettingChange, restart []string, err error) {
+	if s.configFile == "" {
+		return nil, nil, ErrNoConfigFile
+	}
+
+	fileConf, err := config.Load(s.configFile, s.configFlags)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.changeSettings(func(c *config.Settings) error {
+		changes, restart = nil, nil
+		cur := reflect.ValueOf(c).Elem()
+		loaded := reflect.ValueOf(fileConf).Elem()
+		for i := 0; i < cur.NumField(); i++ {
+			field := cur.Type().Field(i).Name
+			if reflect.DeepEqual(cur.Field(i).Interface(), loaded.Field(i).Interface()) {
+				continue
+			}
+			if restartSettings[field] {
+				restart = append(restart, field)
+				continue
+			}
+			changes = append(changes, admin.SettingChange{Setting: field, Old: cur.Field(i).Interface(), New: loaded.Field(i).Interface()})
+			cur.Field(i).Set(loaded.Field(i))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	s.mutConf.Lock()
+	s.pendingConf = fileConf
+	s.mutConf.Unlock()
+
+	for _, change := range changes {
+		s.log.refresh.Info("Config reload: Setting changed", "setting", change.Setting, "old", change.Old, "new", change.New)
+	}
+	for _, field := range restart {
+		s.log.refresh.Warn("Config reload: Setting has been changed. Restart required to apply it", "setting", field)
+	}
+	if len(changes) == 0 && len(restart) == 0 {
+		s.log.refresh.Debug("Config reload: No settings changed")
+	}
+	return changes, restart, nil
+}
+
+// runtimeSetting describes a setting that can be changed at runtime via the admin API
+type runtimeSetting struct {
+	option string // name used in the admin API (/admin/settings/{option})
+	field  string // name of the field in config.Settings
+	get    func(c *config.Settings) any
+	set    func(c *config.Settings, value string) error
+}
+
+// settings that can be changed at runtime
+var runtimeSettings = newRuntimeSettings(admin.Options)
+
+// finds a runtime setting by its option or field name
+func findRuntimeSetting(name string) (runtimeSetting, bool) {
+	for _, rs := range runtimeSettings {
+		if rs.option == name || rs.field == name {
+			return rs, true
+		}
+	}
+	return runtimeSetting{}, false
+}
+
+// creates the runtime settings for our options; their fields are accessed via reflection
+func newRuntimeSettings(options []admin.Option) []runtimeSetting {
+	settings := make([]runtimeSetting, 0, len(options))
+	for _, o := range options {
+		sf, ok := reflect.TypeOf(config.Settings{}).FieldByName(o.Field)
+		if !ok {
+			panic("unknown setting " + o.Field)
+		}
+		value := func(c *config.Settings) reflect.Value {
+			return reflect.ValueOf(c).Elem().FieldByIndex(sf.Index)
+		}
+
+		rs := runtimeSetting{
+			option: o.Name,
+			field:  o.Field,
+			get:    func(c *config.Settings) any { return value(c).Interface() },
+		}
+		switch sf.Type.Kind() {
+		case reflect.String:
+			rs.set = func(c *config.Settings, v string) error {
+				value(c).SetString(v)
+				return nil
+			}
+		case reflect.Int:
+			conv := convValueToInt
+			if o.AllowZero {
+				conv = strconv.Atoi
+			}
+			rs.set = func(c *config.Settings, v string) error {
+				ival, err := conv(v)
+				if err != nil {
+					return err
+				}
+				value(c).SetInt(int64(ival))
+				return nil
+			}
+		case reflect.Bool:
+			rs.set = func(c *config.Settings, v string) error {
+				bval, err := strconv.ParseBool(v)
+				if err != nil {
+					return err
+				}
+				value(c).SetBool(bval)
+				return nil
+			}
+		default:
+			panic("unsupported type of setting " + o.Field)
+		}
+		settings = append(settings, rs)
+	}
+	return settings
+}
@@ -2,7 +2,10 @@ package rpc
 
 import (
 	"fmt"
+	"io"
 	"net/http"
+	"runtime"
+	"time"
 
 	"github.com/moson-mo/goaurrpc/internal/consts"
 )
@@ -19,8 +22,6 @@ number of packages:		%d
 `
 
 func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
-	ip := getRealIP(r, s.conf.TrustedReverseProxies)
-	s.LogVeryVerbose("Client connected:", ip, "->", "["+r.Method+"]", r.URL)
 	w.Header().Add("Content-Type", consts.ContentTypeHtml)
 	s.mut.RLock()
 	defer s.mut.RUnlock()
@@ -28,3 +29,91 @@ func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
 	lr := s.lastRefresh.UTC().Format("2006-01-02 - 15:04:05 (UTC)")
 	fmt.Fprintf(w, statsHtml, s.ver, lr, np)
 }
+
+// collects runtime statistics
+func (s *server) getStats() ServerStats {
+	stats := ServerStats{
+		Version:    s.ver,
+		Started:    s.started.UTC(),
+		Uptime:     int64(time.Since(s.started).Seconds()),
+		IndexSizes: map[string]int{},
+		Goroutines: runtime.NumGoroutine(),
+		Upstream: UpstreamInfo{
+			Location: s.conf().AurFileLocation,
+		},
+	}
+
+	s.mut.RLock()
+	stats.LastRefresh = s.lastRefresh.UTC()
+	stats.Upstream.LastModified = s.lastRefresh.UTC()
+	if s.memDB != nil {
+		stats.Packages = len(s.memDB.PackageSlice)
+		for _, bases := range s.memDB.SuggestBases {
+			stats.PackageBases += len(bases)
+		}
+		stats.IndexSizes["PackageNames"] = len(s.memDB.PackageNames)
+		stats.IndexSizes["PackageDescriptions"] = len(s.memDB.PackageDescriptions)
+		stats.IndexSizes["References"] = len(s.memDB.References)
+		stats.IndexSizes["SuggestNames"] = len(s.memDB.SuggestNames)
+		stats.IndexSizes["SuggestBases"] = len(s.memDB.SuggestBases)
+		stats.Upstream.ETag = s.memDB.ETag
+	}
+	s.mut.RUnlock()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	stats.Memory = MemoryStats{
+		Alloc:       ms.Alloc,
+		TotalAlloc:  ms.TotalAlloc,
+		Sys:         ms.Sys,
+		HeapAlloc:   ms.HeapAlloc,
+		HeapInuse:   ms.HeapInuse,
+		HeapObjects: ms.HeapObjects,
+		NumGC:       ms.NumGC,
+	}
+
+	stats.RateLimits = s.rateLimitEntries()
+	stats.CacheEntries = s.cacheEntries()
+
+	s.mutReloads.Lock()
+	stats.Reloads = append([]ReloadResult{}, s.reloads...)
+	s.mutReloads.Unlock()
+
+	return stats
+}
+
+// writes statistics as "key: value" lines
+func writeStatsText(stats ServerStats, w io.Writer) {
+	ts := func(t time.Time) string {
+		return t.Format(time.RFC3339)
+	}
+	fmt.Fprintf(w, "version: %s\n", stats.Version)
+	fmt.Fprintf(w, "started: %s\n", ts(stats.Started))
+	fmt.Fprintf(w, "uptime: %d\n", stats.Uptime)
+	fmt.Fprintf(w, "last_refresh: %s\n", ts(stats.LastRefresh))
+	fmt.Fprintf(w, "packages: %d\n", stats.Packages)
+	fmt.Fprintf(w, "package_bases: %d\n", stats.PackageBases)
+	for _, idx := range []string{"PackageNames", "PackageDescriptions", "References", "SuggestNames", "SuggestBases"} {
+		fmt.Fprintf(w, "index_size.%s: %d\n", idx, stats.IndexSizes[idx])
+	}
+	fmt.Fprintf(w, "upstream_location: %s\n", stats.Upstream.Location)
+	fmt.Fprintf(w, "upstream_last_modified: %s\n", ts(stats.Upstream.LastModified))
+	fmt.Fprintf(w, "upstream_etag: %s\n", stats.Upstream.ETag)
+	fmt.Fprintf(w, "memory_alloc: %d\n", stats.Memory.Alloc)
+	fmt.Fprintf(w, "memory_total_alloc: %d\n", stats.Memory.TotalAlloc)
+	fmt.Fprintf(w, "memory_sys: %d\n", stats.Memory.Sys)
+	fmt.Fprintf(w, "memory_heap_alloc: %d\n", stats.Memory.HeapAlloc)
+	fmt.Fprintf(w, "memory_heap_inuse: %d\n", stats.Memory.HeapInuse)
+	fmt.Fprintf(w, "memory_heap_objects: %d\n", stats.Memory.HeapObjects)
+	fmt.Fprintf(w, "memory_num_gc: %d\n", stats.Memory.NumGC)
+	fmt.Fprintf(w, "goroutines: %d\n", stats.Goroutines)
+	fmt.Fprintf(w, "rate_limits: %d\n", stats.RateLimits)
+	fmt.Fprintf(w, "cache_entries: %d\n", stats.CacheEntries)
+	for i, rr := range stats.Reloads {
+		fmt.Fprintf(w, "reload.%d: %s %s %dms", i, ts(rr.Time), rr.Result, rr.Duration)
+		if rr.Error != "" {
+			fmt.Fprintf(w, " %s", rr.Error)
+		}
+		fmt.Fprintln(w)
+	}
+}
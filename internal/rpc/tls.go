@@ -0,0 +1,196 @@
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/moson-mo/goaurrpc/internal/config"
+)
+
+// certReloader provides our certificate to TLS handshakes and reloads it when the files have changed
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+
+	mut        sync.Mutex
+	modTime    time.Time // of the newest file when the certificate was loaded
+	errModTime time.Time // of the newest file when loading the certificate failed
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	return c, c.reload()
+}
+
+// loads our key pair. The current certificate stays in use if it can't be loaded
+func (c *certReloader) reload() error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.load()
+}
+
+// reloads our key pair if one of the files has been modified. Returns true if it has been reloaded.
+// An error is only returned once for each modification
+func (c *certReloader) reloadIfModified() (bool, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	modTime := c.newestModTime()
+	if modTime.Equal(c.modTime) || modTime.Equal(c.errModTime) {
+		return false, nil
+	}
+	if err := c.load(); err != nil {
+		c.errModTime = modTime
+		return false, err
+	}
+	return true, nil
+}
+
+// needs to be called with the lock held
+func (c *certReloader) load() error {
+	modTime := c.newestModTime()
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert.Store(&cert)
+	c.modTime = modTime
+	c.errModTime = time.Time{}
+	return nil
+}
+
+// returns the modification time of the newest file (zero if they can't be read)
+func (c *certReloader) newestModTime() time.Time {
+	var newest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}
+		}
+		if fi.ModTime().After(newest) {
+			newest = fi.ModTime()
+		}
+	}
+	return newest
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// reloads our certificate when we receive a signal or when the files have been modified
+func (s *server) watchCertificates(ctx context.Context, hup <-chan os.Signal, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.log.main.Debug("Stopping routine", "routine", "Certificate watcher")
+			return
+		case <-hup:
+			if err := s.certs.reload(); err != nil {
+				s.log.main.Error("Error reloading certificate", "error", err)
+			} else {
+				s.log.main.Info("Received SIGHUP. Reloaded certificate")
+			}
+		case <-ticker.C:
+			reloaded, err := s.certs.reloadIfModified()
+			if err != nil {
+				s.log.main.Error("Error reloading modified certificate", "error", err)
+			} else if reloaded {
+				s.log.main.Info("Certificate has been modified. Reloaded certificate")
+			}
+		}
+	}
+}
+
+// returns the TLS config for our listeners: the certificate is provided by our reloader.
+// Client certificates are verified (if given) when AdminClientCAFile is set; they are required for the admin API
+func newTLSConfig(conf *config.Settings, certs *certReloader) (*tls.Config, error) {
+	minVersion, err := config.ParseTLSVersion(conf.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	suites, err := config.ParseCipherSuites(conf.TLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   suites,
+		GetCertificate: certs.getCertificate,
+	}
+	if conf.AdminClientCAFile != "" {
+		if tc.ClientCAs, err = config.LoadCertPool(conf.AdminClientCAFile); err != nil {
+			return nil, err
+		}
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tc, nil
+}
+
+// loads our certificate and returns the TLS config for our listeners
+// and the listener that redirects to HTTPS (if HTTPRedirectPort is set). Nothing is returned without EnableSSL
+func (s *server) setupTLS(conf *config.Settings) (*tls.Config, net.Listener, error) {
+	if !conf.EnableSSL {
+		return nil, nil, nil
+	}
+	certs, err := newCertReloader(conf.CertFile, conf.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	tc, err := newTLSConfig(conf, certs)
+	if err != nil {
+		return nil, nil, err
+	}
+	var redirect net.Listener
+	if conf.HTTPRedirectPort > 0 {
+		if redirect, err = net.Listen("tcp", ":"+strconv.Itoa(conf.HTTPRedirectPort)); err != nil {
+			return nil, nil, err
+		}
+	}
+	s.certs = certs
+	return tc, redirect, nil
+}
+
+// checks if a request came with a client certificate that has been verified against AdminClientCAFile
+func hasClientCert(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+// redirects plain HTTP requests to HTTPS on port
+func redirectToHTTPS(port int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			// no port; IPv6 addresses are still enclosed in brackets
+			host = strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	}
+}
+
+// returns the port we redirect to: the one of our first TCP listener.
+// False is returned if we only listen on unix sockets
+func httpsPort(listeners []net.Listener) (int, bool) {
+	for _, l := range listeners {
+		if addr, ok := l.Addr().(*net.TCPAddr); ok {
+			return addr.Port, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,50 @@
+package rpc
+
+import (
+	"net/http"
+
+	"github.com/moson-mo/goaurrpc/internal/tracing"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+	Tracing (see internal/tracing): each /rpc and /api request gets a server span, continuing the trace of an incoming traceparent header.
+	handleRequest adds spans for its stages: validate, ratelimit, cache lookup, search / info / suggest, serialize and write.
+	Data reloads are traced with a reloadData span and download, decompress, decode and build index stages.
+*/
+
+var tracer = tracing.Tracer("github.com/moson-mo/goaurrpc/internal/rpc")
+
+// traceMiddleware starts a span for each request
+func (s *server) traceMiddleware(hf http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.Propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		route := r.URL.Path
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("user_agent.original", r.UserAgent()),
+			))
+		defer span.End()
+
+		cw := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+		hf(cw, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", cw.status))
+		if cw.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(cw.status))
+		}
+	}
+}
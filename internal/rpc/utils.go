@@ -1,6 +1,7 @@
 package rpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -11,9 +12,9 @@ import (
 
 	"github.com/moson-mo/goaurrpc/internal/consts"
 	db "github.com/moson-mo/goaurrpc/internal/memdb"
-	"github.com/moson-mo/goaurrpc/internal/metrics"
 
 	"github.com/goccy/go-json"
+	"go.opentelemetry.io/otel/attribute"
 	"gopkg.in/guregu/null.v4"
 )
 
@@ -57,6 +58,13 @@ var queryMode = []string{
 
 var ErrCallBack = errors.New("Invalid callback name.")
 
+// errors for requests exceeding our limits
+var (
+	ErrBodyTooLarge = errors.New("Request body too large.")
+	ErrTooManyArgs  = errors.New("Too many arguments.")
+	ErrArgTooLong   = errors.New("Query arg too long.")
+)
+
 // Checking the validity of the query parameters
 func validateParameters(params url.Values) error {
 	arg, hasArg := params["arg"]
@@ -105,6 +113,23 @@ func validateParameters(params url.Values) error {
 	return nil
 }
 
+// Checking the number and length of arguments (0 = unlimited)
+func validateLimits(params url.Values, maxArgs, maxArgLength int) error {
+	if maxArgs > 0 && len(params["arg"])+len(params["arg[]"]) > maxArgs {
+		return ErrTooManyArgs
+	}
+	if maxArgLength > 0 {
+		for _, key := range []string{"arg", "arg[]"} {
+			for _, arg := range params[key] {
+				if len(arg) > maxArgLength {
+					return ErrArgTooLong
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // get a string slice with all arguments that have been passed
 func getArgsList(params url.Values) []string {
 	var args []string
@@ -155,7 +180,7 @@ func getBy(params url.Values) string {
 }
 
 // generate JSON error and return to client
-func writeError(code int, message string, version int, callback string, w http.ResponseWriter) {
+func (s *server) writeError(code int, message string, version int, callback string, w http.ResponseWriter) {
 	e := RpcResult{
 		Error:   message,
 		Type:    "error",
@@ -168,21 +193,28 @@ func writeError(code int, message string, version int, callback string, w http.R
 	sendResult(code, callback, b, w)
 
 	// update request errors metric
-	metrics.RequestErrors.WithLabelValues(e.Error).Inc()
+	s.metrics.RequestErrors.WithLabelValues(e.Error).Inc()
 }
 
-// generate JSON string from RpcResult and return to client
-func writeResult(result *RpcResult, callback string, w http.ResponseWriter) {
+// generate JSON string from RpcResult and return to client. Returns the size of the JSON data
+func (s *server) writeResult(ctx context.Context, result *RpcResult, callback string, w http.ResponseWriter) int {
 	// set number of records
 	if result.Resultcount == 0 {
 		result.Results = make([]interface{}, 0)
 	}
+	_, span := tracer.Start(ctx, "serialize")
 	b, _ := json.Marshal(result)
+	span.SetAttributes(attribute.Int("size", len(b)))
+	span.End()
 
+	_, span = tracer.Start(ctx, "write")
 	sendResult(200, callback, b, w)
+	span.End()
 
 	// update request size metrics
-	metrics.ResponseSize.WithLabelValues(result.Type).Observe(float64(len(b)))
+	s.metrics.ResponseSize.WithLabelValues(result.Type).Observe(float64(len(b)))
+
+	return len(b)
 }
 
 // sends data to client
@@ -0,0 +1,27 @@
+package systemd
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+	Support for running as a systemd service without depending on libsystemd:
+	- Socket activation: listening sockets passed by systemd (LISTEN_PID, LISTEN_FDS, LISTEN_FDNAMES).
+	- sd_notify: READY=1, STOPPING=1 and WATCHDOG=1 messages sent to NOTIFY_SOCKET (Type=notify services).
+	Everything is a no-op if we have not been started by systemd or on platforms other than unix.
+*/
+
+// WatchdogInterval returns the interval in which we need to send WATCHDOG=1 (half of WatchdogSec).
+// Returns 0 if the watchdog is disabled
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package systemd
+
+import "net"
+
+// ListenersWithNames returns nil; socket activation is not supported on this platform
+func ListenersWithNames() (map[string][]net.Listener, error) {
+	return nil, nil
+}
+
+// Notify does nothing; sd_notify is not supported on this platform
+func Notify(state string) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,94 @@
+//go:build unix
+
+package systemd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// first file descriptor passed by systemd (SD_LISTEN_FDS_START)
+const listenFdsStart = 3
+
+// ListenersWithNames returns the sockets passed by systemd (socket activation), grouped by their name (FileDescriptorName=).
+// Returns nil if there are none. The environment variables are unset, so child processes don't inherit them
+func ListenersWithNames() (map[string][]net.Listener, error) {
+	defer unsetListenEnv()
+	list, names, err := listeners(listenFdsStart)
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	named := make(map[string][]net.Listener)
+	for i, l := range list {
+		named[names[i]] = append(named[names[i]], l)
+	}
+	return named, nil
+}
+
+func unsetListenEnv() {
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+}
+
+// returns the sockets starting at fd start and their names
+func listeners(start int) ([]net.Listener, []string, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil, nil
+	}
+	fdNames := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	var list []net.Listener
+	var names []string
+	for fd := start; fd < start+n; fd++ {
+		syscall.CloseOnExec(fd)
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i := fd - start; i < len(fdNames) && fdNames[i] != "" {
+			name = fdNames[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range list {
+				l.Close()
+			}
+			return nil, nil, errors.New("systemd: socket " + name + ": " + err.Error())
+		}
+		list = append(list, l)
+		names = append(names, name)
+	}
+	return list, names, nil
+}
+
+// Notify sends a state like "READY=1" to systemd.
+// Returns false if we are not running as a notify service
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// abstract sockets start with @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
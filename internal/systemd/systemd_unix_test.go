@@ -0,0 +1,76 @@
+//go:build unix
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListeners(t *testing.T) {
+	// not started by systemd
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+	named, err := ListenersWithNames()
+	assert.Nil(t, err)
+	assert.Nil(t, named)
+
+	// sockets passed for another process
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	list, _, err := listeners(listenFdsStart)
+	assert.Nil(t, err)
+	assert.Nil(t, list)
+
+	// a TCP socket
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	f, err := l.(*net.TCPListener).File()
+	assert.Nil(t, err)
+	l.Close()
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "admin")
+	list, names, err := listeners(int(f.Fd()))
+	assert.Nil(t, err)
+	assert.Len(t, list, 1)
+	assert.Equal(t, []string{"admin"}, names)
+	assert.Equal(t, l.Addr().String(), list[0].Addr().String())
+	conn, err := net.Dial("tcp", list[0].Addr().String())
+	assert.Nil(t, err)
+	conn.Close()
+	list[0].Close()
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	ok, err := Notify("READY=1")
+	assert.False(t, ok)
+	assert.Nil(t, err)
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	assert.Nil(t, err)
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	ok, err = Notify("READY=1")
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "READY=1", string(buf[:n]))
+
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+	ok, err = Notify("READY=1")
+	assert.False(t, ok)
+	assert.NotNil(t, err)
+}
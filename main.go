@@ -3,10 +3,17 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
 
+	"github.com/moson-mo/goaurrpc/internal/bench"
 	"github.com/moson-mo/goaurrpc/internal/config"
+	"github.com/moson-mo/goaurrpc/internal/ctl"
+	"github.com/moson-mo/goaurrpc/internal/query"
 	"github.com/moson-mo/goaurrpc/internal/rpc"
+
+	"github.com/goccy/go-json"
 )
 
 /*
@@ -16,39 +23,108 @@ import (
 
 var version = "v1.2.2"
 
+// subcommands return an exit code
+var subcommands = map[string]func(args []string, stdout, stderr io.Writer) int{
+	"bench": bench.Run,
+	"ctl":   ctl.Run,
+	"query": query.Run,
+}
+
 func main() {
-	var settings *config.Settings
+	// subcommands
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(run(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
 
 	// args
 	cfile := flag.String("c", "", "Config file")
-	verbose := flag.Bool("v", false, "Verbose")
-	vverbose := flag.Bool("vv", false, "Very verbose")
+	verbose := flag.Bool("v", false, "Verbose; same as -log-level debug")
+	vverbose := flag.Bool("vv", false, "Very verbose; same as -log-level trace")
+	printConfig := flag.Bool("print-config", false, "Print the effective config (secrets redacted) and exit")
+	checkConfig := flag.Bool("check-config", false, "Check the effective config for problems and exit. Exits with 1 on errors")
+	writeDefault := flag.String("write-default-config", "", "Print a sample config with default settings in the given `format` (json, yaml or toml) and exit")
+	flags := config.RegisterFlags(flag.CommandLine)
 
 	flag.Parse()
 
-	if *vverbose {
-		*verbose = true
+	// -v and -vv are shortcuts for our log level. Setting the flag keeps them in effect when the config is reloaded.
+	// An explicit -log-level takes precedence
+	logLevelSet := false
+	flag.Visit(func(f *flag.Flag) {
+		logLevelSet = logLevelSet || f.Name == config.FlagName("LogLevel")
+	})
+	if *vverbose && !logLevelSet {
+		flag.Set(config.FlagName("LogLevel"), "trace")
+	} else if *verbose && !logLevelSet {
+		flag.Set(config.FlagName("LogLevel"), "debug")
+	}
+
+	if *writeDefault != "" {
+		format, err := config.ParseFormat(*writeDefault)
+		if err != nil {
+			panic(err)
+		}
+		if err = config.WriteSample(os.Stdout, format); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if *checkConfig {
+		os.Exit(runConfigCheck(*cfile, flags))
 	}
 
-	// set configuration data
-	if *cfile == "" {
-		settings = config.DefaultSettings()
-	} else {
-		var err error
-		settings, err = config.LoadFromFile(*cfile)
+	// set configuration data: defaults < config file < environment < flags
+	settings, err := config.Load(*cfile, flags)
+	if err != nil {
+		panic("Error loading config: " + err.Error())
+	}
+
+	if *printConfig {
+		b, err := json.MarshalIndent(settings.Redact(), "", "\t")
 		if err != nil {
-			panic("Error loading config file: " + err.Error())
+			panic(err)
 		}
+		fmt.Println(string(b))
+		return
 	}
 
 	// construct new server and start listening for requests
 	fmt.Printf("goaurrpc %s is starting...\n\n", version)
-	s, err := rpc.New(*settings, *verbose, *vverbose, version)
+	s, err := rpc.New(*settings, version)
 	if err != nil {
 		panic(err)
 	}
+	s.SetConfigSource(*cfile, flags)
 	if err = s.Listen(); err != http.ErrServerClosed {
 		fmt.Println(err)
 	}
 	fmt.Printf("goaurrpc %s stopped.\n", version)
 }
+
+// checks our config and prints all problems. Returns the exit code
+func runConfigCheck(path string, flags *config.Flags) int {
+	settings, err := config.Read(path, flags)
+	if err != nil {
+		fmt.Printf("error:   %s\n", err)
+		return 1
+	}
+
+	errors, warnings := 0, 0
+	for _, p := range append(settings.Check(), settings.CheckFile(path)...) {
+		fmt.Println(p)
+		if p.Severity == config.SeverityError {
+			errors++
+		} else {
+			warnings++
+		}
+	}
+	fmt.Printf("\nConfig check: %d error(s), %d warning(s)\n", errors, warnings)
+
+	if errors > 0 {
+		return 1
+	}
+	return 0
+}